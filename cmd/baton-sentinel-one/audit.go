@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/conductorone/baton-sentinel-one/pkg/connector"
+)
+
+const (
+	formatJSONL = "jsonl"
+	formatCSV   = "csv"
+)
+
+var auditCSVHeader = []string{
+	"console", "id", "timestamp", "activity_type", "action", "actor_id", "actor_name", "target_type", "target_id", "target_name",
+	"scope", "scope_id", "scope_name", "role_before", "role_after", "description",
+}
+
+// auditExportCmd returns the command exporting the user, service user, role and api token changes from the activities log.
+func auditExportCmd(ctx context.Context, cfg *config) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:           "audit-export",
+		Short:         "Export who changed which user, service user, role or api token and when from the activities log",
+		SilenceErrors: true,
+		SilenceUsage:  true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := loadConfig(cmd, cfg); err != nil {
+				return err
+			}
+
+			format, err := cmd.Flags().GetString("format")
+			if err != nil {
+				return err
+			}
+			if format != formatJSONL && format != formatCSV {
+				return fmt.Errorf("format must be %s or %s", formatJSONL, formatCSV)
+			}
+
+			cursorFile, err := cmd.Flags().GetString("cursor-file")
+			if err != nil {
+				return err
+			}
+
+			progress := &connector.AuditCursor{}
+			if cursorFile != "" {
+				progress, err = connector.LoadAuditCursor(cursorFile)
+				if err != nil {
+					return err
+				}
+			}
+
+			r, err := auditRange(cmd, progress, time.Now())
+			if err != nil {
+				return err
+			}
+
+			if err := r.Validate(); err != nil {
+				return err
+			}
+
+			if err := validateConfig(ctx, cfg); err != nil {
+				return err
+			}
+
+			consoles, err := managementConsoles(cfg)
+			if err != nil {
+				return err
+			}
+
+			c, err := connector.NewReadOnly(ctx, consoles, connectorOptions(cfg)...)
+			if err != nil {
+				return err
+			}
+
+			path, err := cmd.Flags().GetString("file")
+			if err != nil {
+				return err
+			}
+
+			// an export continued from a cursor file appends to the events it already wrote.
+			out, empty, err := openAuditFile(cmd.OutOrStdout(), path, cursorFile != "")
+			if err != nil {
+				return err
+			}
+			defer out.Close()
+
+			if progress.Start(r) {
+				fmt.Fprintf(os.Stderr, "Resuming the export from %s to %s\n", r.Since.Format(time.RFC3339), r.Until.Format(time.RFC3339))
+			}
+
+			w := newAuditWriter(out, format, empty)
+			count := 0
+			err = c.ExportAudit(ctx, progress, func(events []connector.AuditEvent, progress *connector.AuditCursor) error {
+				for _, event := range events {
+					if err := w.write(event); err != nil {
+						return err
+					}
+				}
+				if err := w.flush(); err != nil {
+					return err
+				}
+				count += len(events)
+
+				if cursorFile == "" {
+					return nil
+				}
+				return connector.SaveAuditCursor(cursorFile, progress)
+			})
+			if err != nil {
+				return err
+			}
+
+			fmt.Fprintf(os.Stderr, "Exported %d events from %s to %s\n", count, r.Since.Format(time.RFC3339), r.Until.Format(time.RFC3339))
+
+			return nil
+		},
+	}
+
+	cmd.Flags().String("since", "", "Start of the time range, as RFC 3339 or a duration before now, e.g. 720h. Defaults to the end of the last export of --cursor-file.")
+	cmd.Flags().String("until", "", "End of the time range, as RFC 3339 or a duration before now. Defaults to now.")
+	cmd.Flags().IntSlice("activity-types", nil, "Activity types to export. Defaults to the creations, changes and deletions of users, service users, roles and api tokens.")
+	cmd.Flags().String("format", formatJSONL, "Output format: jsonl, csv")
+	cmd.Flags().String("file", "", "Write the events to this file instead of stdout.")
+	cmd.Flags().String("cursor-file", "", "Keep the position of the export in this file, an interrupted export resumes where it stopped and the next one starts where the last one ended.")
+
+	return cmd
+}
+
+// auditRange returns the time range to export. Without a range in the flags, an interrupted export is resumed,
+// otherwise the export starts where the last one ended.
+func auditRange(cmd *cobra.Command, progress *connector.AuditCursor, now time.Time) (connector.AuditRange, error) {
+	flags := cmd.Flags()
+	if progress.Range != nil && !flags.Changed("since") && !flags.Changed("until") && !flags.Changed("activity-types") {
+		return *progress.Range, nil
+	}
+
+	var rv connector.AuditRange
+
+	since, err := flags.GetString("since")
+	if err != nil {
+		return rv, err
+	}
+	switch {
+	case since != "":
+		if rv.Since, err = parseAuditTime(since, now); err != nil {
+			return rv, err
+		}
+	case progress.ExportedUntil != nil:
+		rv.Since = *progress.ExportedUntil
+	default:
+		return rv, fmt.Errorf("audit export requires --since, or a --cursor-file of an earlier export")
+	}
+
+	rv.Until = now
+	until, err := flags.GetString("until")
+	if err != nil {
+		return rv, err
+	}
+	if until != "" {
+		if rv.Until, err = parseAuditTime(until, now); err != nil {
+			return rv, err
+		}
+	}
+
+	rv.ActivityTypes, err = flags.GetIntSlice("activity-types")
+	if err != nil {
+		return rv, err
+	}
+
+	return rv, nil
+}
+
+// parseAuditTime parses a time given as RFC 3339 or as a duration before now.
+func parseAuditTime(value string, now time.Time) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("time %q must be RFC 3339 or a duration, e.g. 720h", value)
+	}
+
+	return now.Add(-d), nil
+}
+
+// openAuditFile opens the output of the export and reports whether it is empty, stdout when there is no path.
+func openAuditFile(stdout io.Writer, path string, appendTo bool) (io.WriteCloser, bool, error) {
+	if path == "" {
+		return nopCloser{stdout}, true, nil
+	}
+
+	flag := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if appendTo {
+		flag = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	}
+
+	f, err := os.OpenFile(path, flag, 0o600)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to open audit export file: %w", err)
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, false, err
+	}
+
+	return f, info.Size() == 0, nil
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
+
+// auditWriter writes the events as JSON lines or CSV rows.
+type auditWriter struct {
+	buf    *bufio.Writer
+	enc    *json.Encoder
+	csv    *csv.Writer
+	header bool
+}
+
+func newAuditWriter(out io.Writer, format string, empty bool) *auditWriter {
+	buf := bufio.NewWriter(out)
+	if format == formatCSV {
+		return &auditWriter{buf: buf, csv: csv.NewWriter(buf), header: empty}
+	}
+
+	return &auditWriter{buf: buf, enc: json.NewEncoder(buf)}
+}
+
+func (w *auditWriter) write(event connector.AuditEvent) error {
+	if w.enc != nil {
+		return w.enc.Encode(event)
+	}
+
+	if w.header {
+		if err := w.csv.Write(auditCSVHeader); err != nil {
+			return err
+		}
+		w.header = false
+	}
+
+	return w.csv.Write([]string{
+		event.Console, event.ID, event.Timestamp, strconv.Itoa(event.ActivityType), event.Action, event.ActorID, event.ActorName,
+		event.TargetType, event.TargetID, event.TargetName, event.Scope, event.ScopeID, event.ScopeName,
+		event.RoleBefore, event.RoleAfter, event.Description,
+	})
+}
+
+// flush writes the buffered events, before the cursor moves past them.
+func (w *auditWriter) flush() error {
+	if w.csv != nil {
+		w.csv.Flush()
+		if err := w.csv.Error(); err != nil {
+			return err
+		}
+	}
+
+	return w.buf.Flush()
+}
@@ -3,34 +3,283 @@ package main
 import (
 	"context"
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/conductorone/baton-sdk/pkg/cli"
 	"github.com/spf13/cobra"
+
+	"github.com/conductorone/baton-sentinel-one/pkg/connector"
 )
 
 // config defines the external configuration required for the connector to run.
 type config struct {
 	cli.BaseConfig `mapstructure:",squash"` // Puts the base config options in the same place as the connector options
 
-	Token         string `mapstructure:"api-token"`
-	ManagementUrl string `mapstructure:"management-console-url"`
+	Tokens        []string `mapstructure:"api-token"`
+	TokenFiles    []string `mapstructure:"api-token-file"`
+	ManagementUrl string   `mapstructure:"management-console-url"`
+	APIVersion    string   `mapstructure:"api-version"`
+	DryRun        bool     `mapstructure:"dry-run"`
+
+	Consoles          []string `mapstructure:"consoles"`
+	ConsoleTokens     []string `mapstructure:"console-api-tokens"`
+	ConsoleTokenFiles []string `mapstructure:"console-api-token-files"`
+	RequestsPerSecond int      `mapstructure:"requests-per-second"`
+
+	GuardrailTokenOwner bool     `mapstructure:"guardrail-token-owner"`
+	GuardrailLastAdmin  bool     `mapstructure:"guardrail-last-admin"`
+	AllowedAccountIDs   []string `mapstructure:"allowed-account-ids"`
+	AllowedSiteIDs      []string `mapstructure:"allowed-site-ids"`
+
+	IncludeAccountIDs   []string `mapstructure:"include-account-ids"`
+	ExcludeAccountIDs   []string `mapstructure:"exclude-account-ids"`
+	IncludeSiteIDs      []string `mapstructure:"include-site-ids"`
+	ExcludeSiteIDs      []string `mapstructure:"exclude-site-ids"`
+	IncludeAccountNames []string `mapstructure:"include-account-names"`
+	ExcludeAccountNames []string `mapstructure:"exclude-account-names"`
+	IncludeSiteNames    []string `mapstructure:"include-site-names"`
+	ExcludeSiteNames    []string `mapstructure:"exclude-site-names"`
+
+	DisabledResourceTypes []string `mapstructure:"disabled-resource-types"`
+
+	ProxyURL      string `mapstructure:"proxy-url"`
+	CABundle      string `mapstructure:"ca-bundle"`
+	ClientCert    string `mapstructure:"client-cert"`
+	ClientKey     string `mapstructure:"client-key"`
+	MinTLSVersion string `mapstructure:"min-tls-version"`
+	LogHashPII    bool   `mapstructure:"log-hash-pii"`
+
+	MetricsListenAddress string `mapstructure:"metrics-listen-address"`
+	OTLPEndpoint         string `mapstructure:"otlp-endpoint"`
+	OTLPInsecure         bool   `mapstructure:"otlp-insecure"`
+
+	ScopeFailureMode  string `mapstructure:"scope-failure-mode"`
+	ScopeRetries      int    `mapstructure:"scope-retries"`
+	SkippedScopesFile string `mapstructure:"skipped-scopes-file"`
+	GrantWorkers      int    `mapstructure:"grant-workers"`
+
+	StrictDecode    bool   `mapstructure:"strict-decode"`
+	SchemaDriftFile string `mapstructure:"schema-drift-file"`
+
+	IncrementalStateFile string        `mapstructure:"incremental-state-file"`
+	FullSyncInterval     time.Duration `mapstructure:"full-sync-interval"`
+	IncrementalOverlap   time.Duration `mapstructure:"incremental-overlap"`
+
+	ActivityLookback   time.Duration `mapstructure:"activity-lookback"`
+	LoginActivityTypes []int         `mapstructure:"login-activity-types"`
+	APIActivityTypes   []int         `mapstructure:"api-activity-types"`
 }
 
 // validateConfig is run after the configuration is loaded, and should return an error if it isn't valid.
 func validateConfig(ctx context.Context, cfg *config) error {
-	if cfg.Token == "" {
-		return fmt.Errorf("api token must be provided")
+	if len(cfg.Consoles) > 0 {
+		if len(cfg.Tokens) > 0 || len(cfg.TokenFiles) > 0 || cfg.ManagementUrl != "" {
+			return fmt.Errorf("consoles can't be combined with api token, api token file and management console url")
+		}
+	} else {
+		if len(cfg.Tokens) == 0 && len(cfg.TokenFiles) == 0 {
+			return fmt.Errorf("api token or api token file must be provided")
+		}
+
+		if cfg.ManagementUrl == "" {
+			return fmt.Errorf("management console url must be provided")
+		}
+	}
+
+	if err := connector.ValidateAPIVersion(cfg.APIVersion); err != nil {
+		return err
+	}
+
+	if cfg.RequestsPerSecond < 0 {
+		return fmt.Errorf("requests per second must not be negative")
+	}
+
+	consoles, err := managementConsoles(cfg)
+	if err != nil {
+		return err
+	}
+
+	if err := connector.ValidateConsoles(consoles); err != nil {
+		return err
+	}
+
+	if err := scopeFilter(cfg).Validate(); err != nil {
+		return err
+	}
+
+	if err := connector.ValidateResourceTypeIDs(cfg.DisabledResourceTypes); err != nil {
+		return err
+	}
+
+	if err := scopeFailures(cfg).Validate(); err != nil {
+		return err
+	}
+
+	if cfg.GrantWorkers < 0 {
+		return fmt.Errorf("grant workers must not be negative")
+	}
+
+	if cfg.SchemaDriftFile != "" && !cfg.StrictDecode {
+		return fmt.Errorf("schema drift file requires strict decode")
+	}
+
+	if err := incrementalSync(cfg).Validate(); err != nil {
+		return err
+	}
+
+	if err := activity(cfg).Validate(); err != nil {
+		return err
 	}
 
-	if cfg.ManagementUrl == "" {
-		return fmt.Errorf("management console url must be provided")
+	if err := httpOptions(cfg).Validate(); err != nil {
+		return err
 	}
 
 	return nil
 }
 
+// managementConsoles returns the consoles to sync: the "name=url" consoles with their "name=token" tokens and "name=path" token files,
+// or a single unnamed console built from the management console url, api tokens and api token files.
+// A console may be given several tokens, e.g. one per account.
+func managementConsoles(cfg *config) ([]connector.Console, error) {
+	if len(cfg.Consoles) == 0 {
+		return []connector.Console{{URL: cfg.ManagementUrl, Tokens: cfg.Tokens, TokenFiles: cfg.TokenFiles}}, nil
+	}
+
+	tokens := make(map[string][]string, len(cfg.ConsoleTokens))
+	for _, pair := range cfg.ConsoleTokens {
+		name, token, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("console api token must have the form name=token")
+		}
+
+		tokens[name] = append(tokens[name], token)
+	}
+
+	tokenFiles := make(map[string][]string, len(cfg.ConsoleTokenFiles))
+	for _, pair := range cfg.ConsoleTokenFiles {
+		name, path, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("console api token file must have the form name=path")
+		}
+
+		tokenFiles[name] = append(tokenFiles[name], path)
+	}
+
+	var rv []connector.Console
+	for _, pair := range cfg.Consoles {
+		name, consoleUrl, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("console %q must have the form name=url", pair)
+		}
+
+		rv = append(rv, connector.Console{Name: name, URL: consoleUrl, Tokens: tokens[name], TokenFiles: tokenFiles[name]})
+		delete(tokens, name)
+		delete(tokenFiles, name)
+	}
+
+	for name := range tokens {
+		return nil, fmt.Errorf("api token given for unknown management console %q", name)
+	}
+
+	for name := range tokenFiles {
+		return nil, fmt.Errorf("api token file given for unknown management console %q", name)
+	}
+
+	return rv, nil
+}
+
+func scopeFilter(cfg *config) connector.ScopeFilter {
+	return connector.ScopeFilter{
+		IncludeAccountIDs:   cfg.IncludeAccountIDs,
+		ExcludeAccountIDs:   cfg.ExcludeAccountIDs,
+		IncludeSiteIDs:      cfg.IncludeSiteIDs,
+		ExcludeSiteIDs:      cfg.ExcludeSiteIDs,
+		IncludeAccountNames: cfg.IncludeAccountNames,
+		ExcludeAccountNames: cfg.ExcludeAccountNames,
+		IncludeSiteNames:    cfg.IncludeSiteNames,
+		ExcludeSiteNames:    cfg.ExcludeSiteNames,
+	}
+}
+
+func scopeFailures(cfg *config) connector.ScopeFailures {
+	return connector.ScopeFailures{
+		Mode:       cfg.ScopeFailureMode,
+		Retries:    cfg.ScopeRetries,
+		ReportPath: cfg.SkippedScopesFile,
+	}
+}
+
+func incrementalSync(cfg *config) connector.IncrementalSync {
+	return connector.IncrementalSync{
+		StatePath:        cfg.IncrementalStateFile,
+		FullSyncInterval: cfg.FullSyncInterval,
+		Overlap:          cfg.IncrementalOverlap,
+	}
+}
+
+func activity(cfg *config) connector.Activity {
+	return connector.Activity{
+		Lookback:   cfg.ActivityLookback,
+		LoginTypes: cfg.LoginActivityTypes,
+		APITypes:   cfg.APIActivityTypes,
+	}
+}
+
+func httpOptions(cfg *config) connector.HTTPOptions {
+	return connector.HTTPOptions{
+		ProxyURL:      cfg.ProxyURL,
+		CABundle:      cfg.CABundle,
+		ClientCert:    cfg.ClientCert,
+		ClientKey:     cfg.ClientKey,
+		MinTLSVersion: cfg.MinTLSVersion,
+	}
+}
+
 // cmdFlags sets the cmdFlags required for the connector.
 func cmdFlags(cmd *cobra.Command) {
-	cmd.PersistentFlags().String("api-token", "", "API token for your management console used to authenticate with SentinelOne API. ($BATON_API_TOKEN)")
-	cmd.PersistentFlags().String("management-console-url", "", "Your management console url. ($BATON_MANAGEMENT_CONSOLE_URL)")
+	cmd.PersistentFlags().StringSlice("api-token", nil, "API token for your management console used to authenticate with SentinelOne API, several account or site scope tokens are merged. ($BATON_API_TOKEN)")
+	cmd.PersistentFlags().StringSlice("api-token-file", nil, "File holding an API token, re-read when it changes, e.g. a mounted secret. May be combined with --api-token. ($BATON_API_TOKEN_FILE)")
+	cmd.PersistentFlags().String("management-console-url", "", "Your management console url, e.g. https://example.sentinelone.net. ($BATON_MANAGEMENT_CONSOLE_URL)")
+	cmd.PersistentFlags().String("api-version", connector.DefaultAPIVersion, "Version of the management console API. ($BATON_API_VERSION)")
+	cmd.PersistentFlags().StringSlice("consoles", nil, "Sync several management consoles, as name=url pairs. Replaces --management-console-url. ($BATON_CONSOLES)")
+	cmd.PersistentFlags().StringSlice("console-api-tokens", nil, "API tokens of the consoles, as name=token pairs, a name may repeat for several tokens. Replaces --api-token. ($BATON_CONSOLE_API_TOKENS)")
+	cmd.PersistentFlags().StringSlice("console-api-token-files", nil, "API token files of the consoles, as name=path pairs, a name may repeat for several files. Replaces --api-token-file. ($BATON_CONSOLE_API_TOKEN_FILES)")
+	cmd.PersistentFlags().Int("requests-per-second", 20, "Maximum requests per second sent to each management console, 0 for no limit. ($BATON_REQUESTS_PER_SECOND)")
+	cmd.PersistentFlags().String("proxy-url", "", "Send every request through this proxy, e.g. http://proxy:3128. Defaults to the proxy environment variables. ($BATON_PROXY_URL)")
+	cmd.PersistentFlags().String("ca-bundle", "", "PEM file of CA certificates trusted in addition to the system ones, e.g. for on-premises consoles or a TLS-inspecting proxy. ($BATON_CA_BUNDLE)")
+	cmd.PersistentFlags().String("client-cert", "", "PEM file of the client certificate presented for mutual TLS. ($BATON_CLIENT_CERT)")
+	cmd.PersistentFlags().String("client-key", "", "PEM file of the key of the client certificate. ($BATON_CLIENT_KEY)")
+	cmd.PersistentFlags().String("min-tls-version", "1.2", "Lowest accepted TLS version: 1.0, 1.1, 1.2, 1.3. ($BATON_MIN_TLS_VERSION)")
+	cmd.PersistentFlags().Bool("log-hash-pii", false, "Replace emails and names in the logged API requests by a hash. API tokens are never logged. ($BATON_LOG_HASH_PII)")
+	cmd.PersistentFlags().String("metrics-listen-address", "", "Serve metrics of the SentinelOne API requests in the Prometheus format on /metrics of this address, e.g. :9090. ($BATON_METRICS_LISTEN_ADDRESS)")
+	cmd.PersistentFlags().String("otlp-endpoint", "", "Export traces of the sync and the SentinelOne API requests to this OTLP gRPC collector, e.g. localhost:4317. ($BATON_OTLP_ENDPOINT)")
+	cmd.PersistentFlags().Bool("otlp-insecure", false, "Connect to the OTLP collector without TLS. ($BATON_OTLP_INSECURE)")
+	cmd.PersistentFlags().Bool("dry-run", false, "Log the SentinelOne API calls provisioning would make without sending them. ($BATON_DRY_RUN)")
+	cmd.PersistentFlags().Bool("guardrail-token-owner", true, "Refuse provisioning changes to the identity that owns the API token. ($BATON_GUARDRAIL_TOKEN_OWNER)")
+	cmd.PersistentFlags().Bool("guardrail-last-admin", true, "Refuse revoking the API token or resetting the two-factor enrollment of the only Admin of a tenant, account or site. ($BATON_GUARDRAIL_LAST_ADMIN)")
+	cmd.PersistentFlags().StringSlice("allowed-account-ids", nil, "Only allow provisioning changes to principals in these accounts and their sites. ($BATON_ALLOWED_ACCOUNT_IDS)")
+	cmd.PersistentFlags().StringSlice("allowed-site-ids", nil, "Only allow provisioning changes to principals in these sites. ($BATON_ALLOWED_SITE_IDS)")
+	cmd.PersistentFlags().StringSlice("include-account-ids", nil, "Only sync these accounts. ($BATON_INCLUDE_ACCOUNT_IDS)")
+	cmd.PersistentFlags().StringSlice("exclude-account-ids", nil, "Do not sync these accounts. ($BATON_EXCLUDE_ACCOUNT_IDS)")
+	cmd.PersistentFlags().StringSlice("include-site-ids", nil, "Only sync these sites. ($BATON_INCLUDE_SITE_IDS)")
+	cmd.PersistentFlags().StringSlice("exclude-site-ids", nil, "Do not sync these sites. ($BATON_EXCLUDE_SITE_IDS)")
+	cmd.PersistentFlags().StringSlice("include-account-names", nil, "Only sync accounts whose name matches one of these patterns, e.g. 'Acme*'. ($BATON_INCLUDE_ACCOUNT_NAMES)")
+	cmd.PersistentFlags().StringSlice("exclude-account-names", nil, "Do not sync accounts whose name matches one of these patterns. ($BATON_EXCLUDE_ACCOUNT_NAMES)")
+	cmd.PersistentFlags().StringSlice("include-site-names", nil, "Only sync sites whose name matches one of these patterns. ($BATON_INCLUDE_SITE_NAMES)")
+	cmd.PersistentFlags().StringSlice("exclude-site-names", nil, "Do not sync sites whose name matches one of these patterns. ($BATON_EXCLUDE_SITE_NAMES)")
+	cmd.PersistentFlags().StringSlice("disabled-resource-types", nil, "Resource types not to sync: account, site, user, service_user, role. ($BATON_DISABLED_RESOURCE_TYPES)")
+	cmd.PersistentFlags().String("scope-failure-mode", connector.ScopeFailureSkip, "What to do when the grants of an account or site can't be listed after the retries: skip, fail. ($BATON_SCOPE_FAILURE_MODE)")
+	cmd.PersistentFlags().Int("scope-retries", connector.DefaultScopeFailures().Retries, "Retries of server errors when listing the grants of an account or site. ($BATON_SCOPE_RETRIES)")
+	cmd.PersistentFlags().String("skipped-scopes-file", "", "Write the accounts and sites skipped by the sync and why to this JSON file. ($BATON_SKIPPED_SCOPES_FILE)")
+	cmd.PersistentFlags().Int("grant-workers", 0, "Fetch the grants of the accounts and sites the sync reaches next with this many concurrent requests, within --requests-per-second. 0 fetches them one at a time. ($BATON_GRANT_WORKERS)")
+	cmd.PersistentFlags().Bool("strict-decode", false, "Compare the API responses with the fields the connector expects and warn about unknown, missing and mistyped fields. ($BATON_STRICT_DECODE)")
+	cmd.PersistentFlags().String("schema-drift-file", "", "Write the fields found by --strict-decode to this JSON file and list them when the sync ends. ($BATON_SCHEMA_DRIFT_FILE)")
+	cmd.PersistentFlags().String("incremental-state-file", "", "Keep a snapshot of the sync in this file and only fetch the accounts, sites, users and service users changed since the last successful sync. ($BATON_INCREMENTAL_STATE_FILE)")
+	cmd.PersistentFlags().Duration("full-sync-interval", 24*time.Hour, "Run a full sync when the last one is older than this, with --incremental-state-file. 0 only runs one when the snapshot can't be used. ($BATON_FULL_SYNC_INTERVAL)")
+	cmd.PersistentFlags().Duration("incremental-overlap", connector.DefaultIncrementalSync().Overlap, "Fetch the changes made this long before the last successful sync started again, with --incremental-state-file. ($BATON_INCREMENTAL_OVERLAP)")
+	cmd.PersistentFlags().Duration("activity-lookback", 0, "Read this far back in the activities log for the last login and api activity of the users and service users, e.g. 720h. 0 doesn't read it. ($BATON_ACTIVITY_LOOKBACK)")
+	cmd.PersistentFlags().IntSlice("login-activity-types", connector.DefaultLoginActivityTypes, "Activity types of the logins, with --activity-lookback. ($BATON_LOGIN_ACTIVITY_TYPES)")
+	cmd.PersistentFlags().IntSlice("api-activity-types", nil, "Activity types of the api token usage, with --activity-lookback. ($BATON_API_ACTIVITY_TYPES)")
 }
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/conductorone/baton-sentinel-one/pkg/connector"
+)
+
+const (
+	outputText = "text"
+	outputJSON = "json"
+)
+
+// diagnoseCmd returns the command reporting what the configured api tokens can see and do.
+func diagnoseCmd(ctx context.Context, cfg *config) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:           "diagnose",
+		Short:         "Report the identity, scope and permissions of the api tokens and the cost of a full sync",
+		SilenceErrors: true,
+		SilenceUsage:  true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := loadConfig(cmd, cfg); err != nil {
+				return err
+			}
+
+			output, err := cmd.Flags().GetString("output")
+			if err != nil {
+				return err
+			}
+			if output != outputText && output != outputJSON {
+				return fmt.Errorf("output must be %s or %s", outputText, outputJSON)
+			}
+
+			if err := validateConfig(ctx, cfg); err != nil {
+				return err
+			}
+
+			consoles, err := managementConsoles(cfg)
+			if err != nil {
+				return err
+			}
+
+			c, err := connector.NewReadOnly(ctx, consoles, connectorOptions(cfg)...)
+			if err != nil {
+				return err
+			}
+
+			diagnosis, err := c.Diagnose(ctx)
+			if err != nil {
+				return err
+			}
+
+			if output == outputJSON {
+				enc := json.NewEncoder(cmd.OutOrStdout())
+				enc.SetIndent("", "  ")
+				return enc.Encode(diagnosis)
+			}
+
+			return printDiagnosis(cmd.OutOrStdout(), diagnosis)
+		},
+	}
+
+	cmd.Flags().StringP("output", "o", outputText, "Output format: text, json")
+
+	return cmd
+}
+
+// loadConfig reads the configuration of a subcommand from the config file, the environment and the flags, like the connector command does.
+func loadConfig(cmd *cobra.Command, cfg *config) error {
+	v := viper.New()
+	v.SetConfigType("yaml")
+	v.SetConfigName(".baton")
+	v.AddConfigPath(".")
+	if path := os.Getenv("BATON_CONFIG_PATH"); path != "" {
+		v.SetConfigFile(path)
+	}
+
+	if err := v.ReadInConfig(); err != nil {
+		var notFound viper.ConfigFileNotFoundError
+		if !errors.As(err, &notFound) {
+			return err
+		}
+	}
+
+	v.SetEnvPrefix("baton")
+	v.SetEnvKeyReplacer(strings.NewReplacer("-", "_"))
+	v.AutomaticEnv()
+	if err := v.BindPFlags(cmd.InheritedFlags()); err != nil {
+		return err
+	}
+	if err := v.BindPFlags(cmd.Flags()); err != nil {
+		return err
+	}
+
+	return v.Unmarshal(cfg)
+}
+
+func printDiagnosis(out io.Writer, diagnosis *connector.Diagnosis) error {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+
+	for _, c := range diagnosis.Consoles {
+		name := c.URL
+		if c.Name != "" {
+			name = fmt.Sprintf("%s (%s)", c.Name, c.URL)
+		}
+		fmt.Fprintf(w, "Console\t%s\n", name)
+		if c.VersionError != "" {
+			fmt.Fprintf(w, "Version\tunknown: %s\n", c.VersionError)
+		} else {
+			fmt.Fprintf(w, "Version\t%s\n", c.Version)
+		}
+
+		for i, t := range c.Tokens {
+			fmt.Fprintf(w, "\nAPI token %d\n", i+1)
+			if t.Identity != nil {
+				fmt.Fprintf(w, "  Identity\t%s (%s) %s\n", t.Identity.Name, t.Identity.ID, t.Identity.Email)
+				fmt.Fprintf(w, "  Scope\t%s\n", t.Identity.Scope)
+				fmt.Fprintf(w, "  Roles\t%s\n", strings.Join(t.Identity.Roles, ", "))
+				expires := t.Identity.TokenExpiresAt
+				if expires == "" {
+					expires = "unknown"
+				}
+				fmt.Fprintf(w, "  Token expires\t%s\n", expires)
+			} else {
+				fmt.Fprintf(w, "  Identity\tunknown: %s\n", t.IdentityError)
+			}
+
+			fmt.Fprintf(w, "  Endpoints\n")
+			for _, e := range t.Endpoints {
+				access := "denied"
+				if e.Allowed {
+					access = "allowed"
+				}
+				if e.Inferred {
+					access += " (inferred from roles)"
+				}
+				if e.Error != "" {
+					access += ": " + e.Error
+				}
+				fmt.Fprintf(w, "    %s %s\t%s\n", e.Method, e.Path, access)
+			}
+
+			fmt.Fprintf(w, "  Totals\n")
+			resourceTypes := make([]string, 0, len(t.Counts))
+			for resourceType := range t.Counts {
+				resourceTypes = append(resourceTypes, resourceType)
+			}
+			sort.Strings(resourceTypes)
+			for _, resourceType := range resourceTypes {
+				fmt.Fprintf(w, "    %s\t%d\n", resourceType, t.Counts[resourceType])
+			}
+
+			duration := time.Duration(t.EstimatedSeconds * float64(time.Second)).Round(time.Second)
+			fmt.Fprintf(w, "  Full sync estimate\t%d API calls, %s\n", t.EstimatedAPICalls, duration)
+		}
+		fmt.Fprintln(w)
+	}
+
+	return w.Flush()
+}
@@ -12,6 +12,7 @@ import (
 	"go.uber.org/zap"
 
 	"github.com/conductorone/baton-sentinel-one/pkg/connector"
+	"github.com/conductorone/baton-sentinel-one/pkg/sentinelone"
 )
 
 var version = "dev"
@@ -28,18 +29,115 @@ func main() {
 
 	cmd.Version = version
 	cmdFlags(cmd)
+	markConnectorService(cmd)
+	cmd.AddCommand(diagnoseCmd(ctx, cfg))
+	cmd.AddCommand(auditExportCmd(ctx, cfg))
+	cmd.AddCommand(reportCmd(ctx, cfg))
 
-	err = cmd.Execute()
+	executed, err := cmd.ExecuteC()
+	if tracerProvider != nil {
+		if err := shutdownTraces(tracerProvider); err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+		}
+	}
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err.Error())
 		os.Exit(1)
 	}
+
+	if executed == cmd {
+		printSkippedScopes(cfg)
+		printSchemaDrift(cfg)
+	}
+}
+
+// printSkippedScopes lists the accounts and sites skipped by the sync, the connector runs in a subprocess that reports them in a file.
+func printSkippedScopes(cfg *config) {
+	if cfg.SkippedScopesFile == "" {
+		return
+	}
+
+	skipped, err := connector.LoadSkippedScopes(cfg.SkippedScopesFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return
+	}
+
+	if len(skipped) == 0 {
+		return
+	}
+
+	fmt.Fprintf(os.Stderr, "Skipped %d accounts and sites:\n", len(skipped))
+	for _, s := range skipped {
+		name := s.Name
+		if s.Console != "" {
+			name = fmt.Sprintf("%s (console %s)", name, s.Console)
+		}
+		fmt.Fprintf(os.Stderr, "  %s %s %s: %s\n", s.Scope, s.ID, name, s.Reason)
+	}
+}
+
+// printSchemaDrift lists the differences between the API responses and the connector found by the sync in strict decode mode.
+func printSchemaDrift(cfg *config) {
+	if cfg.SchemaDriftFile == "" {
+		return
+	}
+
+	drift, err := connector.LoadSchemaDrift(cfg.SchemaDriftFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return
+	}
+
+	if len(drift) == 0 {
+		return
+	}
+
+	fmt.Fprintf(os.Stderr, "Warning: %d fields of the API responses differ from what the connector expects:\n", len(drift))
+	for _, d := range drift {
+		endpoint := d.Endpoint
+		if d.Console != "" {
+			endpoint = fmt.Sprintf("%s (console %s)", endpoint, d.Console)
+		}
+
+		switch d.Kind {
+		case sentinelone.DriftTypeMismatch:
+			fmt.Fprintf(os.Stderr, "  %s %s: %s is %s, expected %s\n", endpoint, d.Path, d.Kind, d.Actual, d.Expected)
+		default:
+			fmt.Fprintf(os.Stderr, "  %s %s: %s\n", endpoint, d.Path, d.Kind)
+		}
+	}
 }
 
 func getConnector(ctx context.Context, cfg *config) (types.ConnectorServer, error) {
 	l := ctxzap.Extract(ctx)
 
-	sentineloneConnector, err := connector.New(ctx, cfg.ManagementUrl, cfg.Token)
+	consoles, err := managementConsoles(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := connectorOptions(cfg)
+	if cfg.MetricsListenAddress != "" && connectorService {
+		provider, err := serveMetrics(ctx, cfg.MetricsListenAddress)
+		if err != nil {
+			l.Error("error serving metrics", zap.Error(err))
+			return nil, err
+		}
+		opts = append(opts, connector.WithMeterProvider(provider))
+	}
+
+	if cfg.OTLPEndpoint != "" && connectorService {
+		provider, err := exportTraces(ctx, cfg.OTLPEndpoint, cfg.OTLPInsecure)
+		if err != nil {
+			l.Error("error exporting traces", zap.Error(err))
+			return nil, err
+		}
+		tracerProvider = provider
+		opts = append(opts, connector.WithTracerProvider(provider))
+	}
+
+	sentineloneConnector, err := connector.New(ctx, consoles, opts...)
 	if err != nil {
 		l.Error("error creating connector", zap.Error(err))
 		return nil, err
@@ -52,3 +150,27 @@ func getConnector(ctx context.Context, cfg *config) (types.ConnectorServer, erro
 	}
 	return c, nil
 }
+
+func connectorOptions(cfg *config) []connector.Option {
+	return []connector.Option{
+		connector.WithDryRun(cfg.DryRun),
+		connector.WithRateLimit(cfg.RequestsPerSecond),
+		connector.WithGuardrails(connector.Guardrails{
+			ProtectTokenOwner: cfg.GuardrailTokenOwner,
+			ProtectLastAdmin:  cfg.GuardrailLastAdmin,
+			AllowedAccountIDs: cfg.AllowedAccountIDs,
+			AllowedSiteIDs:    cfg.AllowedSiteIDs,
+		}),
+		connector.WithScopeFilter(scopeFilter(cfg)),
+		connector.WithDisabledResourceTypes(cfg.DisabledResourceTypes...),
+		connector.WithScopeFailures(scopeFailures(cfg)),
+		connector.WithGrantWorkers(cfg.GrantWorkers),
+		connector.WithHTTPOptions(httpOptions(cfg)),
+		connector.WithAPIVersion(cfg.APIVersion),
+		connector.WithLogHashPII(cfg.LogHashPII),
+		connector.WithStrictDecode(cfg.StrictDecode),
+		connector.WithSchemaDriftReport(cfg.SchemaDriftFile),
+		connector.WithIncrementalSync(incrementalSync(cfg)),
+		connector.WithActivity(activity(cfg)),
+	}
+}
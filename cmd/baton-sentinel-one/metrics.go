@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/spf13/cobra"
+	otelprom "go.opentelemetry.io/otel/exporters/prometheus"
+	"go.opentelemetry.io/otel/metric"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.uber.org/zap"
+)
+
+// connectorServiceCmd is the hidden command of the SDK running the connector in a subprocess.
+const connectorServiceCmd = "_connector-service"
+
+// connectorService is set when cobra runs the hidden command, this process is then the subprocess sending the requests.
+// The parent process builds a connector too, but only hands the sync over to the subprocess.
+var connectorService bool
+
+// markConnectorService sets connectorService before the hidden command of the SDK builds the connector.
+func markConnectorService(cmd *cobra.Command) {
+	for _, sub := range cmd.Commands() {
+		if sub.Name() == connectorServiceCmd {
+			sub.PreRun = func(*cobra.Command, []string) {
+				connectorService = true
+			}
+		}
+	}
+}
+
+// serveMetrics serves the API usage metrics in the Prometheus format on /metrics of addr until the context is done.
+// The listener is opened before the sync starts, so a taken address fails the run instead of going unnoticed.
+func serveMetrics(ctx context.Context, addr string) (metric.MeterProvider, error) {
+	registry := prometheus.NewRegistry()
+	exporter, err := otelprom.New(otelprom.WithRegisterer(registry))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create metrics exporter: %w", err)
+	}
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen for metrics: %w", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+	server := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	l := ctxzap.Extract(ctx)
+	go func() {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			l.Error("failed to serve metrics", zap.Error(err))
+		}
+	}()
+
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+
+	l.Info("serving metrics", zap.String("address", listener.Addr().String()))
+
+	return sdkmetric.NewMeterProvider(sdkmetric.WithReader(exporter)), nil
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"github.com/conductorone/baton-sentinel-one/pkg/connector"
+)
+
+// reportCmd returns the command grouping the reports for access reviews.
+func reportCmd(ctx context.Context, cfg *config) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "report",
+		Short: "Report on the access to the management consoles for access reviews",
+	}
+
+	cmd.AddCommand(reportRolesCmd(ctx, cfg))
+
+	return cmd
+}
+
+// reportRolesCmd returns the command reporting unused custom roles and over-broad role assignments.
+func reportRolesCmd(ctx context.Context, cfg *config) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:           "roles",
+		Short:         "Report unused custom roles, tenant scope roles, Admins of many scopes and Admin service users",
+		SilenceErrors: true,
+		SilenceUsage:  true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := loadConfig(cmd, cfg); err != nil {
+				return err
+			}
+
+			output, err := cmd.Flags().GetString("output")
+			if err != nil {
+				return err
+			}
+			if output != outputText && output != outputJSON {
+				return fmt.Errorf("output must be %s or %s", outputText, outputJSON)
+			}
+
+			adminScopes, err := cmd.Flags().GetInt("admin-scopes")
+			if err != nil {
+				return err
+			}
+
+			if err := validateConfig(ctx, cfg); err != nil {
+				return err
+			}
+
+			consoles, err := managementConsoles(cfg)
+			if err != nil {
+				return err
+			}
+
+			c, err := connector.NewReadOnly(ctx, consoles, connectorOptions(cfg)...)
+			if err != nil {
+				return err
+			}
+
+			report, err := c.ReportRoles(ctx, adminScopes)
+			if err != nil {
+				return err
+			}
+
+			if output == outputJSON {
+				enc := json.NewEncoder(cmd.OutOrStdout())
+				enc.SetIndent("", "  ")
+				return enc.Encode(report)
+			}
+
+			return printRoleReport(cmd.OutOrStdout(), report)
+		},
+	}
+
+	cmd.Flags().StringP("output", "o", outputText, "Output format: text, json")
+	cmd.Flags().Int("admin-scopes", connector.DefaultAdminScopes, "List the users and service users holding the Admin role at more than this many tenants, accounts and sites.")
+
+	return cmd
+}
+
+func printRoleReport(out io.Writer, report *connector.RoleReport) error {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+
+	for _, c := range report.Consoles {
+		name := c.URL
+		if c.Name != "" {
+			name = fmt.Sprintf("%s (%s)", c.Name, c.URL)
+		}
+		fmt.Fprintf(w, "Console\t%s\n", name)
+
+		fmt.Fprintf(w, "\nCustom roles without assignments: %d\n", len(c.UnusedCustomRoles))
+		for _, role := range c.UnusedCustomRoles {
+			fmt.Fprintf(w, "  %s\t%s\n", role.Name, role.ID)
+		}
+
+		fmt.Fprintf(w, "\nRoles assigned at tenant scope: %d\n", len(c.TenantAssignments))
+		printAssignments(w, c.TenantAssignments)
+
+		fmt.Fprintf(w, "\nAdmins of more than %d scopes: %d\n", report.AdminScopes, len(c.BroadAdmins))
+		for _, admin := range c.BroadAdmins {
+			fmt.Fprintf(w, "  %s\t%s\t%s\t%d scopes\n", admin.PrincipalName, admin.PrincipalType, admin.PrincipalID, len(admin.Assignments))
+			for _, a := range admin.Assignments {
+				fmt.Fprintf(w, "    %s %s (%s)\n", a.Scope, a.ScopeName, a.ScopeID)
+			}
+		}
+
+		fmt.Fprintf(w, "\nService users with Admin: %d\n", len(c.AdminServiceUsers))
+		printAssignments(w, c.AdminServiceUsers)
+		fmt.Fprintln(w)
+	}
+
+	return w.Flush()
+}
+
+func printAssignments(w io.Writer, assignments []connector.RoleAssignment) {
+	for _, a := range assignments {
+		fmt.Fprintf(w, "  %s\t%s\t%s\t%s\t%s %s\n", a.PrincipalName, a.PrincipalType, a.PrincipalID, a.RoleName, a.Scope, a.ScopeName)
+	}
+}
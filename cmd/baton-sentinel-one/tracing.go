@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+)
+
+// traceBatchTimeout is how often the spans are exported. The SDK may exit the connector subprocess without returning from
+// the command, the connector flushes the spans when a sync ends and a short timeout keeps what's lost otherwise small.
+const traceBatchTimeout = time.Second
+
+// traceShutdownTimeout bounds the export of the spans left when the connector service exits.
+const traceShutdownTimeout = 5 * time.Second
+
+// tracerProvider is the provider of the connector service when it exports traces, it's shut down when the service exits.
+var tracerProvider *sdktrace.TracerProvider
+
+// exportTraces exports the spans of the syncers and the API requests to an OTLP collector over gRPC.
+// The exporter only dials when spans are exported, an unreachable collector doesn't hold up the sync.
+func exportTraces(ctx context.Context, endpoint string, insecure bool) (*sdktrace.TracerProvider, error) {
+	opts := []otlptracegrpc.Option{
+		otlptracegrpc.WithEndpoint(endpoint),
+	}
+	if insecure {
+		opts = append(opts, otlptracegrpc.WithInsecure())
+	}
+
+	exporter, err := otlptracegrpc.New(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create trace exporter: %w", err)
+	}
+
+	res := resource.NewWithAttributes(semconv.SchemaURL,
+		semconv.ServiceNameKey.String("baton-sentinel-one"),
+		semconv.ServiceVersionKey.String(version),
+	)
+
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter, sdktrace.WithBatchTimeout(traceBatchTimeout)),
+		sdktrace.WithResource(res),
+	), nil
+}
+
+// shutdownTraces exports the spans still batched and stops the exporter, giving up after traceShutdownTimeout.
+func shutdownTraces(provider *sdktrace.TracerProvider) error {
+	ctx, cancel := context.WithTimeout(context.Background(), traceShutdownTimeout)
+	defer cancel()
+
+	if err := provider.Shutdown(ctx); err != nil {
+		return fmt.Errorf("failed to shut down trace exporter: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,93 @@
+package connector
+
+import (
+	"context"
+
+	"github.com/conductorone/baton-sdk/pkg/annotations"
+	"github.com/conductorone/baton-sdk/pkg/pagination"
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/conductorone/baton-sentinel-one/pkg/sentinelone"
+)
+
+// forbidden reports whether the resource type can't be read with the token, because err or an earlier request was a 403.
+// The first 403 of a resource type is logged, later listings of it are skipped without sending requests.
+func (r *route) forbidden(ctx context.Context, resourceTypeID string, err error) bool {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	if _, ok := r.denied[resourceTypeID]; ok {
+		return true
+	}
+
+	if !sentinelone.IsForbidden(err) {
+		return false
+	}
+
+	if r.denied == nil {
+		r.denied = make(map[string]string)
+	}
+	r.denied[resourceTypeID] = err.Error()
+
+	ctxzap.Extract(ctx).Warn("api token can't read resource type, skipping it",
+		zap.String("resource_type", resourceTypeID),
+		zap.Error(err),
+	)
+
+	return true
+}
+
+// deniedResourceTypes returns the resource types the token can't read and why.
+func (r *route) deniedResourceTypes() map[string]string {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	rv := make(map[string]string, len(r.denied))
+	for k, v := range r.denied {
+		rv[k] = v
+	}
+
+	return rv
+}
+
+// skipPage drops the current page state and returns the next page token with annotations reporting the skipped resource type.
+func (r *route) skipPage(bag *pagination.Bag, resourceTypeID string) (string, annotations.Annotations, error) {
+	pageToken, err := bag.NextToken("")
+	if err != nil {
+		return "", nil, err
+	}
+
+	annos, err := skippedAnnotations(map[string]string{
+		resourceTypeID: r.deniedResourceTypes()[resourceTypeID],
+	})
+	if err != nil {
+		return "", nil, err
+	}
+
+	return pageToken, annos, nil
+}
+
+// skippedAnnotations describes the resource types that were not synced and why.
+func skippedAnnotations(skipped map[string]string) (annotations.Annotations, error) {
+	if len(skipped) == 0 {
+		return nil, nil
+	}
+
+	reasons := make(map[string]interface{}, len(skipped))
+	for resourceTypeID, reason := range skipped {
+		reasons[resourceTypeID] = reason
+	}
+
+	msg, err := structpb.NewStruct(map[string]interface{}{
+		"skipped_resource_types": reasons,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	annos := annotations.Annotations{}
+	annos.Append(msg)
+	return annos, nil
+}
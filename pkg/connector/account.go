@@ -11,13 +11,15 @@ import (
 	grant "github.com/conductorone/baton-sdk/pkg/types/grant"
 	rs "github.com/conductorone/baton-sdk/pkg/types/resource"
 	"github.com/conductorone/baton-sentinel-one/pkg/sentinelone"
+	"google.golang.org/protobuf/proto"
 )
 
 const accountMembership = "member"
 
 type accountResourceType struct {
 	resourceType *v2.ResourceType
-	client       *sentinelone.Client
+	consoles     *consoleSet
+	types        resourceTypeSet
 }
 
 func (a *accountResourceType) ResourceType(_ context.Context) *v2.ResourceType {
@@ -25,16 +27,18 @@ func (a *accountResourceType) ResourceType(_ context.Context) *v2.ResourceType {
 }
 
 // Create a new connector resource for a SentinelOne account.
-func accountResource(account *sentinelone.Account) (*v2.Resource, error) {
+func accountResource(c *console, account *sentinelone.Account, types resourceTypeSet) (*v2.Resource, error) {
+	var children []proto.Message
+	for _, child := range types.only(resourceTypeUser, resourceTypeServiceUser, resourceTypeSite) {
+		children = append(children, &v2.ChildResourceType{ResourceTypeId: child.Id})
+	}
+
 	ret, err := rs.NewResource(
 		account.Name,
 		resourceTypeAccount,
-		account.ID,
-		rs.WithAnnotation(
-			&v2.ChildResourceType{ResourceTypeId: resourceTypeUser.Id},
-			&v2.ChildResourceType{ResourceTypeId: resourceTypeServiceUser.Id},
-			&v2.ChildResourceType{ResourceTypeId: resourceTypeSite.Id},
-		),
+		c.id(account.ID),
+		rs.WithAnnotation(children...),
+		rs.WithParentResourceID(c.root),
 	)
 	if err != nil {
 		return nil, err
@@ -43,16 +47,38 @@ func accountResource(account *sentinelone.Account) (*v2.Resource, error) {
 	return ret, nil
 }
 
-func (a *accountResourceType) List(ctx context.Context, _ *v2.ResourceId, pToken *pagination.Token) ([]*v2.Resource, string, annotations.Annotations, error) {
+func (a *accountResourceType) List(ctx context.Context, parentId *v2.ResourceId, pToken *pagination.Token) ([]*v2.Resource, string, annotations.Annotations, error) {
+	c, ok, err := a.consoles.listedUnder(parentId, a.types, false)
+	if err != nil || !ok {
+		return nil, "", nil, err
+	}
+
 	bag, page, err := parsePageToken(pToken.Token, &v2.ResourceId{ResourceType: resourceTypeAccount.Id})
 	if err != nil {
 		return nil, "", nil, err
 	}
 
-	accounts, nextPage, err := a.client.GetAccounts(ctx, sentinelone.ParamsMap{
-		cursor: page,
-	})
+	index, ready, err := c.routes.fanOut(bag)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	if !ready {
+		pageToken, err := bag.Marshal()
+		return nil, pageToken, nil, err
+	}
+
+	rt := c.routes.all[index]
+	if rt.forbidden(ctx, resourceTypeAccount.Id, nil) {
+		pageToken, annos, err := rt.skipPage(bag, resourceTypeAccount.Id)
+		return nil, pageToken, annos, err
+	}
+
+	accounts, nextPage, err := listPage(ctx, c, index, accountList, page)
 	if err != nil {
+		if rt.forbidden(ctx, resourceTypeAccount.Id, err) {
+			pageToken, annos, err := rt.skipPage(bag, resourceTypeAccount.Id)
+			return nil, pageToken, annos, err
+		}
 		return nil, "", nil, err
 	}
 
@@ -62,13 +88,24 @@ func (a *accountResourceType) List(ctx context.Context, _ *v2.ResourceId, pToken
 	}
 
 	var rv []*v2.Resource
-	for _, account := range accounts {
+	for _, account := range c.scopes.filterAccounts(accounts) {
+		owner, err := c.routes.accountOwner(ctx, account.ID)
+		if err != nil {
+			return nil, "", nil, err
+		}
+
+		// accounts readable with several api tokens are listed with the first of them.
+		if owner != index {
+			continue
+		}
+
 		accountCopy := account
-		ur, err := accountResource(&accountCopy)
+		ur, err := accountResource(c, &accountCopy, a.types)
 		if err != nil {
 			return nil, "", nil, err
 		}
 		rv = append(rv, ur)
+		c.prefetch.register(c, scopeAccount, account.ID)
 	}
 
 	return rv, pageToken, nil, nil
@@ -78,7 +115,7 @@ func (a *accountResourceType) Entitlements(_ context.Context, resource *v2.Resou
 	var rv []*v2.Entitlement
 
 	assignmentOptions := []ent.EntitlementOption{
-		ent.WithGrantableTo(resourceTypeUser, resourceTypeSite, resourceTypeServiceUser),
+		ent.WithGrantableTo(a.types.only(resourceTypeUser, resourceTypeSite, resourceTypeServiceUser)...),
 		ent.WithDisplayName(fmt.Sprintf("%s Account %s", resource.DisplayName, accountMembership)),
 		ent.WithDescription(fmt.Sprintf("Access to %s account in SentinelOne", resource.DisplayName)),
 	}
@@ -98,37 +135,58 @@ func (a *accountResourceType) Grants(ctx context.Context, resource *v2.Resource,
 		return nil, "", nil, err
 	}
 
+	c, accountID, err := a.consoles.resolve(resource.Id.Resource)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	rt, err := c.routes.routeFor(ctx, scopeAccount, accountID)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
 	var rv []*v2.Grant
 	switch bag.ResourceTypeID() {
 	case resourceTypeAccount.Id:
 		bag.Pop()
-		bag.Push(pagination.PageState{
-			ResourceTypeID: resourceTypeUser.Id,
-		})
-		bag.Push(pagination.PageState{
-			ResourceTypeID: resourceTypeServiceUser.Id,
-		})
-		bag.Push(pagination.PageState{
-			ResourceTypeID: resourceTypeSite.Id,
-		})
+		a.types.pushEnabled(bag, resourceTypeUser, resourceTypeServiceUser, resourceTypeSite)
+		c.prefetch.visit(ctx, c, scopeAccount, accountID)
 
 	case resourceTypeUser.Id:
-		accountUsers, nextCursor, err := a.client.GetUsers(ctx, sentinelone.ParamsMap{
-			accountsFilter: resource.Id.Resource,
-			cursor:         page,
+		if rt.forbidden(ctx, resourceTypeUser.Id, nil) {
+			pageToken, annos, err := rt.skipPage(bag, resourceTypeUser.Id)
+			return nil, pageToken, annos, err
+		}
+
+		var (
+			accountUsers []sentinelone.User
+			nextCursor   string
+		)
+		skipped, err := c.failures.call(ctx, c, scopeAccount, accountID, resource.DisplayName, func(ctx context.Context) error {
+			var err error
+			accountUsers, nextCursor, err = grantPage(ctx, c, rt, scopeAccount, accountID, userList, page)
+			return err
 		})
 		if err != nil {
 			return nil, "", nil, fmt.Errorf("failed to list users for account %s: %w", resource.Id.Resource, err)
 		}
+		if skipped {
+			return skippedScope(c, bag, scopeAccount, accountID)
+		}
 
 		paginationErr := bag.Next(nextCursor)
 		if paginationErr != nil {
 			return nil, "", nil, paginationErr
 		}
 
+		accountUsers, err = c.scopes.filterUsers(ctx, accountUsers)
+		if err != nil {
+			return nil, "", nil, err
+		}
+
 		for _, accountUser := range accountUsers {
 			accountUserCopy := accountUser
-			ur, err := userResource(&accountUserCopy, resource.Id)
+			ur, err := userResource(c, &accountUserCopy, resource.Id)
 			if err != nil {
 				return nil, "", nil, fmt.Errorf("error creating user resource for account %s: %w", resource.Id.Resource, err)
 			}
@@ -136,22 +194,40 @@ func (a *accountResourceType) Grants(ctx context.Context, resource *v2.Resource,
 		}
 
 	case resourceTypeServiceUser.Id:
-		accountServiceUsers, nextCursor, err := a.client.GetServiceUsers(ctx, sentinelone.ParamsMap{
-			accountsFilter: resource.Id.Resource,
-			cursor:         page,
+		if rt.forbidden(ctx, resourceTypeServiceUser.Id, nil) {
+			pageToken, annos, err := rt.skipPage(bag, resourceTypeServiceUser.Id)
+			return nil, pageToken, annos, err
+		}
+
+		var (
+			accountServiceUsers []sentinelone.ServiceUser
+			nextCursor          string
+		)
+		skipped, err := c.failures.call(ctx, c, scopeAccount, accountID, resource.DisplayName, func(ctx context.Context) error {
+			var err error
+			accountServiceUsers, nextCursor, err = grantPage(ctx, c, rt, scopeAccount, accountID, serviceUserList, page)
+			return err
 		})
 		if err != nil {
 			return nil, "", nil, fmt.Errorf("failed to list service users for account %s: %w", resource.Id.Resource, err)
 		}
+		if skipped {
+			return skippedScope(c, bag, scopeAccount, accountID)
+		}
 
 		paginationErr := bag.Next(nextCursor)
 		if paginationErr != nil {
 			return nil, "", nil, paginationErr
 		}
 
+		accountServiceUsers, err = c.scopes.filterServiceUsers(ctx, accountServiceUsers)
+		if err != nil {
+			return nil, "", nil, err
+		}
+
 		for _, accountServiceUser := range accountServiceUsers {
 			accountServiceUserCopy := accountServiceUser
-			sur, err := serviceUserResource(&accountServiceUserCopy, resource.Id)
+			sur, err := serviceUserResource(c, &accountServiceUserCopy, resource.Id)
 			if err != nil {
 				return nil, "", nil, fmt.Errorf("error creating service user resource for account %s: %w", resource.Id.Resource, err)
 			}
@@ -160,22 +236,35 @@ func (a *accountResourceType) Grants(ctx context.Context, resource *v2.Resource,
 		}
 
 	case resourceTypeSite.Id:
-		accountSites, nextCursor, err := a.client.GetSites(ctx, sentinelone.ParamsMap{
-			accountsFilter: resource.Id.Resource,
-			cursor:         page,
+		if rt.forbidden(ctx, resourceTypeSite.Id, nil) {
+			pageToken, annos, err := rt.skipPage(bag, resourceTypeSite.Id)
+			return nil, pageToken, annos, err
+		}
+
+		var (
+			accountSites []sentinelone.Site
+			nextCursor   string
+		)
+		skipped, err := c.failures.call(ctx, c, scopeAccount, accountID, resource.DisplayName, func(ctx context.Context) error {
+			var err error
+			accountSites, nextCursor, err = grantPage(ctx, c, rt, scopeAccount, accountID, siteList, page)
+			return err
 		})
 		if err != nil {
 			return nil, "", nil, fmt.Errorf("failed to list sites for account %s: %w", resource.Id.Resource, err)
 		}
+		if skipped {
+			return skippedScope(c, bag, scopeAccount, accountID)
+		}
 
 		paginationErr := bag.Next(nextCursor)
 		if paginationErr != nil {
 			return nil, "", nil, paginationErr
 		}
 
-		for _, accountSite := range accountSites {
+		for _, accountSite := range c.scopes.filterSites(accountSites) {
 			accountSiteCopy := accountSite
-			sr, err := siteResource(&accountSiteCopy, resource.Id)
+			sr, err := siteResource(c, &accountSiteCopy, resource.Id)
 			if err != nil {
 				return nil, "", nil, fmt.Errorf("error creating site resource for account %s: %w", resource.Id.Resource, err)
 			}
@@ -193,9 +282,10 @@ func (a *accountResourceType) Grants(ctx context.Context, resource *v2.Resource,
 	return rv, pageToken, nil, nil
 }
 
-func accountBuilder(client *sentinelone.Client) *accountResourceType {
+func accountBuilder(consoles *consoleSet, types resourceTypeSet) *accountResourceType {
 	return &accountResourceType{
 		resourceType: resourceTypeAccount,
-		client:       client,
+		consoles:     consoles,
+		types:        types,
 	}
 }
@@ -0,0 +1,188 @@
+package connector
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+	"go.uber.org/zap"
+
+	"github.com/conductorone/baton-sentinel-one/pkg/sentinelone"
+)
+
+const (
+	lastLoginProfileKey       = "last_login"
+	lastAPIActivityProfileKey = "last_api_activity"
+
+	activityTypesFilter = "activityTypes"
+	createdSince        = "createdAt__gte"
+)
+
+// DefaultLoginActivityTypes are the activity types of the logins to the management console.
+var DefaultLoginActivityTypes = []int{27}
+
+// Activity configures reading the activities log of the consoles, for when the users and service users last logged in
+// and used the api. The users endpoint doesn't tell reliably.
+type Activity struct {
+	// Lookback is how far back the activities are read, 0 doesn't read them.
+	Lookback time.Duration
+	// LoginTypes and APITypes are the activity type ids of the logins and of the api usage,
+	// listed by GET /web/api/v2.1/activities/types.
+	LoginTypes []int
+	APITypes   []int
+}
+
+// Validate returns an error if the lookback is negative or no activity type is given.
+func (a Activity) Validate() error {
+	if a.Lookback < 0 {
+		return fmt.Errorf("activity lookback must not be negative")
+	}
+
+	if a.Lookback > 0 && len(a.LoginTypes) == 0 && len(a.APITypes) == 0 {
+		return fmt.Errorf("activity lookback requires login or api activity types")
+	}
+
+	for _, activityType := range append(append([]int(nil), a.LoginTypes...), a.APITypes...) {
+		if activityType < 0 {
+			return fmt.Errorf("invalid activity type %d", activityType)
+		}
+	}
+
+	return nil
+}
+
+// activityLog holds when each user and service user of a console last logged in and used the api.
+// The activities log is read once, the first time the users or service users are listed.
+type activityLog struct {
+	cfg    Activity
+	routes *routes
+	login  map[int]struct{}
+	api    map[int]struct{}
+
+	mtx       sync.Mutex
+	loaded    bool
+	lastLogin map[string]time.Time
+	lastAPI   map[string]time.Time
+}
+
+// newActivityLog returns nil when the activities are not read.
+func newActivityLog(cfg Activity, routes *routes) *activityLog {
+	if cfg.Lookback == 0 {
+		return nil
+	}
+
+	rv := &activityLog{
+		cfg:       cfg,
+		routes:    routes,
+		login:     make(map[int]struct{}, len(cfg.LoginTypes)),
+		api:       make(map[int]struct{}, len(cfg.APITypes)),
+		lastLogin: make(map[string]time.Time),
+		lastAPI:   make(map[string]time.Time),
+	}
+	for _, activityType := range cfg.LoginTypes {
+		rv.login[activityType] = struct{}{}
+	}
+	for _, activityType := range cfg.APITypes {
+		rv.api[activityType] = struct{}{}
+	}
+
+	return rv
+}
+
+// load reads the activities of the lookback with every api token of the console, the activities of each token's scopes.
+// A token that can't read the activities is skipped with a warning.
+func (a *activityLog) load(ctx context.Context) error {
+	if a == nil {
+		return nil
+	}
+
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	if a.loaded {
+		return nil
+	}
+
+	l := ctxzap.Extract(ctx)
+
+	var activityTypes []string
+	for _, activityType := range append(append([]int(nil), a.cfg.LoginTypes...), a.cfg.APITypes...) {
+		activityTypes = append(activityTypes, strconv.Itoa(activityType))
+	}
+	since := time.Now().Add(-a.cfg.Lookback).UTC().Format(time.RFC3339Nano)
+
+	for i, rt := range a.routes.all {
+		var (
+			page  string
+			count int
+		)
+		for {
+			nextPage, err := rt.client.EachActivity(ctx, sentinelone.ParamsMap{
+				activityTypesFilter: strings.Join(activityTypes, ","),
+				createdSince:        since,
+				cursor:              page,
+			}, func(activity sentinelone.Activity) error {
+				count++
+				a.record(activity)
+				return nil
+			})
+			if err != nil {
+				if sentinelone.IsForbidden(err) {
+					l.Warn("api token can't read the activities, skipping them", zap.Int("api_token", i+1), zap.Error(err))
+					break
+				}
+				return fmt.Errorf("failed to list activities: %w", err)
+			}
+
+			if nextPage == "" {
+				break
+			}
+			page = nextPage
+		}
+
+		l.Debug("read activities", zap.Int("api_token", i+1), zap.Int("count", count), zap.String("since", since))
+	}
+
+	a.loaded = true
+
+	return nil
+}
+
+func (a *activityLog) record(activity sentinelone.Activity) {
+	if activity.UserID == "" {
+		return
+	}
+
+	createdAt, err := time.Parse(time.RFC3339Nano, activity.CreatedAt)
+	if err != nil {
+		return
+	}
+
+	if _, ok := a.login[activity.ActivityType]; ok && createdAt.After(a.lastLogin[activity.UserID]) {
+		a.lastLogin[activity.UserID] = createdAt
+	}
+	if _, ok := a.api[activity.ActivityType]; ok && createdAt.After(a.lastAPI[activity.UserID]) {
+		a.lastAPI[activity.UserID] = createdAt
+	}
+}
+
+// addToProfile sets the last login and api activity of a user or service user found in the activities log.
+func (a *activityLog) addToProfile(profile map[string]interface{}, id string) {
+	if a == nil {
+		return
+	}
+
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	if at, ok := a.lastLogin[id]; ok {
+		profile[lastLoginProfileKey] = at.Format(time.RFC3339)
+	}
+	if at, ok := a.lastAPI[id]; ok {
+		profile[lastAPIActivityProfileKey] = at.Format(time.RFC3339)
+	}
+}
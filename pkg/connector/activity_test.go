@@ -0,0 +1,142 @@
+package connector
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	rs "github.com/conductorone/baton-sdk/pkg/types/resource"
+
+	"github.com/conductorone/baton-sentinel-one/pkg/sentinelone"
+)
+
+func TestActivityValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		activity Activity
+		wantErr  bool
+	}{
+		{name: "disabled", activity: Activity{}},
+		{name: "disabled with types", activity: Activity{LoginTypes: DefaultLoginActivityTypes}},
+		{name: "logins", activity: Activity{Lookback: time.Hour, LoginTypes: DefaultLoginActivityTypes}},
+		{name: "api activity", activity: Activity{Lookback: time.Hour, APITypes: []int{80}}},
+		{name: "negative lookback", activity: Activity{Lookback: -time.Hour, LoginTypes: DefaultLoginActivityTypes}, wantErr: true},
+		{name: "lookback without types", activity: Activity{Lookback: time.Hour}, wantErr: true},
+		{name: "negative login type", activity: Activity{Lookback: time.Hour, LoginTypes: []int{-1}}, wantErr: true},
+		{name: "negative api type", activity: Activity{Lookback: time.Hour, LoginTypes: []int{27}, APITypes: []int{-80}}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.activity.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// profiles lists the users and returns their profiles by id.
+func profiles(t *testing.T, s *SentinelOne) map[string]map[string]interface{} {
+	rv := make(map[string]map[string]interface{})
+	for _, resource := range listResources(t, syncer(t, s, resourceTypeUser), nil) {
+		trait, err := rs.GetUserTrait(resource)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rv[resource.Id.Resource] = trait.GetProfile().AsMap()
+	}
+
+	return rv
+}
+
+func TestActivityProfile(t *testing.T) {
+	const (
+		loginType = 27
+		apiType   = 80
+	)
+
+	f := newFakeConsole(t)
+	f.users = []sentinelone.User{{ID: "u1", Scope: scopeTenant}, {ID: "u2", Scope: scopeTenant}}
+	f.activities = []sentinelone.Activity{
+		{UserID: "u1", ActivityType: loginType, CreatedAt: "2026-10-01T08:00:00Z"},
+		{UserID: "u1", ActivityType: loginType, CreatedAt: "2026-10-03T08:00:00.5Z"},
+		{UserID: "u1", ActivityType: loginType, CreatedAt: "2026-10-02T08:00:00Z"},
+		{UserID: "u1", ActivityType: apiType, CreatedAt: "2026-10-02T09:30:00Z"},
+		// neither a login nor api usage.
+		{UserID: "u2", ActivityType: 99, CreatedAt: "2026-10-04T08:00:00Z"},
+		{UserID: "u2", ActivityType: loginType, CreatedAt: "yesterday"},
+		// an activity of the console itself.
+		{ActivityType: apiType, CreatedAt: "2026-10-04T08:00:00Z"},
+	}
+	s := f.newConnector(t,
+		WithDisabledResourceTypes(resourceTypeAccount.Id),
+		WithActivity(Activity{Lookback: 720 * time.Hour, LoginTypes: []int{loginType}, APITypes: []int{apiType}}),
+	)
+
+	got := profiles(t, s)
+	if login := got["u1"][lastLoginProfileKey]; login != "2026-10-03T08:00:00Z" {
+		t.Errorf("last login of u1 = %v, want the latest login", login)
+	}
+	if api := got["u1"][lastAPIActivityProfileKey]; api != "2026-10-02T09:30:00Z" {
+		t.Errorf("last api activity of u1 = %v, want the api usage", api)
+	}
+	for _, key := range []string{lastLoginProfileKey, lastAPIActivityProfileKey} {
+		if v, ok := got["u2"][key]; ok {
+			t.Errorf("u2 has %s %v without such activity", key, v)
+		}
+	}
+
+	// the activities are read once, the next listings reuse them.
+	profiles(t, s)
+	sent := f.sent(http.MethodGet, "activities")
+	if len(sent) != 1 {
+		t.Fatalf("sent %d activities requests, want 1", len(sent))
+	}
+	if types := sent[0].Query.Get(activityTypesFilter); types != "27,80" {
+		t.Errorf("activity types filter = %q, want 27,80", types)
+	}
+	since, err := time.Parse(time.RFC3339Nano, sent[0].Query.Get(createdSince))
+	if err != nil {
+		t.Fatalf("invalid %s filter: %v", createdSince, err)
+	}
+	if lookback := time.Since(since); lookback < 720*time.Hour || lookback > 721*time.Hour {
+		t.Errorf("activities read since %v, want 30 days ago", since)
+	}
+}
+
+func TestActivityForbidden(t *testing.T) {
+	f := newFakeConsole(t)
+	f.users = []sentinelone.User{{ID: "u1", Scope: scopeTenant}}
+	f.activities = []sentinelone.Activity{{UserID: "u1", ActivityType: 27, CreatedAt: "2026-10-01T08:00:00Z"}}
+	f.setStatus("activities", http.StatusForbidden)
+	s := f.newConnector(t,
+		WithDisabledResourceTypes(resourceTypeAccount.Id),
+		WithActivity(Activity{Lookback: time.Hour, LoginTypes: DefaultLoginActivityTypes}),
+	)
+
+	got := profiles(t, s)
+	if _, ok := got["u1"]; !ok {
+		t.Fatal("user not listed when the activities can't be read")
+	}
+	if login, ok := got["u1"][lastLoginProfileKey]; ok {
+		t.Errorf("last login = %v without the activities", login)
+	}
+}
+
+func TestActivityDisabled(t *testing.T) {
+	f := newFakeConsole(t)
+	f.users = []sentinelone.User{{ID: "u1", Scope: scopeTenant}}
+	s := f.newConnector(t, WithDisabledResourceTypes(resourceTypeAccount.Id))
+
+	profiles(t, s)
+	if sent := f.sent(http.MethodGet, "activities"); len(sent) != 0 {
+		t.Errorf("sent %d activities requests without a lookback", len(sent))
+	}
+
+	var a *activityLog
+	profile := map[string]interface{}{}
+	a.addToProfile(profile, "u1")
+	if len(profile) != 0 {
+		t.Errorf("profile = %v, want it untouched", profile)
+	}
+}
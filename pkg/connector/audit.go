@@ -0,0 +1,372 @@
+package connector
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+	"go.uber.org/zap"
+
+	"github.com/conductorone/baton-sentinel-one/pkg/sentinelone"
+)
+
+const (
+	createdBefore = "createdAt__lt"
+	sortBy        = "sortBy"
+	sortOrder     = "sortOrder"
+
+	// auditPageSize is the number of activities per page, each page is written before the cursor moves past it.
+	auditPageSize = 100
+)
+
+// AuditRange is the part of the activities log exported by ExportAudit.
+type AuditRange struct {
+	Since time.Time `json:"since"`
+	Until time.Time `json:"until"`
+	// ActivityTypes are the exported activity type ids. When empty, the creations, changes and deletions of users,
+	// service users, roles and api tokens are picked from the activity types of each console.
+	ActivityTypes []int `json:"activity_types,omitempty"`
+}
+
+// Validate returns an error if the range is empty or an activity type is invalid.
+func (r AuditRange) Validate() error {
+	if r.Since.IsZero() {
+		return fmt.Errorf("audit export requires the start of the time range")
+	}
+
+	if !r.Until.After(r.Since) {
+		return fmt.Errorf("audit export time range must end after it starts")
+	}
+
+	for _, activityType := range r.ActivityTypes {
+		if activityType < 0 {
+			return fmt.Errorf("invalid activity type %d", activityType)
+		}
+	}
+
+	return nil
+}
+
+func (r AuditRange) equal(other AuditRange) bool {
+	if !r.Since.Equal(other.Since) || !r.Until.Equal(other.Until) || len(r.ActivityTypes) != len(other.ActivityTypes) {
+		return false
+	}
+
+	for i, activityType := range r.ActivityTypes {
+		if other.ActivityTypes[i] != activityType {
+			return false
+		}
+	}
+
+	return true
+}
+
+// AuditCursor is how far an audit export went. It is saved after every page, so an interrupted export resumes where it stopped
+// and the next one starts where the last one ended.
+type AuditCursor struct {
+	// Range is the range being exported, nil once it was exported completely.
+	Range *AuditRange `json:"range,omitempty"`
+	// Positions is the position of every api token of every console in the range.
+	Positions map[string]AuditPosition `json:"positions,omitempty"`
+	// ExportedUntil is the end of the last range exported completely.
+	ExportedUntil *time.Time `json:"exported_until,omitempty"`
+}
+
+// AuditPosition is the cursor of the next page of the activities read with an api token.
+type AuditPosition struct {
+	Cursor string `json:"cursor,omitempty"`
+	Done   bool   `json:"done,omitempty"`
+}
+
+// Start sets the range to export, unless it is the range of an interrupted export, which then resumes.
+// It returns whether the export resumes.
+func (c *AuditCursor) Start(r AuditRange) bool {
+	if c.Range != nil && c.Range.equal(r) {
+		return true
+	}
+
+	c.Range = &r
+	c.Positions = nil
+
+	return false
+}
+
+// LoadAuditCursor reads the cursor saved by an earlier audit export, an empty cursor if there is none.
+func LoadAuditCursor(path string) (*AuditCursor, error) {
+	rv := &AuditCursor{}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return rv, nil
+		}
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, rv); err != nil {
+		return nil, fmt.Errorf("failed to read audit cursor %s: %w", path, err)
+	}
+
+	return rv, nil
+}
+
+// SaveAuditCursor writes the cursor of the audit export.
+func SaveAuditCursor(path string, cursor *AuditCursor) error {
+	return writeReport(path, cursor)
+}
+
+// AuditEvent is a change of the access to a console, normalized from the activities log.
+type AuditEvent struct {
+	Console      string `json:"console,omitempty"`
+	ID           string `json:"id"`
+	Timestamp    string `json:"timestamp"`
+	ActivityType int    `json:"activity_type"`
+	Action       string `json:"action"`
+	ActorID      string `json:"actor_id,omitempty"`
+	ActorName    string `json:"actor_name,omitempty"`
+	// TargetType is user, service_user or api_token.
+	TargetType string `json:"target_type,omitempty"`
+	TargetID   string `json:"target_id,omitempty"`
+	TargetName string `json:"target_name,omitempty"`
+	// Scope is tenant, account or site.
+	Scope       string `json:"scope"`
+	ScopeID     string `json:"scope_id,omitempty"`
+	ScopeName   string `json:"scope_name,omitempty"`
+	RoleBefore  string `json:"role_before,omitempty"`
+	RoleAfter   string `json:"role_after,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+// The details of an activity are in its data, whose keys depend on the activity type and the console release.
+// The first key found is used.
+var (
+	auditActorNameKeys  = []string{"username", "userName"}
+	auditTargetIDKeys   = []string{"targetUserId", "affectedUserId", "serviceUserId"}
+	auditTargetNameKeys = []string{"targetUserName", "affectedUserName", "serviceUserName", "fullName", "email", "name"}
+	auditRoleBeforeKeys = []string{"oldRole", "oldRoleName", "previousRole", "previousRoleName"}
+	auditRoleAfterKeys  = []string{"newRole", "newRoleName", "role", "roleName"}
+)
+
+// ExportAudit reads the activities log of the range of the progress cursor with every api token of every console, oldest first.
+// fn is called with the events of every page and the cursor past the page: the cursor must only be saved once the events are
+// written. It is called once more without events when the range is exported completely.
+// An activity seen with several tokens is exported by the token owning its scope.
+func (s *SentinelOne) ExportAudit(ctx context.Context, progress *AuditCursor, fn func([]AuditEvent, *AuditCursor) error) error {
+	if progress.Range == nil {
+		return fmt.Errorf("audit cursor has no time range")
+	}
+
+	if err := progress.Range.Validate(); err != nil {
+		return err
+	}
+
+	if progress.Positions == nil {
+		progress.Positions = make(map[string]AuditPosition)
+	}
+
+	for _, c := range s.consoles.all {
+		if err := exportConsoleAudit(ctx, c, progress, fn); err != nil {
+			return err
+		}
+	}
+
+	until := progress.Range.Until
+	progress.Range = nil
+	progress.Positions = nil
+	progress.ExportedUntil = &until
+
+	return fn(nil, progress)
+}
+
+func exportConsoleAudit(ctx context.Context, c *console, progress *AuditCursor, fn func([]AuditEvent, *AuditCursor) error) error {
+	l := ctxzap.Extract(ctx).With(zap.String("console", c.name))
+
+	activityTypes, actions, err := auditActivityTypes(ctx, c, progress.Range.ActivityTypes)
+	if err != nil {
+		return err
+	}
+
+	if len(activityTypes) == 0 {
+		l.Warn("no activity type to export")
+		return nil
+	}
+
+	for i, rt := range c.routes.all {
+		key := fmt.Sprintf("%s#%d", c.name, i+1)
+		position := progress.Positions[key]
+
+		for !position.Done {
+			activities, next, err := rt.client.GetActivities(ctx, sentinelone.ParamsMap{
+				activityTypesFilter: strings.Join(activityTypes, ","),
+				createdSince:        progress.Range.Since.UTC().Format(time.RFC3339Nano),
+				createdBefore:       progress.Range.Until.UTC().Format(time.RFC3339Nano),
+				sortBy:              "createdAt",
+				sortOrder:           "asc",
+				limit:               strconv.Itoa(auditPageSize),
+				cursor:              position.Cursor,
+			})
+			if err != nil {
+				if sentinelone.IsForbidden(err) {
+					l.Warn("api token can't read the activities, skipping them", zap.Int("api_token", i+1), zap.Error(err))
+					progress.Positions[key] = AuditPosition{Done: true}
+					break
+				}
+				return fmt.Errorf("failed to list activities: %w", err)
+			}
+
+			var events []AuditEvent
+			for _, activity := range activities {
+				owned, err := ownsActivity(ctx, c, i, activity)
+				if err != nil {
+					return err
+				}
+				if owned {
+					events = append(events, auditEvent(c, activity, actions))
+				}
+			}
+
+			position = AuditPosition{Cursor: next, Done: next == ""}
+			progress.Positions[key] = position
+			if err := fn(events, progress); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// auditActivityTypes returns the activity types to export and the action of every activity type of the console.
+func auditActivityTypes(ctx context.Context, c *console, selected []int) ([]string, map[int]string, error) {
+	types, err := c.routes.first().GetActivityTypes(ctx)
+	if err != nil {
+		if len(selected) == 0 {
+			return nil, nil, fmt.Errorf("failed to list activity types, give the activity types to export instead: %w", err)
+		}
+		ctxzap.Extract(ctx).Warn("failed to list activity types, the actions are not exported", zap.String("console", c.name), zap.Error(err))
+	}
+
+	pick := len(selected) == 0
+	actions := make(map[int]string, len(types))
+	for _, t := range types {
+		actions[t.ID] = t.Action
+		if pick && isAuditAction(t.Action) {
+			selected = append(selected, t.ID)
+		}
+	}
+
+	rv := make([]string, 0, len(selected))
+	for _, activityType := range selected {
+		rv = append(rv, strconv.Itoa(activityType))
+	}
+
+	return rv, actions, nil
+}
+
+// isAuditAction reports whether the action changes the access to the console, e.g. "User Modified" or "Service User Created".
+func isAuditAction(action string) bool {
+	a := strings.ToLower(action)
+	if strings.Contains(a, "log") || !strings.Contains(a, "user") && !strings.Contains(a, "role") && !strings.Contains(a, "api token") {
+		return false
+	}
+
+	for _, verb := range []string{"creat", "add", "delet", "remov", "modif", "updat", "chang", "edit", "assign", "generat", "revok"} {
+		if strings.Contains(a, verb) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ownsActivity reports whether the activity is exported with the token, the token that owns the scope of the activity.
+// An activity whose scope no token can read anymore, e.g. a deleted site, is exported by every token that sees it.
+func ownsActivity(ctx context.Context, c *console, index int, activity sentinelone.Activity) (bool, error) {
+	if c.routes.single() {
+		return true, nil
+	}
+
+	var (
+		owner int
+		err   error
+	)
+	switch scope, scopeID, _ := activityScope(activity); scope {
+	case scopeSite:
+		owner, err = c.routes.siteOwner(ctx, scopeID)
+	case scopeAccount:
+		owner, err = c.routes.accountOwner(ctx, scopeID)
+	default:
+		owner, err = c.routes.principalOwner(ctx, scopeTenant, nil)
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return owner < 0 || owner == index, nil
+}
+
+func activityScope(activity sentinelone.Activity) (string, string, string) {
+	switch {
+	case activity.SiteID != "":
+		return scopeSite, activity.SiteID, activity.SiteName
+	case activity.AccountID != "":
+		return scopeAccount, activity.AccountID, activity.AccountName
+	default:
+		return scopeTenant, "", ""
+	}
+}
+
+func auditEvent(c *console, activity sentinelone.Activity, actions map[int]string) AuditEvent {
+	action := actions[activity.ActivityType]
+	scope, scopeID, scopeName := activityScope(activity)
+
+	rv := AuditEvent{
+		Console:      c.name,
+		ID:           activity.ID,
+		Timestamp:    activity.CreatedAt,
+		ActivityType: activity.ActivityType,
+		Action:       action,
+		ActorID:      activity.UserID,
+		ActorName:    activityData(activity, auditActorNameKeys),
+		TargetID:     activityData(activity, auditTargetIDKeys),
+		TargetName:   activityData(activity, auditTargetNameKeys),
+		Scope:        scope,
+		ScopeID:      scopeID,
+		ScopeName:    scopeName,
+		RoleBefore:   activityData(activity, auditRoleBeforeKeys),
+		RoleAfter:    activityData(activity, auditRoleAfterKeys),
+		Description:  activity.PrimaryDescription,
+	}
+
+	switch a := strings.ToLower(action); {
+	case strings.Contains(a, "service user"):
+		rv.TargetType = resourceTypeServiceUser.Id
+	case strings.Contains(a, "api token"):
+		rv.TargetType = "api_token"
+	case strings.Contains(a, "user"), strings.Contains(a, "role"):
+		rv.TargetType = resourceTypeUser.Id
+	}
+
+	return rv
+}
+
+func activityData(activity sentinelone.Activity, keys []string) string {
+	for _, key := range keys {
+		switch v := activity.Data[key].(type) {
+		case string:
+			if v != "" {
+				return v
+			}
+		case float64:
+			return strconv.FormatFloat(v, 'f', -1, 64)
+		}
+	}
+
+	return ""
+}
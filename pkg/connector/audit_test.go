@@ -0,0 +1,279 @@
+package connector
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/conductorone/baton-sentinel-one/pkg/sentinelone"
+)
+
+func TestAuditRangeValidate(t *testing.T) {
+	since := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		r       AuditRange
+		wantErr bool
+	}{
+		{name: "range", r: AuditRange{Since: since, Until: since.Add(time.Hour)}},
+		{name: "activity types", r: AuditRange{Since: since, Until: since.Add(time.Hour), ActivityTypes: []int{23, 24}}},
+		{name: "no start", r: AuditRange{Until: since}, wantErr: true},
+		{name: "no end", r: AuditRange{Since: since}, wantErr: true},
+		{name: "empty range", r: AuditRange{Since: since, Until: since}, wantErr: true},
+		{name: "ends before it starts", r: AuditRange{Since: since, Until: since.Add(-time.Hour)}, wantErr: true},
+		{name: "negative activity type", r: AuditRange{Since: since, Until: since.Add(time.Hour), ActivityTypes: []int{-1}}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.r.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAuditCursorStart(t *testing.T) {
+	since := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	r := AuditRange{Since: since, Until: since.Add(time.Hour), ActivityTypes: []int{23}}
+
+	tests := []struct {
+		name        string
+		r           AuditRange
+		wantResumed bool
+	}{
+		{name: "same range", r: r, wantResumed: true},
+		{name: "same range in another zone", r: AuditRange{Since: since.In(time.FixedZone("CEST", 2*3600)), Until: r.Until, ActivityTypes: []int{23}}, wantResumed: true},
+		{name: "later end", r: AuditRange{Since: since, Until: since.Add(2 * time.Hour), ActivityTypes: []int{23}}},
+		{name: "other activity types", r: AuditRange{Since: since, Until: r.Until, ActivityTypes: []int{24}}},
+		{name: "all activity types", r: AuditRange{Since: since, Until: r.Until}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			started := r
+			cursor := &AuditCursor{Range: &started, Positions: map[string]AuditPosition{"#1": {Cursor: "next"}}}
+
+			if resumed := cursor.Start(tt.r); resumed != tt.wantResumed {
+				t.Fatalf("Start() = %v, want %v", resumed, tt.wantResumed)
+			}
+			if tt.wantResumed != (cursor.Positions != nil) {
+				t.Errorf("positions = %v, kept %v, want %v", cursor.Positions, !tt.wantResumed, tt.wantResumed)
+			}
+			if !cursor.Range.equal(tt.r) {
+				t.Errorf("range = %+v, want %+v", cursor.Range, tt.r)
+			}
+		})
+	}
+}
+
+func TestAuditCursorFile(t *testing.T) {
+	dir := t.TempDir()
+
+	cursor, err := LoadAuditCursor(filepath.Join(dir, "missing.json"))
+	if err != nil || cursor.Range != nil || cursor.ExportedUntil != nil {
+		t.Fatalf("LoadAuditCursor() of a missing file = %+v, %v, want an empty cursor", cursor, err)
+	}
+
+	invalid := filepath.Join(dir, "invalid.json")
+	if err := os.WriteFile(invalid, []byte("{"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadAuditCursor(invalid); err == nil {
+		t.Error("expected an error for an invalid cursor")
+	}
+
+	since := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	until := since.Add(-time.Hour)
+	want := &AuditCursor{
+		Range:         &AuditRange{Since: since, Until: since.Add(time.Hour), ActivityTypes: []int{23}},
+		Positions:     map[string]AuditPosition{"#1": {Cursor: "next"}, "#2": {Done: true}},
+		ExportedUntil: &until,
+	}
+	path := filepath.Join(dir, "cursor.json")
+	if err := SaveAuditCursor(path, want); err != nil {
+		t.Fatalf("SaveAuditCursor() error = %v", err)
+	}
+
+	got, err := LoadAuditCursor(path)
+	if err != nil {
+		t.Fatalf("LoadAuditCursor() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("LoadAuditCursor() = %+v, want %+v", got, want)
+	}
+}
+
+// TestExportAuditResume interrupts an export after its first page, and resumes it from the saved cursor.
+func TestExportAuditResume(t *testing.T) {
+	f := newFakeConsole(t)
+	f.pageSize = 2
+	f.activityTypes = []sentinelone.ActivityType{
+		{ID: 23, Action: "User Modified"},
+		{ID: 24, Action: "User Deleted"},
+		{ID: 27, Action: "User Logged In"},
+	}
+	for _, id := range []string{"1", "2", "3", "4", "5"} {
+		f.activities = append(f.activities, sentinelone.Activity{
+			ID:           id,
+			ActivityType: 23,
+			CreatedAt:    "2026-10-01T0" + id + ":00:00Z",
+			UserID:       "admin",
+			AccountID:    "a1",
+			Data:         map[string]interface{}{"targetUserId": "u" + id, "oldRole": "Viewer", "newRole": "Admin"},
+		})
+	}
+	s := f.newConnector(t)
+
+	path := filepath.Join(t.TempDir(), "cursor.json")
+	since := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	r := AuditRange{Since: since, Until: since.Add(24 * time.Hour)}
+	interrupted := errors.New("interrupted")
+
+	var exported []string
+	export := func(stopAfter int) error {
+		progress, err := LoadAuditCursor(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		progress.Start(r)
+
+		pages := 0
+		return s.ExportAudit(context.Background(), progress, func(events []AuditEvent, progress *AuditCursor) error {
+			if pages == stopAfter {
+				return interrupted
+			}
+			pages++
+
+			for _, event := range events {
+				exported = append(exported, event.ID)
+			}
+			return SaveAuditCursor(path, progress)
+		})
+	}
+
+	if err := export(1); !errors.Is(err, interrupted) {
+		t.Fatalf("ExportAudit() error = %v, want the interruption", err)
+	}
+	if want := []string{"1", "2"}; !reflect.DeepEqual(exported, want) {
+		t.Fatalf("exported %v before the interruption, want %v", exported, want)
+	}
+
+	progress, err := LoadAuditCursor(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !progress.Start(r) {
+		t.Fatal("interrupted export not resumed")
+	}
+
+	f.mtx.Lock()
+	f.requests = nil
+	f.mtx.Unlock()
+
+	if err := export(-1); err != nil {
+		t.Fatalf("ExportAudit() error = %v", err)
+	}
+	if want := []string{"1", "2", "3", "4", "5"}; !reflect.DeepEqual(exported, want) {
+		t.Errorf("exported %v, want every activity once", exported)
+	}
+
+	sent := f.sent(http.MethodGet, "activities")
+	if len(sent) == 0 || sent[0].Query.Get(cursor) != "2" {
+		t.Fatalf("resumed export sent %v, want it to start at the saved cursor", sent)
+	}
+	query := sent[0].Query
+	if query.Get(activityTypesFilter) != "23,24" || query.Get(sortOrder) != "asc" ||
+		query.Get(createdSince) != "2026-10-01T00:00:00Z" || query.Get(createdBefore) != "2026-10-02T00:00:00Z" {
+		t.Errorf("activities query = %v", query)
+	}
+
+	done, err := LoadAuditCursor(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if done.Range != nil || done.Positions != nil || done.ExportedUntil == nil || !done.ExportedUntil.Equal(r.Until) {
+		t.Errorf("cursor of the complete export = %+v, want it exported until %v", done, r.Until)
+	}
+}
+
+func TestAuditEvent(t *testing.T) {
+	actions := map[int]string{23: "User Modified", 50: "Service User Created", 60: "API Token Revoked"}
+
+	tests := []struct {
+		name     string
+		activity sentinelone.Activity
+		want     AuditEvent
+	}{
+		{
+			name: "role change",
+			activity: sentinelone.Activity{
+				ID: "1", ActivityType: 23, CreatedAt: "2026-10-01T08:00:00Z", UserID: "admin", SiteID: "s1", SiteName: "Paris",
+				Data: map[string]interface{}{"username": "Jane", "targetUserId": "u1", "email": "john@acme.com", "oldRoleName": "Viewer", "role": "Admin"},
+			},
+			want: AuditEvent{
+				ID: "1", Timestamp: "2026-10-01T08:00:00Z", ActivityType: 23, Action: "User Modified", ActorID: "admin", ActorName: "Jane",
+				TargetType: resourceTypeUser.Id, TargetID: "u1", TargetName: "john@acme.com", Scope: scopeSite, ScopeID: "s1", ScopeName: "Paris",
+				RoleBefore: "Viewer", RoleAfter: "Admin",
+			},
+		},
+		{
+			name: "service user with a numeric id",
+			activity: sentinelone.Activity{
+				ID: "2", ActivityType: 50, AccountID: "a1", AccountName: "Acme",
+				Data: map[string]interface{}{"serviceUserId": float64(1234567890123), "serviceUserName": "ci"},
+			},
+			want: AuditEvent{
+				ID: "2", ActivityType: 50, Action: "Service User Created", TargetType: resourceTypeServiceUser.Id,
+				TargetID: "1234567890123", TargetName: "ci", Scope: scopeAccount, ScopeID: "a1", ScopeName: "Acme",
+			},
+		},
+		{
+			name:     "api token",
+			activity: sentinelone.Activity{ID: "3", ActivityType: 60, PrimaryDescription: "Jane revoked the token of John"},
+			want: AuditEvent{
+				ID: "3", ActivityType: 60, Action: "API Token Revoked", TargetType: "api_token", Scope: scopeTenant,
+				Description: "Jane revoked the token of John",
+			},
+		},
+		{
+			name:     "unknown activity type",
+			activity: sentinelone.Activity{ID: "4", ActivityType: 99, Data: map[string]interface{}{"targetUserId": ""}},
+			want:     AuditEvent{ID: "4", ActivityType: 99, Scope: scopeTenant},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := auditEvent(&console{}, tt.activity, actions); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("auditEvent() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsAuditAction(t *testing.T) {
+	tests := map[string]bool{
+		"User Modified":           true,
+		"Service User Created":    true,
+		"Role Assigned":           true,
+		"API Token Generated":     true,
+		"User Logged In":          false,
+		"User Logout":             false,
+		"Agent Uninstalled":       false,
+		"Threat Mitigated":        false,
+		"User Viewed a Dashboard": false,
+	}
+
+	for action, want := range tests {
+		if got := isAuditAction(action); got != want {
+			t.Errorf("isAuditAction(%q) = %v, want %v", action, got, want)
+		}
+	}
+}
@@ -2,23 +2,37 @@ package connector
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"net/url"
+	"net/http"
 
 	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
 	"github.com/conductorone/baton-sdk/pkg/annotations"
 	"github.com/conductorone/baton-sdk/pkg/connectorbuilder"
-	"github.com/conductorone/baton-sdk/pkg/uhttp"
+	"github.com/conductorone/baton-sdk/pkg/pagination"
 	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
 
 	"github.com/conductorone/baton-sentinel-one/pkg/sentinelone"
 )
 
 type SentinelOne struct {
-	client *sentinelone.Client
+	consoles          *consoleSet
+	types             resourceTypeSet
+	requestsPerSecond int
+	apiVersion        string
+	tracer            trace.Tracer
+	lifecycle         *syncLifecycle
 }
 
 var (
+	resourceTypeConsole = &v2.ResourceType{
+		Id:          "console",
+		DisplayName: "Management Console",
+		Annotations: skipEntitlementsAndGrants(),
+	}
 	resourceTypeAccount = &v2.ResourceType{
 		Id:          "account",
 		DisplayName: "Account",
@@ -29,7 +43,6 @@ var (
 		Traits: []v2.ResourceType_Trait{
 			v2.ResourceType_TRAIT_USER,
 		},
-		Annotations: annotationsForUserResourceType(),
 	}
 	resourceTypeServiceUser = &v2.ResourceType{
 		Id:          "service_user",
@@ -37,7 +50,7 @@ var (
 		Traits: []v2.ResourceType_Trait{
 			v2.ResourceType_TRAIT_USER,
 		},
-		Annotations: annotationsForUserResourceType(),
+		Annotations: skipEntitlementsAndGrants(),
 	}
 	resourceTypeRole = &v2.ResourceType{
 		Id:          "role",
@@ -50,16 +63,83 @@ var (
 		Id:          "site",
 		DisplayName: "Site",
 	}
+
+	allResourceTypes = map[string]*v2.ResourceType{
+		resourceTypeAccount.Id:     resourceTypeAccount,
+		resourceTypeUser.Id:        resourceTypeUser,
+		resourceTypeServiceUser.Id: resourceTypeServiceUser,
+		resourceTypeRole.Id:        resourceTypeRole,
+		resourceTypeSite.Id:        resourceTypeSite,
+	}
 )
 
+// resourceTypeSet holds the ids of the resource types whose syncers are enabled.
+type resourceTypeSet map[string]struct{}
+
+func newResourceTypeSet(disabled []string) resourceTypeSet {
+	skip := toSet(disabled)
+	rv := make(resourceTypeSet, len(allResourceTypes))
+	for id := range allResourceTypes {
+		if _, ok := skip[id]; !ok {
+			rv[id] = struct{}{}
+		}
+	}
+
+	return rv
+}
+
+func (s resourceTypeSet) enabled(resourceType *v2.ResourceType) bool {
+	_, ok := s[resourceType.Id]
+	return ok
+}
+
+// only returns the enabled resource types, keeping their order.
+func (s resourceTypeSet) only(resourceTypes ...*v2.ResourceType) []*v2.ResourceType {
+	rv := make([]*v2.ResourceType, 0, len(resourceTypes))
+	for _, resourceType := range resourceTypes {
+		if s.enabled(resourceType) {
+			rv = append(rv, resourceType)
+		}
+	}
+
+	return rv
+}
+
+// pushEnabled pushes a page state for every enabled resource type, so disabled principal types are skipped by grant fan-outs.
+func (s resourceTypeSet) pushEnabled(bag *pagination.Bag, resourceTypes ...*v2.ResourceType) {
+	for _, resourceType := range s.only(resourceTypes...) {
+		bag.Push(pagination.PageState{
+			ResourceTypeID: resourceType.Id,
+		})
+	}
+}
+
 func (s *SentinelOne) ResourceSyncers(ctx context.Context) []connectorbuilder.ResourceSyncer {
-	return []connectorbuilder.ResourceSyncer{
-		accountBuilder(s.client),
-		userBuilder(s.client),
-		serviceUserBuilder(s.client),
-		roleBuilder(s.client),
-		siteBuilder(s.client),
+	syncers := []connectorbuilder.ResourceSyncer{
+		accountBuilder(s.consoles, s.types),
+		userBuilder(s.consoles, s.types),
+		serviceUserBuilder(s.consoles, s.types),
+		roleBuilder(s.consoles, s.types),
+		siteBuilder(s.consoles, s.types),
+	}
+
+	var rv []connectorbuilder.ResourceSyncer
+	// several consoles are synced as separate tenant roots.
+	if s.consoles.multi() {
+		rv = append(rv, consoleBuilder(s.consoles, s.types))
+	}
+
+	for _, syncer := range syncers {
+		if s.types.enabled(syncer.ResourceType(ctx)) {
+			rv = append(rv, syncer)
+		}
 	}
+
+	for i, syncer := range rv {
+		rv[i] = newTracedSyncer(syncer, s.tracer, s.lifecycle)
+	}
+
+	return rv
 }
 
 func (s *SentinelOne) Metadata(ctx context.Context) (*v2.ConnectorMetadata, error) {
@@ -71,60 +151,301 @@ func (s *SentinelOne) Metadata(ctx context.Context) (*v2.ConnectorMetadata, erro
 
 // Validates that the user has access to all relevant resources.
 // It's not defined which role is needed to fetch all resources so we need to check that user has access to all of them.
+// Only the enabled resource types are checked, the ones the token gets a 403 for are skipped by the syncers and reported in the annotations.
+// When several consoles are synced, the consoles that fail are skipped and only a failure of all of them is an error.
 func (s *SentinelOne) Validate(ctx context.Context) (annotations.Annotations, error) {
-	_, _, err := s.client.GetAccounts(ctx, sentinelone.ParamsMap{
-		"limit": "1",
-	})
-	if err != nil {
-		return nil, fmt.Errorf("failed to get accounts: %w", err)
+	if err := s.consoles.validate(ctx, s.validateConsole); err != nil {
+		return nil, err
 	}
 
-	_, _, err = s.client.GetSites(ctx, sentinelone.ParamsMap{
-		"limit": "1",
-	})
-	if err != nil {
-		return nil, fmt.Errorf("failed to get sites: %w", err)
+	skipped := make(map[string]string)
+	for _, c := range s.consoles.all {
+		if !c.isAvailable() {
+			continue
+		}
+		for _, rt := range c.routes.all {
+			for resourceTypeID, reason := range rt.deniedResourceTypes() {
+				skipped[resourceTypeID] = reason
+			}
+		}
 	}
-	_, _, err = s.client.GetUsers(ctx, sentinelone.ParamsMap{
-		"limit": "1",
-	})
-	if err != nil {
-		return nil, fmt.Errorf("failed to get users: %w", err)
+
+	return skippedAnnotations(skipped)
+}
+
+// validateConsole checks the api version and every api token of the console and discovers their scopes.
+func (s *SentinelOne) validateConsole(ctx context.Context, c *console) error {
+	if err := s.checkAPIVersion(ctx, c); err != nil {
+		return err
 	}
 
-	_, _, err = s.client.GetServiceUsers(ctx, sentinelone.ParamsMap{
-		"limit": "1",
-	})
-	if err != nil {
-		return nil, fmt.Errorf("failed to get service users: %w", err)
+	for i, rt := range c.routes.all {
+		if err := s.validateRoute(ctx, rt); err != nil {
+			if c.routes.single() {
+				return err
+			}
+			return fmt.Errorf("api token %d: %w", i+1, err)
+		}
 	}
 
-	_, _, err = s.client.GetPredefinedRoles(ctx, sentinelone.ParamsMap{
+	return c.routes.discover(ctx)
+}
+
+// checkAPIVersion makes sure the console serves the configured api version, and logs the release of the console.
+func (s *SentinelOne) checkAPIVersion(ctx context.Context, c *console) error {
+	l := ctxzap.Extract(ctx)
+
+	info, err := c.routes.first().GetSystemInfo(ctx)
+	if err == nil {
+		l.Info("management console version",
+			zap.String("url", c.url),
+			zap.String("release", info.Release),
+			zap.String("build", info.Build),
+			zap.String("api_version", s.apiVersion),
+		)
+		return nil
+	}
+
+	var apiErr *sentinelone.APIError
+	if !errors.As(err, &apiErr) {
+		return fmt.Errorf("failed to reach management console %s: %w", c.url, err)
+	}
+
+	switch apiErr.StatusCode {
+	case http.StatusNotFound:
+		return fmt.Errorf("management console %s doesn't serve api version %s, check the api version and the console url", c.url, s.apiVersion)
+	case http.StatusUnauthorized, http.StatusForbidden:
+		// the token is checked by the probes that follow, the version check is only skipped.
+		l.Warn("can't check the api version of the management console", zap.String("url", c.url), zap.Error(err))
+		return nil
+	default:
+		return fmt.Errorf("failed to get system info of management console %s: %w", c.url, err)
+	}
+}
+
+// validateRoute reads one page of every enabled resource type. A 403 only disables the resource type for the token,
+// the token is rejected when it can't read any of them.
+func (s *SentinelOne) validateRoute(ctx context.Context, rt *route) error {
+	params := sentinelone.ParamsMap{
 		"limit": "1",
-	})
-	if err != nil {
-		return nil, fmt.Errorf("failed to get roles: %w", err)
 	}
 
-	return nil, nil
+	probes := []struct {
+		resourceType *v2.ResourceType
+		name         string
+		probe        func() error
+	}{
+		{resourceTypeAccount, "accounts", func() error {
+			_, _, err := rt.client.GetAccounts(ctx, params)
+			return err
+		}},
+		{resourceTypeSite, "sites", func() error {
+			_, _, err := rt.client.GetSites(ctx, params)
+			return err
+		}},
+		{resourceTypeUser, "users", func() error {
+			_, _, err := rt.client.GetUsers(ctx, params)
+			return err
+		}},
+		{resourceTypeServiceUser, "service users", func() error {
+			_, _, err := rt.client.GetServiceUsers(ctx, params)
+			return err
+		}},
+		{resourceTypeRole, "roles", func() error {
+			_, _, err := rt.client.GetPredefinedRoles(ctx, params)
+			return err
+		}},
+	}
+
+	var (
+		enabled int
+		denied  int
+	)
+	for _, p := range probes {
+		if !s.types.enabled(p.resourceType) {
+			continue
+		}
+		enabled++
+
+		if err := p.probe(); err != nil {
+			if rt.forbidden(ctx, p.resourceType.Id, err) {
+				denied++
+				continue
+			}
+			return fmt.Errorf("failed to get %s: %w", p.name, err)
+		}
+	}
+
+	if enabled > 0 && denied == enabled {
+		return fmt.Errorf("api token can't read any of the enabled resource types")
+	}
+
+	return nil
 }
 
-// New returns the SentinelOne connector.
-func New(ctx context.Context, baseUrl, token string) (*SentinelOne, error) {
-	httpClient, err := uhttp.NewClient(ctx, uhttp.WithLogger(true, ctxzap.Extract(ctx)))
-	if err != nil {
+// New returns the SentinelOne connector syncing the given management consoles.
+func New(ctx context.Context, consoles []Console, opts ...Option) (*SentinelOne, error) {
+	return newSentinelOne(ctx, consoles, false, opts...)
+}
+
+// NewReadOnly returns a connector for the commands that only read the consoles, e.g. diagnose.
+// Unlike New it leaves the reports and the incremental sync state of the syncs alone, doesn't watch the
+// api token files and never sends a write.
+func NewReadOnly(ctx context.Context, consoles []Console, opts ...Option) (*SentinelOne, error) {
+	return newSentinelOne(ctx, consoles, true, opts...)
+}
+
+func newSentinelOne(ctx context.Context, consoles []Console, readOnly bool, opts ...Option) (*SentinelOne, error) {
+	o := &options{
+		guardrails:    DefaultGuardrails(),
+		scopeFailures: DefaultScopeFailures(),
+		incremental:   DefaultIncrementalSync(),
+		apiVersion:    DefaultAPIVersion,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	if readOnly {
+		o.dryRun = true
+		o.scopeFailures.ReportPath = ""
+		o.schemaDriftReport = ""
+		o.incremental = IncrementalSync{}
+		o.grantWorkers = 0
+	}
+
+	if err := ValidateConsoles(consoles); err != nil {
+		return nil, err
+	}
+
+	if err := ValidateAPIVersion(o.apiVersion); err != nil {
+		return nil, err
+	}
+
+	if o.grantWorkers < 0 {
+		return nil, fmt.Errorf("grant workers must not be negative")
+	}
+
+	if err := o.incremental.Validate(); err != nil {
 		return nil, err
 	}
 
-	clientUrl, err := url.Parse(baseUrl)
+	if err := o.activity.Validate(); err != nil {
+		return nil, err
+	}
+
+	lifecycle := newSyncLifecycle()
+
+	failures := newScopeFailures(o.scopeFailures)
+	lifecycle.started(failures.reset)
+	lifecycle.ended(failures.report)
+
+	types := newResourceTypeSet(o.disabledResourceTypes)
+	prefetch := newGrantPrefetcher(ctx, o.grantWorkers, types)
+	if prefetch != nil {
+		lifecycle.started(prefetch.reset)
+	}
+
+	snapshots := newSnapshots(ctx, o.incremental, snapshotFingerprint(consoles, o))
+	if snapshots != nil {
+		lifecycle.started(snapshots.reset)
+		lifecycle.ended(snapshots.commit)
+	}
+
+	var drift *schemaDrift
+	if o.strictDecode {
+		drift = newSchemaDrift(o.schemaDriftReport)
+		lifecycle.started(drift.reset)
+		lifecycle.ended(drift.report)
+	}
+
+	tracerProvider := o.tracerProvider
+	if tracerProvider == nil {
+		tracerProvider = otel.GetTracerProvider()
+	}
+
+	// the SDK may exit the connector subprocess right after a sync, the spans batched by then are exported when it ends.
+	if flusher, ok := tracerProvider.(interface{ ForceFlush(context.Context) error }); ok {
+		lifecycle.ended(func(ctx context.Context) error {
+			if err := flusher.ForceFlush(ctx); err != nil {
+				ctxzap.Extract(ctx).Warn("failed to export traces", zap.Error(err))
+			}
+			return nil
+		})
+	}
+
+	httpClient, err := newHTTPClient(ctx, o.http, o.logHashPII)
 	if err != nil {
 		return nil, err
 	}
-	clientUrl.Path = "/web/api/v2.1/"
 
-	client := sentinelone.NewClient(httpClient, clientUrl.String(), token)
+	var all []*console
+	for _, cfg := range consoles {
+		consoleURL, err := NormalizeConsoleURL(cfg.URL)
+		if err != nil {
+			return nil, err
+		}
+		baseURL := apiURL(consoleURL, o.apiVersion)
+
+		// every console and token gets its own client, so each is rate limited independently.
+		var clients []*sentinelone.Client
+		clientOpts := []sentinelone.ClientOption{
+			sentinelone.WithDryRun(o.dryRun),
+			sentinelone.WithRateLimit(o.requestsPerSecond),
+			sentinelone.WithMeterProvider(o.meterProvider),
+			sentinelone.WithTracerProvider(tracerProvider),
+		}
+		if drift != nil {
+			clientOpts = append(clientOpts, sentinelone.WithSchemaRecorder(drift.forConsole(cfg.Name)))
+		}
+		for _, token := range cfg.Tokens {
+			clients = append(clients, sentinelone.NewClient(httpClient, baseURL, token, clientOpts...))
+		}
+
+		for _, path := range cfg.TokenFiles {
+			tf, err := newTokenFile(path)
+			if err != nil {
+				return nil, err
+			}
+
+			if !readOnly {
+				if err := tf.watch(ctx); err != nil {
+					return nil, err
+				}
+			}
+
+			clients = append(clients, tf.newClient(httpClient, baseURL, clientOpts...))
+		}
+		routes := newRoutes(clients)
+
+		c := &console{
+			name:       cfg.Name,
+			url:        consoleURL,
+			routes:     routes,
+			guardrails: newGuardrails(routes, o.guardrails),
+			scopes:     newScopeFilter(routes, o.scopes),
+			failures:   failures,
+			prefetch:   prefetch,
+			snapshots:  snapshots,
+			activity:   newActivityLog(o.activity, routes),
+			available:  true,
+		}
+		all = append(all, c)
+	}
+
+	set := newConsoleSet(all)
+	if set.multi() {
+		for _, c := range all {
+			c.root = &v2.ResourceId{ResourceType: resourceTypeConsole.Id, Resource: c.name}
+		}
+	}
 
 	return &SentinelOne{
-		client: client,
+		consoles:          set,
+		types:             types,
+		requestsPerSecond: o.requestsPerSecond,
+		apiVersion:        o.apiVersion,
+		tracer:            tracerProvider.Tracer(instrumentationName),
+		lifecycle:         lifecycle,
 	}, nil
 }
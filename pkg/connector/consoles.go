@@ -0,0 +1,329 @@
+package connector
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
+	"github.com/conductorone/baton-sdk/pkg/annotations"
+	"github.com/conductorone/baton-sdk/pkg/pagination"
+	rs "github.com/conductorone/baton-sdk/pkg/types/resource"
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/proto"
+)
+
+// consoleIDSeparator separates the console name from the SentinelOne id in namespaced resource ids.
+const consoleIDSeparator = "/"
+
+// Console is a SentinelOne management console synced by the connector.
+type Console struct {
+	// Name identifies the console in resource ids, it must be unique and is empty only when a single console is synced.
+	Name string
+	URL  string
+	// Tokens are the api tokens of the console, several account or site scope tokens are merged into one view of the console.
+	Tokens []string
+	// TokenFiles are files holding one api token each, re-read when they change or when the token is rejected.
+	TokenFiles []string
+}
+
+// ValidateConsoles returns an error if the consoles can't be synced together.
+func ValidateConsoles(consoles []Console) error {
+	if len(consoles) == 0 {
+		return fmt.Errorf("at least one management console is required")
+	}
+
+	names := make(map[string]struct{}, len(consoles))
+	for _, c := range consoles {
+		if c.Name == "" && len(consoles) > 1 {
+			return fmt.Errorf("every management console needs a name when several consoles are synced")
+		}
+
+		if strings.Contains(c.Name, consoleIDSeparator) {
+			return fmt.Errorf("management console name %q must not contain %q", c.Name, consoleIDSeparator)
+		}
+
+		if _, ok := names[c.Name]; ok {
+			return fmt.Errorf("management console %q is configured more than once", c.Name)
+		}
+		names[c.Name] = struct{}{}
+
+		if c.URL == "" {
+			return fmt.Errorf("management console %q has no url", c.Name)
+		}
+
+		if _, err := NormalizeConsoleURL(c.URL); err != nil {
+			return err
+		}
+
+		if len(c.Tokens) == 0 && len(c.TokenFiles) == 0 {
+			return fmt.Errorf("management console %q has no api token", c.Name)
+		}
+
+		for _, token := range c.Tokens {
+			if token == "" {
+				return fmt.Errorf("management console %q has an empty api token", c.Name)
+			}
+		}
+
+		for _, path := range c.TokenFiles {
+			if path == "" {
+				return fmt.Errorf("management console %q has an empty api token file path", c.Name)
+			}
+		}
+	}
+
+	return nil
+}
+
+// console holds the client and the per-console state of one management console.
+type console struct {
+	name       string
+	url        string
+	root       *v2.ResourceId
+	routes     *routes
+	guardrails *guardrails
+	scopes     *scopeFilter
+	// failures is shared by all consoles, so the skipped accounts and sites are reported together.
+	failures *scopeFailures
+	// prefetch is shared by all consoles too, its workers bound the grants fetched ahead across consoles.
+	prefetch *grantPrefetcher
+	// snapshots is nil unless the syncs are incremental, it holds the snapshot of every console.
+	snapshots *snapshots
+	// activity is nil unless the activities log is read for the last logins and api activity.
+	activity *activityLog
+
+	mtx       sync.Mutex
+	available bool
+}
+
+// id namespaces the SentinelOne id with the console name, so ids of different consoles can't collide.
+func (c *console) id(objectID string) string {
+	if c.name == "" {
+		return objectID
+	}
+
+	return c.name + consoleIDSeparator + objectID
+}
+
+func (c *console) isAvailable() bool {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	return c.available
+}
+
+func (c *console) setAvailable(available bool) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	c.available = available
+}
+
+// consoleSet holds every synced console. A single unnamed console is synced without the console resource type and without namespaced ids.
+type consoleSet struct {
+	all    []*console
+	byName map[string]*console
+}
+
+func newConsoleSet(consoles []*console) *consoleSet {
+	rv := &consoleSet{
+		all:    consoles,
+		byName: make(map[string]*console, len(consoles)),
+	}
+	for _, c := range consoles {
+		rv.byName[c.name] = c
+	}
+
+	return rv
+}
+
+// multi reports whether the consoles are synced as separate tenant roots.
+func (cs *consoleSet) multi() bool {
+	return len(cs.all) > 1 || cs.all[0].name != ""
+}
+
+// resolve returns the console a namespaced resource id belongs to and the SentinelOne id within that console.
+func (cs *consoleSet) resolve(resourceID string) (*console, string, error) {
+	if !cs.multi() {
+		return cs.all[0], resourceID, nil
+	}
+
+	name, objectID, ok := strings.Cut(resourceID, consoleIDSeparator)
+	if !ok {
+		return nil, "", fmt.Errorf("resource id %s has no management console prefix", resourceID)
+	}
+
+	c, ok := cs.byName[name]
+	if !ok {
+		return nil, "", fmt.Errorf("unknown management console %q", name)
+	}
+
+	return c, objectID, nil
+}
+
+// resolveAll resolves the resource ids, which must all belong to the same console.
+func (cs *consoleSet) resolveAll(resourceIDs []string) (*console, []string, error) {
+	var (
+		rv        *console
+		objectIDs = make([]string, 0, len(resourceIDs))
+	)
+	for _, resourceID := range resourceIDs {
+		c, objectID, err := cs.resolve(resourceID)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if rv != nil && rv != c {
+			return nil, nil, fmt.Errorf("resources of management consoles %q and %q can't be changed in one request", rv.name, c.name)
+		}
+		rv = c
+		objectIDs = append(objectIDs, objectID)
+	}
+
+	if rv == nil {
+		return nil, nil, fmt.Errorf("no resources given")
+	}
+
+	return rv, objectIDs, nil
+}
+
+// listedUnder returns the console whose resources are listed for parentId, or false if the resource type is listed under another parent.
+// Resource types nested in accounts are listed under the console or the root when accounts are not synced.
+func (cs *consoleSet) listedUnder(parentId *v2.ResourceId, types resourceTypeSet, nestedInAccount bool) (*console, bool, error) {
+	var expected *v2.ResourceType
+	switch {
+	case nestedInAccount && types.enabled(resourceTypeAccount):
+		expected = resourceTypeAccount
+	case cs.multi():
+		expected = resourceTypeConsole
+	}
+
+	if expected == nil {
+		return cs.all[0], parentId == nil, nil
+	}
+
+	if parentId == nil || parentId.ResourceType != expected.Id {
+		return nil, false, nil
+	}
+
+	var (
+		c   *console
+		err error
+	)
+	if expected == resourceTypeConsole {
+		c, err = cs.byNameOrError(parentId.Resource)
+	} else {
+		c, _, err = cs.resolve(parentId.Resource)
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	return c, c.isAvailable(), nil
+}
+
+func (cs *consoleSet) byNameOrError(name string) (*console, error) {
+	c, ok := cs.byName[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown management console %q", name)
+	}
+
+	return c, nil
+}
+
+// validate probes every console, consoles that fail are reported and skipped by the sync.
+// An error is only returned when no console can be synced.
+func (cs *consoleSet) validate(ctx context.Context, probe func(context.Context, *console) error) error {
+	l := ctxzap.Extract(ctx)
+
+	var failed []string
+	for _, c := range cs.all {
+		err := probe(ctx, c)
+		c.setAvailable(err == nil)
+		if err == nil {
+			continue
+		}
+
+		if !cs.multi() {
+			return err
+		}
+
+		l.Warn("management console is not reachable, skipping it", zap.String("console", c.name), zap.Error(err))
+		failed = append(failed, fmt.Sprintf("%s: %s", c.name, err))
+	}
+
+	if len(failed) == len(cs.all) {
+		sort.Strings(failed)
+		return fmt.Errorf("no management console is reachable: %s", strings.Join(failed, "; "))
+	}
+
+	return nil
+}
+
+type consoleResourceType struct {
+	resourceType *v2.ResourceType
+	consoles     *consoleSet
+	types        resourceTypeSet
+}
+
+func (c *consoleResourceType) ResourceType(_ context.Context) *v2.ResourceType {
+	return c.resourceType
+}
+
+// Create a new connector resource for a SentinelOne management console, the root of its accounts and roles.
+func consoleResource(c *console, types resourceTypeSet) (*v2.Resource, error) {
+	childTypes := []*v2.ResourceType{resourceTypeAccount, resourceTypeRole}
+	if !types.enabled(resourceTypeAccount) {
+		childTypes = append(childTypes, resourceTypeUser, resourceTypeServiceUser, resourceTypeSite)
+	}
+
+	var children []proto.Message
+	for _, child := range types.only(childTypes...) {
+		children = append(children, &v2.ChildResourceType{ResourceTypeId: child.Id})
+	}
+
+	return rs.NewResource(
+		c.name,
+		resourceTypeConsole,
+		c.name,
+		rs.WithAnnotation(children...),
+	)
+}
+
+// List returns the consoles that passed validation.
+func (c *consoleResourceType) List(_ context.Context, _ *v2.ResourceId, _ *pagination.Token) ([]*v2.Resource, string, annotations.Annotations, error) {
+	var rv []*v2.Resource
+	for _, con := range c.consoles.all {
+		if !con.isAvailable() {
+			continue
+		}
+
+		cr, err := consoleResource(con, c.types)
+		if err != nil {
+			return nil, "", nil, err
+		}
+		rv = append(rv, cr)
+	}
+
+	return rv, "", nil, nil
+}
+
+func (c *consoleResourceType) Entitlements(_ context.Context, _ *v2.Resource, _ *pagination.Token) ([]*v2.Entitlement, string, annotations.Annotations, error) {
+	return nil, "", nil, nil
+}
+
+func (c *consoleResourceType) Grants(_ context.Context, _ *v2.Resource, _ *pagination.Token) ([]*v2.Grant, string, annotations.Annotations, error) {
+	return nil, "", nil, nil
+}
+
+func consoleBuilder(consoles *consoleSet, types resourceTypeSet) *consoleResourceType {
+	return &consoleResourceType{
+		resourceType: resourceTypeConsole,
+		consoles:     consoles,
+		types:        types,
+	}
+}
@@ -0,0 +1,140 @@
+package connector
+
+import (
+	"testing"
+
+	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
+)
+
+func TestValidateConsoles(t *testing.T) {
+	tests := []struct {
+		name     string
+		consoles []Console
+		wantErr  bool
+	}{
+		{name: "none", consoles: nil, wantErr: true},
+		{name: "single unnamed", consoles: []Console{{URL: "acme.sentinelone.net", Tokens: []string{"t"}}}},
+		{name: "several named", consoles: []Console{
+			{Name: "eu", URL: "eu.sentinelone.net", Tokens: []string{"t"}},
+			{Name: "us", URL: "us.sentinelone.net", TokenFiles: []string{"/run/token"}},
+		}},
+		{name: "several with one unnamed", consoles: []Console{
+			{Name: "eu", URL: "eu.sentinelone.net", Tokens: []string{"t"}},
+			{URL: "us.sentinelone.net", Tokens: []string{"t"}},
+		}, wantErr: true},
+		{name: "duplicate name", consoles: []Console{
+			{Name: "eu", URL: "eu.sentinelone.net", Tokens: []string{"t"}},
+			{Name: "eu", URL: "us.sentinelone.net", Tokens: []string{"t"}},
+		}, wantErr: true},
+		{name: "name with separator", consoles: []Console{{Name: "eu/1", URL: "eu.sentinelone.net", Tokens: []string{"t"}}}, wantErr: true},
+		{name: "no url", consoles: []Console{{Name: "eu", Tokens: []string{"t"}}}, wantErr: true},
+		{name: "invalid url", consoles: []Console{{Name: "eu", URL: "ftp://eu.sentinelone.net", Tokens: []string{"t"}}}, wantErr: true},
+		{name: "no token", consoles: []Console{{Name: "eu", URL: "eu.sentinelone.net"}}, wantErr: true},
+		{name: "empty token", consoles: []Console{{Name: "eu", URL: "eu.sentinelone.net", Tokens: []string{""}}}, wantErr: true},
+		{name: "empty token file", consoles: []Console{{Name: "eu", URL: "eu.sentinelone.net", TokenFiles: []string{""}}}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ValidateConsoles(tt.consoles); (err != nil) != tt.wantErr {
+				t.Errorf("ValidateConsoles() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestConsoleSetResolve(t *testing.T) {
+	single := newConsoleSet([]*console{{}})
+	multi := newConsoleSet([]*console{{name: "eu"}, {name: "us"}})
+
+	tests := []struct {
+		name       string
+		set        *consoleSet
+		resourceID string
+		console    string
+		objectID   string
+		wantErr    bool
+	}{
+		{name: "single console ids aren't namespaced", set: single, resourceID: "101", objectID: "101"},
+		{name: "single console ids may contain the separator", set: single, resourceID: "a/b", objectID: "a/b"},
+		{name: "namespaced id", set: multi, resourceID: "us/101", console: "us", objectID: "101"},
+		{name: "missing console", set: multi, resourceID: "101", wantErr: true},
+		{name: "unknown console", set: multi, resourceID: "apac/101", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, objectID, err := tt.set.resolve(tt.resourceID)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("resolve() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			if c.name != tt.console || objectID != tt.objectID {
+				t.Errorf("resolve() = %q, %q, want %q, %q", c.name, objectID, tt.console, tt.objectID)
+			}
+			if id := c.id(objectID); id != tt.resourceID {
+				t.Errorf("id() = %q, want %q", id, tt.resourceID)
+			}
+		})
+	}
+}
+
+func TestConsoleSetResolveAll(t *testing.T) {
+	multi := newConsoleSet([]*console{{name: "eu"}, {name: "us"}})
+
+	c, ids, err := multi.resolveAll([]string{"eu/1", "eu/2"})
+	if err != nil {
+		t.Fatalf("resolveAll() error = %v", err)
+	}
+	if c.name != "eu" || !equalStrings(ids, []string{"1", "2"}) {
+		t.Errorf("resolveAll() = %q, %v", c.name, ids)
+	}
+
+	if _, _, err := multi.resolveAll([]string{"eu/1", "us/2"}); err == nil {
+		t.Error("expected an error for the resources of two consoles")
+	}
+
+	if _, _, err := multi.resolveAll(nil); err == nil {
+		t.Error("expected an error without resources")
+	}
+}
+
+func TestConsoleSetListedUnder(t *testing.T) {
+	multi := newConsoleSet([]*console{{name: "eu", available: true}, {name: "us"}})
+	all := newResourceTypeSet(nil)
+	noAccounts := newResourceTypeSet([]string{resourceTypeAccount.Id})
+
+	tests := []struct {
+		name            string
+		types           resourceTypeSet
+		parent          *v2.ResourceId
+		nestedInAccount bool
+		console         string
+	}{
+		{name: "roles under their console", types: all, parent: &v2.ResourceId{ResourceType: resourceTypeConsole.Id, Resource: "eu"}, console: "eu"},
+		{name: "roles not under the root", types: all, parent: nil},
+		{name: "users under their account", types: all, parent: &v2.ResourceId{ResourceType: resourceTypeAccount.Id, Resource: "eu/a1"}, nestedInAccount: true, console: "eu"},
+		{name: "users not under the console with accounts", types: all, parent: &v2.ResourceId{ResourceType: resourceTypeConsole.Id, Resource: "eu"}, nestedInAccount: true},
+		{name: "users under the console without accounts", types: noAccounts, parent: &v2.ResourceId{ResourceType: resourceTypeConsole.Id, Resource: "eu"}, nestedInAccount: true, console: "eu"},
+		{name: "unavailable console", types: all, parent: &v2.ResourceId{ResourceType: resourceTypeConsole.Id, Resource: "us"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, ok, err := multi.listedUnder(tt.parent, tt.types, tt.nestedInAccount)
+			if err != nil {
+				t.Fatalf("listedUnder() error = %v", err)
+			}
+
+			if ok != (tt.console != "") {
+				t.Fatalf("listedUnder() listed = %v, want console %q", ok, tt.console)
+			}
+			if ok && c.name != tt.console {
+				t.Errorf("listedUnder() console = %q, want %q", c.name, tt.console)
+			}
+		})
+	}
+}
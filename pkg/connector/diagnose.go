@@ -0,0 +1,296 @@
+package connector
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/conductorone/baton-sentinel-one/pkg/sentinelone"
+)
+
+// pageSize is the number of items SentinelOne returns per page when the syncers don't set a limit.
+const pageSize = 10
+
+// Diagnosis describes what the configured api tokens can see and do, and what a full sync would cost.
+type Diagnosis struct {
+	Consoles []ConsoleDiagnosis `json:"consoles"`
+}
+
+// ConsoleDiagnosis is the diagnosis of one management console.
+type ConsoleDiagnosis struct {
+	Name         string           `json:"name,omitempty"`
+	URL          string           `json:"url"`
+	Version      string           `json:"version,omitempty"`
+	VersionError string           `json:"version_error,omitempty"`
+	Tokens       []TokenDiagnosis `json:"tokens"`
+}
+
+// TokenDiagnosis is the diagnosis of one api token.
+type TokenDiagnosis struct {
+	Identity      *Identity           `json:"identity,omitempty"`
+	IdentityError string              `json:"identity_error,omitempty"`
+	Endpoints     []EndpointDiagnosis `json:"endpoints"`
+	// Counts holds the total number of objects of every resource type the token can read.
+	Counts map[string]int `json:"counts"`
+	// EstimatedAPICalls and EstimatedSeconds are the cost of a full sync with this token.
+	EstimatedAPICalls int     `json:"estimated_api_calls"`
+	EstimatedSeconds  float64 `json:"estimated_duration_seconds"`
+}
+
+// Identity is the user or service user owning an api token.
+type Identity struct {
+	ID             string   `json:"id"`
+	Name           string   `json:"name"`
+	Email          string   `json:"email,omitempty"`
+	Scope          string   `json:"scope"`
+	Roles          []string `json:"roles"`
+	TokenExpiresAt string   `json:"token_expires_at,omitempty"`
+}
+
+// EndpointDiagnosis tells whether an endpoint can be used with the token.
+// Writes are never sent, whether an endpoint is writable is inferred from the roles of the token owner.
+type EndpointDiagnosis struct {
+	Method  string `json:"method"`
+	Path    string `json:"path"`
+	Allowed bool   `json:"allowed"`
+	// Inferred is set when the access is derived from the roles of the token owner instead of a request.
+	Inferred bool   `json:"inferred,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// Diagnose reports the identity, scope and permissions of every api token and the size of every console.
+// It only sends reads, a failing request is reported instead of returned.
+func (s *SentinelOne) Diagnose(ctx context.Context) (*Diagnosis, error) {
+	rv := &Diagnosis{}
+	for _, c := range s.consoles.all {
+		cd := ConsoleDiagnosis{
+			Name: c.name,
+			URL:  c.url,
+		}
+
+		info, err := c.routes.first().GetSystemInfo(ctx)
+		if err != nil {
+			cd.VersionError = err.Error()
+		} else {
+			cd.Version = fmt.Sprintf("%s (build %s)", info.Release, info.Build)
+		}
+
+		for _, rt := range c.routes.all {
+			cd.Tokens = append(cd.Tokens, s.diagnoseToken(ctx, rt.client))
+		}
+
+		rv.Consoles = append(rv.Consoles, cd)
+	}
+
+	return rv, nil
+}
+
+func (s *SentinelOne) diagnoseToken(ctx context.Context, client *sentinelone.Client) TokenDiagnosis {
+	rv := TokenDiagnosis{
+		Counts: make(map[string]int),
+	}
+
+	owner, err := client.GetCurrentUser(ctx)
+	if err != nil {
+		rv.IdentityError = err.Error()
+	} else {
+		rv.Identity = identity(owner)
+	}
+
+	reads := []struct {
+		path           string
+		resourceTypeID string
+		count          func(context.Context) (int, error)
+	}{
+		{"accounts", resourceTypeAccount.Id, client.CountAccounts},
+		{"sites", resourceTypeSite.Id, client.CountSites},
+		{"users", resourceTypeUser.Id, client.CountUsers},
+		{"service-users", resourceTypeServiceUser.Id, client.CountServiceUsers},
+		{"rbac/roles", resourceTypeRole.Id, client.CountPredefinedRoles},
+	}
+
+	var (
+		requests int
+		elapsed  time.Duration
+	)
+	for _, read := range reads {
+		start := time.Now()
+		total, err := read.count(ctx)
+		elapsed += time.Since(start)
+		requests++
+
+		ed := EndpointDiagnosis{
+			Method:  "GET",
+			Path:    read.path,
+			Allowed: err == nil,
+		}
+		if err != nil {
+			ed.Error = err.Error()
+		} else {
+			rv.Counts[read.resourceTypeID] = total
+		}
+		rv.Endpoints = append(rv.Endpoints, ed)
+	}
+
+	// the writes require the Admin role in the scopes of the token.
+	var (
+		allowed bool
+		detail  string
+	)
+	if owner != nil {
+		allowed, detail = writesAllowed(ctx, client, owner)
+	}
+	for _, e := range sentinelone.WriteEndpoints() {
+		rv.Endpoints = append(rv.Endpoints, EndpointDiagnosis{
+			Method:   e.Method,
+			Path:     e.Path,
+			Allowed:  allowed,
+			Inferred: true,
+			Error:    detail,
+		})
+	}
+
+	rv.EstimatedAPICalls = s.estimateCalls(rv.Counts)
+
+	// syncs send one request at a time, so each takes the observed latency or the rate limit interval, whichever is longer.
+	perCall := elapsed / time.Duration(requests)
+	if s.requestsPerSecond > 0 {
+		if interval := time.Second / time.Duration(s.requestsPerSecond); interval > perCall {
+			perCall = interval
+		}
+	}
+	rv.EstimatedSeconds = (time.Duration(rv.EstimatedAPICalls) * perCall).Seconds()
+
+	return rv
+}
+
+// estimateCalls estimates the requests of a full sync from the totals, following how the syncers page through the API.
+func (s *SentinelOne) estimateCalls(counts map[string]int) int {
+	pages := func(n int) int {
+		if n <= 0 {
+			return 1
+		}
+		return (n + pageSize - 1) / pageSize
+	}
+
+	accounts := counts[resourceTypeAccount.Id]
+	sites := counts[resourceTypeSite.Id]
+	users := counts[resourceTypeUser.Id]
+	serviceUsers := counts[resourceTypeServiceUser.Id]
+	roles := counts[resourceTypeRole.Id]
+
+	// sites, users and service users are listed once per account, or once when accounts are not synced.
+	listings := 1
+	calls := 0
+	if s.types.enabled(resourceTypeAccount) {
+		listings = accounts
+		calls += pages(accounts)
+	}
+
+	if s.types.enabled(resourceTypeSite) {
+		calls += listings * pages(sites)
+	}
+
+	if s.types.enabled(resourceTypeUser) {
+		calls += listings * pages(users)
+	}
+
+	if s.types.enabled(resourceTypeServiceUser) {
+		calls += listings * pages(serviceUsers)
+	}
+
+	if s.types.enabled(resourceTypeRole) {
+		// custom roles are discovered by listing all users and service users.
+		calls += pages(roles) + pages(users) + pages(serviceUsers)
+	}
+
+	// grants are listed per principal type, each listing takes at least one page.
+	principalTypes := len(s.types.only(resourceTypeUser, resourceTypeServiceUser))
+	if s.types.enabled(resourceTypeAccount) {
+		calls += accounts*(principalTypes+1) + (users+serviceUsers+sites)/pageSize
+	}
+
+	if s.types.enabled(resourceTypeSite) {
+		calls += sites*principalTypes + (users+serviceUsers)/pageSize
+	}
+
+	if s.types.enabled(resourceTypeRole) {
+		calls += roles*principalTypes + (users+serviceUsers)/pageSize
+	}
+
+	return calls
+}
+
+func identity(owner *sentinelone.User) *Identity {
+	rv := &Identity{
+		ID:    owner.ID,
+		Name:  owner.FullName,
+		Email: owner.Email,
+		Scope: owner.Scope,
+	}
+
+	for _, scopeRole := range owner.ScopeRoles {
+		role := scopeRole.RoleName
+		if scopeRole.Name != "" {
+			role = fmt.Sprintf("%s in %s", scopeRole.RoleName, scopeRole.Name)
+		}
+		rv.Roles = append(rv.Roles, role)
+	}
+
+	if owner.APIToken != nil {
+		rv.TokenExpiresAt = owner.APIToken.ExpiresAt
+	}
+
+	return rv
+}
+
+// writesAllowed tells whether the token owner holds the Admin role in every tenant, account or site of its scope.
+// When it's the Admin of only some of them, the detail tells how many, the writes then fail for the principals of the others.
+func writesAllowed(ctx context.Context, client *sentinelone.Client, owner *sentinelone.User) (bool, string) {
+	adminRoleID := predefinedAdminRoleID(ctx, client)
+
+	admin := 0
+	for _, scopeRole := range owner.ScopeRoles {
+		if isAdminRole(scopeRole, adminRoleID) {
+			admin++
+		}
+	}
+
+	if admin > 0 && admin < len(owner.ScopeRoles) {
+		return false, fmt.Sprintf("the token owner is Admin in %d of the %d %ss of its scope", admin, len(owner.ScopeRoles), owner.Scope)
+	}
+
+	return admin > 0, ""
+}
+
+// isAdminRole tells whether the scope role is the predefined Admin role, by name when its id is unknown.
+func isAdminRole(scopeRole sentinelone.Role, adminRoleID string) bool {
+	if adminRoleID != "" {
+		return scopeRole.RoleID == adminRoleID
+	}
+
+	return scopeRole.RoleName == adminRoleName
+}
+
+// predefinedAdminRoleID returns the id of the predefined Admin role, a custom role may be named Admin as well.
+// It's empty when the token can't list the roles, the roles of the token owner are then matched by name.
+func predefinedAdminRoleID(ctx context.Context, client *sentinelone.Client) string {
+	params := sentinelone.ParamsMap{}
+	for {
+		roles, next, err := client.GetPredefinedRoles(ctx, params)
+		if err != nil {
+			return ""
+		}
+
+		for _, role := range roles {
+			if role.PredefinedRole && role.Name == adminRoleName {
+				return role.ID
+			}
+		}
+
+		if next == "" {
+			return ""
+		}
+		params[cursor] = next
+	}
+}
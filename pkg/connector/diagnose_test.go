@@ -0,0 +1,119 @@
+package connector
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/conductorone/baton-sentinel-one/pkg/sentinelone"
+)
+
+func TestDiagnoseWrites(t *testing.T) {
+	admin := sentinelone.Role{ID: "admin-id", Name: adminRoleName, PredefinedRole: true}
+	viewer := sentinelone.Role{ID: "viewer-id", Name: "Viewer", PredefinedRole: true}
+
+	tests := []struct {
+		name        string
+		scopeRoles  []sentinelone.Role
+		rolesDenied bool
+		allowed     bool
+		detail      bool
+	}{
+		{name: "admin of every account", scopeRoles: []sentinelone.Role{
+			{ID: "a1", RoleID: "admin-id", RoleName: adminRoleName},
+			{ID: "a2", RoleID: "admin-id", RoleName: adminRoleName},
+		}, allowed: true},
+		{name: "admin of some accounts", scopeRoles: []sentinelone.Role{
+			{ID: "a1", RoleID: "admin-id", RoleName: adminRoleName},
+			{ID: "a2", RoleID: "viewer-id", RoleName: "Viewer"},
+		}, detail: true},
+		{name: "custom role named admin", scopeRoles: []sentinelone.Role{
+			{ID: "a1", RoleID: "custom-id", RoleName: adminRoleName},
+		}},
+		{name: "roles not readable", scopeRoles: []sentinelone.Role{
+			{ID: "a1", RoleID: "custom-id", RoleName: adminRoleName},
+		}, rolesDenied: true, allowed: true},
+		{name: "no roles"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeConsole(t)
+			f.currentUser = sentinelone.User{ID: "owner", Scope: scopeAccount, ScopeRoles: tt.scopeRoles}
+			f.roles = []sentinelone.Role{admin, viewer}
+			if tt.rolesDenied {
+				f.setStatus("rbac/roles", http.StatusForbidden)
+			}
+			s := f.newConnector(t)
+
+			d, err := s.Diagnose(context.Background())
+			if err != nil {
+				t.Fatalf("Diagnose() error = %v", err)
+			}
+
+			writes := make(map[sentinelone.Endpoint]EndpointDiagnosis)
+			for _, ed := range d.Consoles[0].Tokens[0].Endpoints {
+				if ed.Inferred {
+					writes[sentinelone.Endpoint{Method: ed.Method, Path: ed.Path}] = ed
+				}
+			}
+
+			for _, e := range sentinelone.WriteEndpoints() {
+				ed, ok := writes[e]
+				if !ok {
+					t.Errorf("%s %s is not diagnosed", e.Method, e.Path)
+					continue
+				}
+				if ed.Allowed != tt.allowed {
+					t.Errorf("%s %s allowed = %v, want %v", e.Method, e.Path, ed.Allowed, tt.allowed)
+				}
+				if (ed.Error != "") != tt.detail {
+					t.Errorf("%s %s error = %q", e.Method, e.Path, ed.Error)
+				}
+			}
+			if _, ok := writes[sentinelone.Endpoint{Method: http.MethodPost, Path: "users/revoke-api-token"}]; !ok {
+				t.Error("POST users/revoke-api-token is not diagnosed")
+			}
+
+			if writes := f.writes(); len(writes) != 0 {
+				t.Errorf("sent %v, want no write", writes)
+			}
+		})
+	}
+}
+
+// TestNewReadOnly checks that the read-only connector leaves the reports and the incremental state of the syncs alone.
+func TestNewReadOnly(t *testing.T) {
+	f := newFakeConsole(t)
+	dir := t.TempDir()
+
+	statePath := filepath.Join(dir, "state.json")
+	files := []string{
+		filepath.Join(dir, "skipped.json"),
+		filepath.Join(dir, "drift.json"),
+		pendingSnapshotPath(statePath),
+	}
+	for _, path := range files {
+		if err := os.WriteFile(path, []byte("{}"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, err := NewReadOnly(context.Background(), []Console{{URL: f.srv.URL, Tokens: []string{"token"}}},
+		WithScopeFailures(ScopeFailures{Mode: ScopeFailureSkip, ReportPath: files[0]}),
+		WithStrictDecode(true),
+		WithSchemaDriftReport(files[1]),
+		WithIncrementalSync(IncrementalSync{StatePath: statePath}),
+	)
+	if err != nil {
+		t.Fatalf("NewReadOnly() error = %v", err)
+	}
+
+	for _, path := range files {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("%s was removed: %v", filepath.Base(path), err)
+		}
+	}
+}
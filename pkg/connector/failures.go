@@ -0,0 +1,320 @@
+package connector
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
+	"github.com/conductorone/baton-sdk/pkg/annotations"
+	"github.com/conductorone/baton-sdk/pkg/pagination"
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/conductorone/baton-sentinel-one/pkg/sentinelone"
+)
+
+const (
+	// ScopeFailureSkip skips an account or site whose grants can't be listed and records why.
+	ScopeFailureSkip = "skip"
+	// ScopeFailureFail aborts the sync when the grants of an account or site can't be listed.
+	ScopeFailureFail = "fail"
+)
+
+// scopeRetryDelay is the wait before the first retry of a failing account or site, it doubles with every retry.
+var scopeRetryDelay = time.Second
+
+// ScopeFailures configures how accounts and sites whose grants fail to list are handled.
+type ScopeFailures struct {
+	// Mode is ScopeFailureSkip or ScopeFailureFail.
+	Mode string
+	// Retries is the number of retries of server errors before the account or site fails.
+	Retries int
+	// ReportPath is the file the skipped accounts and sites are written to, empty for none.
+	ReportPath string
+}
+
+// DefaultScopeFailures retries twice and then skips the account or site.
+func DefaultScopeFailures() ScopeFailures {
+	return ScopeFailures{
+		Mode:    ScopeFailureSkip,
+		Retries: 2,
+	}
+}
+
+// Validate returns an error if the mode is unknown or the retries are negative.
+func (f ScopeFailures) Validate() error {
+	if f.Mode != ScopeFailureSkip && f.Mode != ScopeFailureFail {
+		return fmt.Errorf("scope failure mode must be %s or %s", ScopeFailureSkip, ScopeFailureFail)
+	}
+
+	if f.Retries < 0 {
+		return fmt.Errorf("scope retries must not be negative")
+	}
+
+	return nil
+}
+
+// SkippedScope is an account or site whose grants were not synced.
+type SkippedScope struct {
+	Console string `json:"console,omitempty"`
+	Scope   string `json:"scope"`
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	Reason  string `json:"reason"`
+}
+
+// LoadSkippedScopes reads the accounts and sites skipped by the last sync from a report, nil if there is no report.
+func LoadSkippedScopes(path string) ([]SkippedScope, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var rv []SkippedScope
+	if err := json.Unmarshal(data, &rv); err != nil {
+		return nil, fmt.Errorf("failed to read skipped scopes report %s: %w", path, err)
+	}
+
+	return rv, nil
+}
+
+// scopeFailures retries the requests of an account or site and records the ones that are skipped.
+type scopeFailures struct {
+	ScopeFailures
+
+	mtx     sync.Mutex
+	skipped []SkippedScope
+	byKey   map[string]int
+}
+
+func newScopeFailures(cfg ScopeFailures) *scopeFailures {
+	return &scopeFailures{
+		ScopeFailures: cfg,
+		byKey:         make(map[string]int),
+	}
+}
+
+// reset forgets the skips of the last sync when another one starts.
+func (f *scopeFailures) reset(ctx context.Context) error {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	f.skipped = nil
+	f.byKey = make(map[string]int)
+
+	// a report left by an earlier sync would be mistaken for the skips of this one.
+	if f.ReportPath != "" {
+		if err := os.Remove(f.ReportPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to remove skipped scopes report: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// report writes the accounts and sites skipped by the sync once it ends, there is no report when none was.
+func (f *scopeFailures) report(ctx context.Context) error {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	if f.ReportPath == "" || len(f.skipped) == 0 {
+		return nil
+	}
+
+	if err := writeReport(f.ReportPath, f.skipped); err != nil {
+		ctxzap.Extract(ctx).Warn("failed to write skipped scopes report", zap.String("path", f.ReportPath), zap.Error(err))
+	}
+
+	return nil
+}
+
+// call runs fn for the account or site, retrying server errors. It returns true when the scope is skipped,
+// either now or by an earlier call, and the error of fn when failures abort the sync.
+// The context of a retry is marked, so the client counts the requests sent again.
+func (f *scopeFailures) call(ctx context.Context, c *console, scope, id, name string, fn func(ctx context.Context) error) (bool, error) {
+	if f.isSkipped(c, scope, id) {
+		return true, nil
+	}
+
+	var err error
+	for attempt := 0; ; attempt++ {
+		attemptCtx := ctx
+		if attempt > 0 {
+			attemptCtx = sentinelone.WithRetryReason(ctx, retryReason(err))
+		}
+
+		err = fn(attemptCtx)
+		if err == nil {
+			return false, nil
+		}
+
+		if ctx.Err() != nil || !retryable(err) || attempt >= f.Retries {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			return false, ctx.Err()
+		case <-time.After(scopeRetryDelay << attempt):
+		}
+	}
+
+	if f.Mode == ScopeFailureFail || ctx.Err() != nil {
+		return false, err
+	}
+
+	f.skip(ctx, c, SkippedScope{
+		Console: c.name,
+		Scope:   scope,
+		ID:      id,
+		Name:    name,
+		Reason:  err.Error(),
+	})
+
+	return true, nil
+}
+
+func (f *scopeFailures) isSkipped(c *console, scope, id string) bool {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	_, ok := f.byKey[scopeKey(c, scope, id)]
+	return ok
+}
+
+func (f *scopeFailures) skip(ctx context.Context, c *console, skipped SkippedScope) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	l := ctxzap.Extract(ctx)
+	l.Warn("failed to list grants, skipping",
+		zap.String("console", skipped.Console),
+		zap.String("scope", skipped.Scope),
+		zap.String("id", skipped.ID),
+		zap.String("name", skipped.Name),
+		zap.String("reason", skipped.Reason),
+		zap.Int("skipped_scopes", len(f.skipped)+1),
+	)
+
+	f.byKey[scopeKey(c, skipped.Scope, skipped.ID)] = len(f.skipped)
+	f.skipped = append(f.skipped, skipped)
+}
+
+// annotations reports the skipped account or site on the grants listing that gave up on it.
+func (f *scopeFailures) annotations(c *console, scope, id string) (annotations.Annotations, error) {
+	f.mtx.Lock()
+	i, ok := f.byKey[scopeKey(c, scope, id)]
+	var skipped SkippedScope
+	if ok {
+		skipped = f.skipped[i]
+	}
+	f.mtx.Unlock()
+
+	if !ok {
+		return nil, nil
+	}
+
+	msg, err := structpb.NewStruct(map[string]interface{}{
+		"skipped_scope": map[string]interface{}{
+			"scope":  skipped.Scope,
+			"id":     skipped.ID,
+			"name":   skipped.Name,
+			"reason": skipped.Reason,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	annos := annotations.Annotations{}
+	annos.Append(msg)
+	return annos, nil
+}
+
+func scopeKey(c *console, scope, id string) string {
+	return c.name + "/" + scope + "/" + id
+}
+
+// retryReason labels the retry of a failed request, e.g. "429" or "transport".
+func retryReason(err error) string {
+	var apiErr *sentinelone.APIError
+	if errors.As(err, &apiErr) {
+		return strconv.Itoa(apiErr.StatusCode)
+	}
+
+	return "transport"
+}
+
+// retryable reports whether the request may succeed when sent again: server errors, rate limiting and failed connections,
+// including a connection dropped while the response is read. A response that can't be decoded would fail the same way again.
+func retryable(err error) bool {
+	var apiErr *sentinelone.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode >= http.StatusInternalServerError || apiErr.StatusCode == http.StatusTooManyRequests
+	}
+
+	var (
+		urlErr *url.Error
+		netErr net.Error
+	)
+	return errors.As(err, &urlErr) || errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// writeReport replaces the report atomically, so it can be read while the sync runs.
+func writeReport(path string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return writeFile(path, data)
+}
+
+// writeFile replaces the file through a rename, so a reader never sees it half written.
+func writeFile(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// skippedScope ends the page state of a skipped account or site, the states left in the bag are skipped in turn.
+func skippedScope(c *console, bag *pagination.Bag, scope, id string) ([]*v2.Grant, string, annotations.Annotations, error) {
+	annos, err := c.failures.annotations(c, scope, id)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	pageToken, err := bag.NextToken("")
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	return nil, pageToken, annos, nil
+}
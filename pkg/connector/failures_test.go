@@ -0,0 +1,170 @@
+package connector
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/conductorone/baton-sentinel-one/pkg/sentinelone"
+)
+
+func TestScopeFailuresCall(t *testing.T) {
+	defer func(delay time.Duration) { scopeRetryDelay = delay }(scopeRetryDelay)
+	scopeRetryDelay = 5 * time.Millisecond
+
+	serverErr := &sentinelone.APIError{StatusCode: http.StatusInternalServerError}
+	rateLimited := &sentinelone.APIError{StatusCode: http.StatusTooManyRequests}
+	forbidden := &sentinelone.APIError{StatusCode: http.StatusForbidden}
+	transportErr := &url.Error{Op: "Get", URL: "https://acme.sentinelone.net/web/api/v2.1/users", Err: errors.New("connection reset by peer")}
+	decodeErr := json.Unmarshal([]byte(`{"data": [}`), &sentinelone.Response[sentinelone.User]{})
+
+	tests := []struct {
+		name        string
+		mode        string
+		retries     int
+		errs        []error
+		wantCalls   int
+		wantSkipped bool
+		wantErr     bool
+	}{
+		{name: "success", mode: ScopeFailureSkip, retries: 2, errs: []error{nil}, wantCalls: 1},
+		{name: "success after retries", mode: ScopeFailureSkip, retries: 2, errs: []error{serverErr, rateLimited, nil}, wantCalls: 3},
+		{name: "skipped after retries", mode: ScopeFailureSkip, retries: 2, errs: []error{serverErr, serverErr, serverErr}, wantCalls: 3, wantSkipped: true},
+		{name: "failed after retries", mode: ScopeFailureFail, retries: 1, errs: []error{serverErr, serverErr}, wantCalls: 2, wantErr: true},
+		{name: "no retries", mode: ScopeFailureSkip, errs: []error{serverErr}, wantCalls: 1, wantSkipped: true},
+		{name: "client error not retried", mode: ScopeFailureSkip, retries: 2, errs: []error{forbidden}, wantCalls: 1, wantSkipped: true},
+		{name: "dry run not retried", mode: ScopeFailureFail, retries: 2, errs: []error{&sentinelone.DryRunError{}}, wantCalls: 1, wantErr: true},
+		{name: "transport error retried", mode: ScopeFailureFail, retries: 1, errs: []error{transportErr, nil}, wantCalls: 2},
+		{name: "dropped connection retried", mode: ScopeFailureFail, retries: 1, errs: []error{io.ErrUnexpectedEOF, nil}, wantCalls: 2},
+		{name: "decode error not retried", mode: ScopeFailureFail, retries: 2, errs: []error{decodeErr}, wantCalls: 1, wantErr: true},
+		{name: "other error not retried", mode: ScopeFailureFail, retries: 2, errs: []error{errors.New("failed to get users: [invalid filter]")}, wantCalls: 1, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newScopeFailures(ScopeFailures{Mode: tt.mode, Retries: tt.retries})
+			c := &console{name: "eu"}
+
+			var calls []time.Time
+			skipped, err := f.call(context.Background(), c, scopeAccount, "a1", "Acme", func(ctx context.Context) error {
+				calls = append(calls, time.Now())
+				return tt.errs[len(calls)-1]
+			})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("call() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if skipped != tt.wantSkipped {
+				t.Errorf("call() skipped = %v, want %v", skipped, tt.wantSkipped)
+			}
+			if len(calls) != tt.wantCalls {
+				t.Errorf("called %d times, want %d", len(calls), tt.wantCalls)
+			}
+
+			// the wait before a retry doubles every time.
+			for i := 1; i < len(calls); i++ {
+				if wait := calls[i].Sub(calls[i-1]); wait < scopeRetryDelay<<(i-1) {
+					t.Errorf("retry %d after %v, want at least %v", i, wait, scopeRetryDelay<<(i-1))
+				}
+			}
+
+			if f.isSkipped(c, scopeAccount, "a1") != tt.wantSkipped {
+				t.Errorf("isSkipped() = %v, want %v", !tt.wantSkipped, tt.wantSkipped)
+			}
+		})
+	}
+}
+
+func TestScopeFailuresCallSkipped(t *testing.T) {
+	f := newScopeFailures(DefaultScopeFailures())
+	c := &console{}
+	f.skip(context.Background(), c, SkippedScope{Scope: scopeSite, ID: "s1"})
+
+	skipped, err := f.call(context.Background(), c, scopeSite, "s1", "Prod", func(ctx context.Context) error {
+		t.Error("a skipped scope was called")
+		return nil
+	})
+	if err != nil || !skipped {
+		t.Errorf("call() = %v, %v, want skipped", skipped, err)
+	}
+}
+
+// TestSkippedScopeSync checks that a skipped account ends the fan-out of its grants and that the report is written
+// once the grants of the sync are listed, and removed when the next sync starts.
+func TestSkippedScopeSync(t *testing.T) {
+	defer func(delay time.Duration) { scopeRetryDelay = delay }(scopeRetryDelay)
+	scopeRetryDelay = time.Millisecond
+
+	f := newFakeConsole(t)
+	f.accounts = []sentinelone.Account{{ID: "a1", Name: "Acme"}, {ID: "a2", Name: "Globex"}}
+	// the sites of an account are the first listing of its fan-out.
+	f.setStatus("sites", http.StatusInternalServerError)
+
+	report := filepath.Join(t.TempDir(), "skipped.json")
+	s := f.newConnector(t, WithScopeFailures(ScopeFailures{Mode: ScopeFailureSkip, Retries: 1, ReportPath: report}))
+	accounts := syncer(t, s, resourceTypeAccount)
+
+	resources := listResources(t, accounts, nil)
+	if len(resources) != 2 {
+		t.Fatalf("listed %d accounts, want 2", len(resources))
+	}
+
+	for i, resource := range resources {
+		_, annos, err := listGrants(accounts, resource)
+		if err != nil {
+			t.Fatalf("Grants() error = %v", err)
+		}
+		if len(annos) == 0 {
+			t.Errorf("grants of %s have no skipped_scope annotation", resource.Id.Resource)
+		}
+
+		if _, err := os.Stat(report); i < len(resources)-1 && err == nil {
+			t.Error("report written before the grants of the sync were listed")
+		}
+	}
+
+	// every account is retried once, the rest of its fan-out is skipped without a request.
+	if sites := f.sent(http.MethodGet, "sites"); len(sites) != 4 {
+		t.Errorf("sent %d sites requests, want 4", len(sites))
+	}
+	for _, path := range []string{"users", "service-users"} {
+		if sent := f.sent(http.MethodGet, path); len(sent) != 0 {
+			t.Errorf("sent %v of skipped accounts", sent)
+		}
+	}
+
+	skipped, err := LoadSkippedScopes(report)
+	if err != nil {
+		t.Fatalf("LoadSkippedScopes() error = %v", err)
+	}
+	if len(skipped) != 2 || skipped[0].ID != "a1" || skipped[1].ID != "a2" {
+		t.Errorf("report = %+v, want both accounts", skipped)
+	}
+
+	listResources(t, accounts, nil)
+	if _, err := os.Stat(report); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("report of the last sync left when the next one started: %v", err)
+	}
+	if s.consoles.all[0].failures.isSkipped(s.consoles.all[0], scopeAccount, "a1") {
+		t.Error("skip of the last sync kept when the next one started")
+	}
+}
+
+func TestFailedScopeSync(t *testing.T) {
+	f := newFakeConsole(t)
+	f.accounts = []sentinelone.Account{{ID: "a1", Name: "Acme"}}
+	f.setStatus("users", http.StatusInternalServerError)
+
+	s := f.newConnector(t, WithScopeFailures(ScopeFailures{Mode: ScopeFailureFail}))
+	accounts := syncer(t, s, resourceTypeAccount)
+
+	if _, _, err := listGrants(accounts, listResources(t, accounts, nil)[0]); err == nil {
+		t.Fatal("expected the grants of a failing account to fail the sync")
+	}
+}
@@ -0,0 +1,304 @@
+package connector
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
+	"github.com/conductorone/baton-sdk/pkg/annotations"
+	"github.com/conductorone/baton-sdk/pkg/connectorbuilder"
+	"github.com/conductorone/baton-sdk/pkg/pagination"
+
+	"github.com/conductorone/baton-sentinel-one/pkg/sentinelone"
+)
+
+const fakeAPIPath = "/web/api/" + DefaultAPIVersion + "/"
+
+// fakeConsole is a management console API serving fixed users, service users, accounts, sites, roles and activities.
+// It records the requests it gets.
+type fakeConsole struct {
+	srv *httptest.Server
+
+	mtx           sync.Mutex
+	currentUser   sentinelone.User
+	users         []sentinelone.User
+	serviceUsers  []sentinelone.ServiceUser
+	accounts      []sentinelone.Account
+	sites         []sentinelone.Site
+	roles         []sentinelone.Role
+	activities    []sentinelone.Activity
+	activityTypes []sentinelone.ActivityType
+	// pageSize splits the listings into pages, 0 lists everything in one page.
+	pageSize int
+	// status answers the requests of a path relative to the API with this status instead, e.g. 500.
+	status   map[string]int
+	requests []fakeRequest
+}
+
+// fakeRequest is a request the fake console got, its path is relative to the API, e.g. "users/2fa/reset".
+type fakeRequest struct {
+	Method string
+	Path   string
+	Query  url.Values
+	Body   string
+}
+
+func newFakeConsole(t *testing.T) *fakeConsole {
+	f := &fakeConsole{
+		currentUser: sentinelone.User{ID: "owner", Scope: scopeTenant},
+		status:      make(map[string]int),
+	}
+	f.srv = httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(f.srv.Close)
+
+	return f
+}
+
+// newConnector returns a connector syncing the fake console with a single api token.
+func (f *fakeConsole) newConnector(t *testing.T, opts ...Option) *SentinelOne {
+	s, err := New(context.Background(), []Console{{URL: f.srv.URL, Tokens: []string{"token"}}}, opts...)
+	if err != nil {
+		t.Fatalf("failed to create connector: %v", err)
+	}
+
+	return s
+}
+
+func (f *fakeConsole) setStatus(path string, status int) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	f.status[path] = status
+}
+
+// sent returns the requests with the method sent to the path.
+func (f *fakeConsole) sent(method, path string) []fakeRequest {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	var rv []fakeRequest
+	for _, r := range f.requests {
+		if r.Method == method && r.Path == path {
+			rv = append(rv, r)
+		}
+	}
+
+	return rv
+}
+
+// writes returns the requests that aren't reads.
+func (f *fakeConsole) writes() []fakeRequest {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	var rv []fakeRequest
+	for _, r := range f.requests {
+		if r.Method != http.MethodGet {
+			rv = append(rv, r)
+		}
+	}
+
+	return rv
+}
+
+func (f *fakeConsole) serve(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	path := strings.TrimPrefix(r.URL.Path, fakeAPIPath)
+	query := r.URL.Query()
+
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	f.requests = append(f.requests, fakeRequest{
+		Method: r.Method,
+		Path:   path,
+		Query:  query,
+		Body:   string(body),
+	})
+
+	if status, ok := f.status[path]; ok {
+		w.WriteHeader(status)
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodGet && path == "user":
+		writeJSON(w, sentinelone.SingleResponse[sentinelone.User]{Data: f.currentUser})
+	case r.Method == http.MethodGet && path == "users":
+		writePage(w, query, f.pageSize, filterFake(f.users, query, userRoles, func(u sentinelone.User) string { return u.ID }))
+	case r.Method == http.MethodGet && path == "service-users":
+		writePage(w, query, f.pageSize, filterFake(f.serviceUsers, query, serviceUserRoles, func(s sentinelone.ServiceUser) string { return s.ID }))
+	case r.Method == http.MethodGet && path == "accounts":
+		writePage(w, query, f.pageSize, f.accounts)
+	case r.Method == http.MethodGet && path == "sites":
+		writePage(w, query, f.pageSize, f.sites)
+	case r.Method == http.MethodGet && path == "rbac/roles":
+		writePage(w, query, f.pageSize, f.roles)
+	case r.Method == http.MethodGet && path == "activities":
+		writePage(w, query, f.pageSize, f.activities)
+	case r.Method == http.MethodGet && path == "activities/types":
+		writePage(w, query, 0, f.activityTypes)
+	case r.Method == http.MethodPost && path == "users/revoke-api-token":
+		writeJSON(w, sentinelone.SingleResponse[sentinelone.SuccessResponse]{Data: sentinelone.SuccessResponse{Success: true}})
+	case r.Method == http.MethodPost:
+		var req sentinelone.FilterRequest
+		_ = json.Unmarshal(body, &req)
+		writeJSON(w, sentinelone.SingleResponse[sentinelone.AffectedResponse]{Data: sentinelone.AffectedResponse{Affected: len(req.Filter.IDs)}})
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// filterFake applies the ids, roles, accounts and sites filters of the users and service users listings.
+func filterFake[T any](items []T, query url.Values, roles func(T) (string, []sentinelone.Role), id func(T) string) []T {
+	matches := func(filter string, value func(sentinelone.Role) string, item T) bool {
+		if query.Get(filter) == "" {
+			return true
+		}
+
+		_, scopeRoles := roles(item)
+		for _, want := range strings.Split(query.Get(filter), ",") {
+			if filter == idsFilter && id(item) == want {
+				return true
+			}
+			for _, scopeRole := range scopeRoles {
+				if value(scopeRole) == want {
+					return true
+				}
+			}
+		}
+
+		return false
+	}
+
+	var rv []T
+	for _, item := range items {
+		if matches(idsFilter, func(sentinelone.Role) string { return "" }, item) &&
+			matches(rolesFilter, func(r sentinelone.Role) string { return r.RoleID }, item) &&
+			matches(accountsFilter, func(r sentinelone.Role) string { return r.ID }, item) &&
+			matches(sitesFilter, func(r sentinelone.Role) string { return r.ID }, item) {
+			rv = append(rv, item)
+		}
+	}
+
+	return rv
+}
+
+// writePage writes the page of the items at the cursor, which is the offset of the page.
+// The sites are wrapped in an object, like the console does.
+func writePage[T any](w http.ResponseWriter, query url.Values, pageSize int, items []T) {
+	var res struct {
+		sentinelone.PaginationResponse
+		Data interface{} `json:"data"`
+	}
+	res.Pagination.TotalItems = len(items)
+
+	page := []T{}
+	if query.Get("countOnly") != "true" {
+		offset, _ := strconv.Atoi(query.Get(cursor))
+		if offset > len(items) {
+			offset = len(items)
+		}
+		end := len(items)
+		if pageSize > 0 && offset+pageSize < end {
+			end = offset + pageSize
+			res.Pagination.NextCursor = strconv.Itoa(end)
+		}
+		page = append(page, items[offset:end]...)
+	}
+
+	res.Data = page
+	if sites, ok := interface{}(page).([]sentinelone.Site); ok {
+		res.Data = map[string]interface{}{"sites": sites}
+	}
+
+	writeJSON(w, res)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// userGrant returns the grant of an entitlement of the user to the principal, as the builder lists the entitlement.
+func userGrant(t *testing.T, s *SentinelOne, userID, slug, principalID string) *v2.Grant {
+	resource := &v2.Resource{Id: &v2.ResourceId{ResourceType: resourceTypeUser.Id, Resource: userID}, DisplayName: userID}
+	entitlements, _, _, err := userBuilder(s.consoles, s.types).Entitlements(context.Background(), resource, nil)
+	if err != nil {
+		t.Fatalf("failed to list entitlements: %v", err)
+	}
+
+	for _, e := range entitlements {
+		if e.Slug == slug {
+			return &v2.Grant{
+				Entitlement: e,
+				Principal:   &v2.Resource{Id: &v2.ResourceId{ResourceType: resourceTypeUser.Id, Resource: principalID}},
+			}
+		}
+	}
+
+	t.Fatalf("user has no %s entitlement", slug)
+	return nil
+}
+
+// syncer returns the syncer of the resource type, wrapped like the SDK gets it.
+func syncer(t *testing.T, s *SentinelOne, resourceType *v2.ResourceType) connectorbuilder.ResourceSyncer {
+	for _, rs := range s.ResourceSyncers(context.Background()) {
+		if rs.ResourceType(context.Background()).Id == resourceType.Id {
+			return rs
+		}
+	}
+
+	t.Fatalf("no syncer for %s", resourceType.Id)
+	return nil
+}
+
+// listResources lists every page of the resources under the parent.
+func listResources(t *testing.T, rs connectorbuilder.ResourceSyncer, parent *v2.ResourceId) []*v2.Resource {
+	var (
+		rv    []*v2.Resource
+		token string
+	)
+	for {
+		resources, next, _, err := rs.List(context.Background(), parent, &pagination.Token{Token: token})
+		if err != nil {
+			t.Fatalf("List() error = %v", err)
+		}
+		rv = append(rv, resources...)
+
+		if next == "" {
+			return rv
+		}
+		token = next
+	}
+}
+
+// listGrants lists every page of the grants of the resource, the annotations of every page are returned as well.
+func listGrants(rs connectorbuilder.ResourceSyncer, resource *v2.Resource) ([]*v2.Grant, annotations.Annotations, error) {
+	var (
+		rv    []*v2.Grant
+		annos annotations.Annotations
+		token string
+	)
+	for {
+		grants, next, pageAnnos, err := rs.Grants(context.Background(), resource, &pagination.Token{Token: token})
+		if err != nil {
+			return nil, nil, err
+		}
+		rv = append(rv, grants...)
+		annos = append(annos, pageAnnos...)
+
+		if next == "" {
+			return rv, annos, nil
+		}
+		token = next
+	}
+}
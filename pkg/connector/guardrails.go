@@ -0,0 +1,292 @@
+package connector
+
+import (
+	"context"
+	"fmt"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/conductorone/baton-sentinel-one/pkg/sentinelone"
+)
+
+// Machine-readable names of the guardrail rules, they are part of the error returned when a rule blocks a request.
+const (
+	GuardrailTokenOwner      = "token_owner"
+	GuardrailLastAdmin       = "last_admin"
+	GuardrailScopeNotAllowed = "scope_not_allowed"
+)
+
+const (
+	adminRoleName = "Admin"
+
+	scopeTenant  = "tenant"
+	scopeAccount = "account"
+	scopeSite    = "site"
+)
+
+// GuardrailError is returned when a guardrail rule refuses a provisioning request.
+type GuardrailError struct {
+	Rule   string
+	Detail string
+}
+
+func (e *GuardrailError) Error() string {
+	return fmt.Sprintf("blocked by guardrail %s: %s", e.Rule, e.Detail)
+}
+
+// GRPCStatus lets the error reach ConductorOne as a failed precondition instead of an unknown error.
+func (e *GuardrailError) GRPCStatus() *status.Status {
+	return status.New(codes.FailedPrecondition, e.Error())
+}
+
+// Guardrails configures the rules that protect against locking ConductorOne or people out of a console.
+type Guardrails struct {
+	// ProtectTokenOwner refuses changes to the identity that owns the configured API token.
+	ProtectTokenOwner bool
+	// ProtectLastAdmin refuses revoking the api token or resetting the two-factor enrollment of the only Admin of a tenant,
+	// account or site. The connector doesn't revoke roles or scope memberships, those aren't covered.
+	ProtectLastAdmin bool
+	// AllowedAccountIDs and AllowedSiteIDs limit mutations to principals in these scopes, no limit when both are empty.
+	AllowedAccountIDs []string
+	AllowedSiteIDs    []string
+}
+
+// DefaultGuardrails protects the token owner and the last admin of every scope.
+func DefaultGuardrails() Guardrails {
+	return Guardrails{
+		ProtectTokenOwner: true,
+		ProtectLastAdmin:  true,
+	}
+}
+
+type guardrails struct {
+	routes            *routes
+	protectTokenOwner bool
+	protectLastAdmin  bool
+	allowedAccountIDs map[string]struct{}
+	allowedSiteIDs    map[string]struct{}
+}
+
+func newGuardrails(routes *routes, cfg Guardrails) *guardrails {
+	return &guardrails{
+		routes:            routes,
+		protectTokenOwner: cfg.ProtectTokenOwner,
+		protectLastAdmin:  cfg.ProtectLastAdmin,
+		allowedAccountIDs: toSet(cfg.AllowedAccountIDs),
+		allowedSiteIDs:    toSet(cfg.AllowedSiteIDs),
+	}
+}
+
+// check runs all enabled rules against the principals targeted by the operation.
+func (g *guardrails) check(ctx context.Context, operation string, targets []principal) error {
+	if g.protectTokenOwner {
+		if err := g.checkTokenOwner(ctx, targets); err != nil {
+			return err
+		}
+	}
+
+	if len(g.allowedAccountIDs) > 0 || len(g.allowedSiteIDs) > 0 {
+		if err := g.checkAllowedScopes(ctx, targets); err != nil {
+			return err
+		}
+	}
+
+	if g.protectLastAdmin && removesAccess(operation) {
+		if err := g.checkLastAdmin(ctx, targets); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// removesAccess reports whether the operation takes away a credential the targeted principals sign in or call the API with:
+// an Admin whose api token is revoked or whose two-factor enrollment is reset can't reach the console until they act.
+// These are the only operations of the connector that can lock the last Admin out, it never revokes a role or a scope
+// membership: the Admin role of a user is left as it is.
+func removesAccess(operation string) bool {
+	return operation == revokeAPITokenOperation || operation == resetTwoFactorOperation
+}
+
+func (g *guardrails) checkTokenOwner(ctx context.Context, targets []principal) error {
+	for _, rt := range g.routes.all {
+		owner, err := rt.client.GetCurrentUser(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to get the owner of the api token: %w", err)
+		}
+
+		for _, target := range targets {
+			if target.id == owner.ID {
+				return &GuardrailError{
+					Rule:   GuardrailTokenOwner,
+					Detail: fmt.Sprintf("%s %s owns a configured api token", target.resourceTypeID, target.id),
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+func (g *guardrails) checkAllowedScopes(ctx context.Context, targets []principal) error {
+	for _, target := range targets {
+		if target.scope == scopeTenant {
+			return &GuardrailError{
+				Rule:   GuardrailScopeNotAllowed,
+				Detail: fmt.Sprintf("%s %s has tenant scope", target.resourceTypeID, target.id),
+			}
+		}
+
+		for _, scopeRole := range target.scopeRoles {
+			allowed, err := g.scopeAllowed(ctx, target.scope, scopeRole.ID)
+			if err != nil {
+				return err
+			}
+
+			if !allowed {
+				return &GuardrailError{
+					Rule:   GuardrailScopeNotAllowed,
+					Detail: fmt.Sprintf("%s %s has a role in %s %s", target.resourceTypeID, target.id, target.scope, scopeRole.ID),
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// scopeAllowed reports whether the account or site is allowlisted, a site is also allowed when its account is.
+func (g *guardrails) scopeAllowed(ctx context.Context, scope, scopeID string) (bool, error) {
+	if _, ok := g.allowedAccountIDs[scopeID]; ok && scope == scopeAccount {
+		return true, nil
+	}
+
+	if scope != scopeSite {
+		return false, nil
+	}
+
+	if _, ok := g.allowedSiteIDs[scopeID]; ok {
+		return true, nil
+	}
+
+	accountID, err := g.routes.siteAccountID(ctx, scopeID)
+	if err != nil {
+		return false, fmt.Errorf("failed to look up site %s: %w", scopeID, err)
+	}
+
+	_, ok := g.allowedAccountIDs[accountID]
+	return ok && accountID != "", nil
+}
+
+func (g *guardrails) checkLastAdmin(ctx context.Context, targets []principal) error {
+	excluded := make(map[string]struct{}, len(targets))
+	for _, target := range targets {
+		excluded[target.id] = struct{}{}
+	}
+
+	for _, target := range targets {
+		for _, scopeRole := range target.scopeRoles {
+			if scopeRole.RoleName != adminRoleName {
+				continue
+			}
+
+			found, err := g.hasOtherAdmin(ctx, target.scope, scopeRole, excluded)
+			if err != nil {
+				return err
+			}
+
+			if !found {
+				return &GuardrailError{
+					Rule:   GuardrailLastAdmin,
+					Detail: fmt.Sprintf("%s %s is the last admin of %s %s", target.resourceTypeID, target.id, target.scope, scopeRole.ID),
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// hasOtherAdmin looks for a user or service user outside of excluded that holds the admin role in the same scope.
+func (g *guardrails) hasOtherAdmin(ctx context.Context, scope string, adminRole sentinelone.Role, excluded map[string]struct{}) (bool, error) {
+	params := sentinelone.ParamsMap{
+		rolesFilter: adminRole.RoleID,
+	}
+	switch scope {
+	case scopeAccount:
+		params[accountsFilter] = adminRole.ID
+	case scopeSite:
+		params[sitesFilter] = adminRole.ID
+	}
+
+	isOtherAdmin := func(id, principalScope string, scopeRoles []sentinelone.Role) bool {
+		if _, ok := excluded[id]; ok || principalScope != scope {
+			return false
+		}
+
+		for _, scopeRole := range scopeRoles {
+			if scopeRole.RoleID == adminRole.RoleID && (scope == scopeTenant || scopeRole.ID == adminRole.ID) {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	client, err := g.routes.clientFor(ctx, scope, adminRole.ID)
+	if err != nil {
+		return false, err
+	}
+
+	page := ""
+	for {
+		params[cursor] = page
+		users, nextCursor, err := client.GetUsers(ctx, params)
+		if err != nil {
+			return false, fmt.Errorf("failed to list admins of %s %s: %w", scope, adminRole.ID, err)
+		}
+
+		for _, user := range users {
+			if isOtherAdmin(user.ID, user.Scope, user.ScopeRoles) {
+				return true, nil
+			}
+		}
+
+		if nextCursor == "" {
+			break
+		}
+		page = nextCursor
+	}
+
+	page = ""
+	for {
+		params[cursor] = page
+		serviceUsers, nextCursor, err := client.GetServiceUsers(ctx, params)
+		if err != nil {
+			return false, fmt.Errorf("failed to list service user admins of %s %s: %w", scope, adminRole.ID, err)
+		}
+
+		for _, serviceUser := range serviceUsers {
+			if isOtherAdmin(serviceUser.ID, serviceUser.Scope, serviceUser.ScopeRoles) {
+				return true, nil
+			}
+		}
+
+		if nextCursor == "" {
+			break
+		}
+		page = nextCursor
+	}
+
+	return false, nil
+}
+
+func toSet(values []string) map[string]struct{} {
+	rv := make(map[string]struct{}, len(values))
+	for _, v := range values {
+		rv[v] = struct{}{}
+	}
+
+	return rv
+}
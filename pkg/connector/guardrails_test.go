@@ -0,0 +1,158 @@
+package connector
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/conductorone/baton-sentinel-one/pkg/sentinelone"
+)
+
+func TestGuardrails(t *testing.T) {
+	adminOf := func(accountID string) []sentinelone.Role {
+		return []sentinelone.Role{{ID: accountID, RoleID: "admin", RoleName: adminRoleName}}
+	}
+	viewerOf := func(accountID string) []sentinelone.Role {
+		return []sentinelone.Role{{ID: accountID, RoleID: "viewer", RoleName: "Viewer"}}
+	}
+
+	tests := []struct {
+		name       string
+		guardrails Guardrails
+		users      []sentinelone.User
+		target     string
+		slug       string
+		// rule is the guardrail expected to block the request, empty when it is sent.
+		rule string
+		path string
+	}{
+		{
+			name:       "token owner",
+			guardrails: DefaultGuardrails(),
+			users:      []sentinelone.User{{ID: "owner", Scope: scopeTenant}},
+			target:     "owner",
+			slug:       apiTokenEntitlement,
+			rule:       GuardrailTokenOwner,
+		},
+		{
+			name:       "token owner disabled",
+			guardrails: Guardrails{},
+			users:      []sentinelone.User{{ID: "owner", Scope: scopeTenant}},
+			target:     "owner",
+			slug:       apiTokenEntitlement,
+			path:       "users/revoke-api-token",
+		},
+		{
+			name:       "api token of the last admin",
+			guardrails: DefaultGuardrails(),
+			users:      []sentinelone.User{{ID: "u1", Scope: scopeAccount, ScopeRoles: adminOf("a1")}},
+			target:     "u1",
+			slug:       apiTokenEntitlement,
+			rule:       GuardrailLastAdmin,
+		},
+		{
+			name:       "two-factor enrollment of the last admin",
+			guardrails: DefaultGuardrails(),
+			users:      []sentinelone.User{{ID: "u1", Scope: scopeAccount, ScopeRoles: adminOf("a1")}},
+			target:     "u1",
+			slug:       twoFactorEnrollmentEntitlement,
+			rule:       GuardrailLastAdmin,
+		},
+		{
+			name:       "two-factor requirement of the last admin",
+			guardrails: DefaultGuardrails(),
+			users:      []sentinelone.User{{ID: "u1", Scope: scopeAccount, ScopeRoles: adminOf("a1")}},
+			target:     "u1",
+			slug:       twoFactorRequiredEntitlement,
+			path:       "users/2fa/disable",
+		},
+		{
+			name:       "api token of an admin of an account with another admin",
+			guardrails: DefaultGuardrails(),
+			users: []sentinelone.User{
+				{ID: "u1", Scope: scopeAccount, ScopeRoles: adminOf("a1")},
+				{ID: "u2", Scope: scopeAccount, ScopeRoles: adminOf("a1")},
+			},
+			target: "u1",
+			slug:   apiTokenEntitlement,
+			path:   "users/revoke-api-token",
+		},
+		{
+			name:       "admin of another account doesn't count",
+			guardrails: DefaultGuardrails(),
+			users: []sentinelone.User{
+				{ID: "u1", Scope: scopeAccount, ScopeRoles: adminOf("a1")},
+				{ID: "u2", Scope: scopeAccount, ScopeRoles: adminOf("a2")},
+			},
+			target: "u1",
+			slug:   apiTokenEntitlement,
+			rule:   GuardrailLastAdmin,
+		},
+		{
+			name:       "last admin disabled",
+			guardrails: Guardrails{ProtectTokenOwner: true},
+			users:      []sentinelone.User{{ID: "u1", Scope: scopeAccount, ScopeRoles: adminOf("a1")}},
+			target:     "u1",
+			slug:       twoFactorEnrollmentEntitlement,
+			path:       "users/2fa/reset",
+		},
+		{
+			name:       "principal of an allowed account",
+			guardrails: Guardrails{AllowedAccountIDs: []string{"a1"}},
+			users:      []sentinelone.User{{ID: "u1", Scope: scopeAccount, ScopeRoles: viewerOf("a1")}},
+			target:     "u1",
+			slug:       apiTokenEntitlement,
+			path:       "users/revoke-api-token",
+		},
+		{
+			name:       "principal of another account",
+			guardrails: Guardrails{AllowedAccountIDs: []string{"a1"}},
+			users:      []sentinelone.User{{ID: "u1", Scope: scopeAccount, ScopeRoles: viewerOf("a2")}},
+			target:     "u1",
+			slug:       apiTokenEntitlement,
+			rule:       GuardrailScopeNotAllowed,
+		},
+		{
+			name:       "tenant principal with an allowlist",
+			guardrails: Guardrails{AllowedSiteIDs: []string{"s1"}},
+			users:      []sentinelone.User{{ID: "u1", Scope: scopeTenant}},
+			target:     "u1",
+			slug:       apiTokenEntitlement,
+			rule:       GuardrailScopeNotAllowed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeConsole(t)
+			f.users = tt.users
+			s := f.newConnector(t, WithGuardrails(tt.guardrails))
+
+			_, err := userBuilder(s.consoles, s.types).Revoke(context.Background(), userGrant(t, s, tt.target, tt.slug, tt.target))
+
+			if tt.rule == "" {
+				if err != nil {
+					t.Fatalf("Revoke() error = %v", err)
+				}
+				if sent := f.sent("POST", tt.path); len(sent) != 1 {
+					t.Fatalf("sent %d requests to %s, want 1", len(sent), tt.path)
+				}
+				return
+			}
+
+			var guardrailErr *GuardrailError
+			if !errors.As(err, &guardrailErr) || guardrailErr.Rule != tt.rule {
+				t.Fatalf("Revoke() error = %v, want guardrail %s", err, tt.rule)
+			}
+			if code := status.Code(err); code != codes.FailedPrecondition {
+				t.Errorf("status code = %s, want %s", code, codes.FailedPrecondition)
+			}
+			if writes := f.writes(); len(writes) != 0 {
+				t.Errorf("sent %v, want no write", writes)
+			}
+		})
+	}
+}
@@ -6,16 +6,20 @@ import (
 	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
 	"github.com/conductorone/baton-sdk/pkg/annotations"
 	"github.com/conductorone/baton-sdk/pkg/pagination"
+	"google.golang.org/protobuf/types/known/structpb"
 )
 
 const (
 	accountsFilter = "accountIds"
 	sitesFilter    = "siteIds"
 	rolesFilter    = "roleIds"
+	updatedAfter   = "updatedAt__gt"
+	createdAfter   = "createdAt__gt"
 	cursor         = "cursor"
 )
 
-func annotationsForUserResourceType() annotations.Annotations {
+// skipEntitlementsAndGrants annotates the resource types without entitlements or grants of their own.
+func skipEntitlementsAndGrants() annotations.Annotations {
 	annos := annotations.Annotations{}
 	annos.Update(&v2.SkipEntitlementsAndGrants{})
 	return annos
@@ -38,6 +42,14 @@ func parsePageToken(i string, resourceID *v2.ResourceId) (*pagination.Bag, strin
 	return b, b.PageToken(), nil
 }
 
+func getProfileBoolValue(profile *structpb.Struct, k string) bool {
+	if profile == nil {
+		return false
+	}
+
+	return profile.GetFields()[k].GetBoolValue()
+}
+
 func splitFullName(name string) (string, string) {
 	names := strings.SplitN(name, " ", 2)
 	var firstName, lastName string
@@ -0,0 +1,1007 @@
+package connector
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+	"go.uber.org/zap"
+
+	"github.com/conductorone/baton-sentinel-one/pkg/sentinelone"
+)
+
+const (
+	snapshotVersion = 1
+	// snapshotPageSize is the number of items of a page listed from the snapshot.
+	snapshotPageSize = 100
+)
+
+// IncrementalSync configures syncs that only fetch the accounts, sites, users and service users changed since the last
+// successful sync, and merge them with the snapshot that sync left.
+type IncrementalSync struct {
+	// StatePath is the file holding the snapshot and the watermark of the last successful sync, empty for full syncs only.
+	StatePath string
+	// FullSyncInterval is the time after which a full sync is run again, 0 only runs one when the snapshot can't be used.
+	FullSyncInterval time.Duration
+	// Overlap is subtracted from the watermark, so the changes made while the last sync ran or hidden by clock skew
+	// are fetched again.
+	Overlap time.Duration
+}
+
+// DefaultIncrementalSync fetches the changes made up to 10 minutes before the last sync started again.
+func DefaultIncrementalSync() IncrementalSync {
+	return IncrementalSync{
+		Overlap: 10 * time.Minute,
+	}
+}
+
+// Validate returns an error if the full sync interval or the overlap is negative.
+func (s IncrementalSync) Validate() error {
+	if s.FullSyncInterval < 0 {
+		return fmt.Errorf("full sync interval must not be negative")
+	}
+
+	if s.Overlap < 0 {
+		return fmt.Errorf("watermark overlap must not be negative")
+	}
+
+	return nil
+}
+
+// pendingSnapshotPath is the file the sync writes its snapshot to, it replaces the state once the sync succeeded.
+func pendingSnapshotPath(statePath string) string {
+	return statePath + ".pending"
+}
+
+// membersLogPath is the file the grants listed since the snapshot was written are appended to.
+func membersLogPath(snapshotPath string) string {
+	return snapshotPath + ".log"
+}
+
+// commitSyncState makes the snapshot written by the sync that just succeeded the base of the next incremental sync.
+// The snapshot of a failed sync is never committed, the next sync starts again from the last successful one.
+func commitSyncState(statePath string) error {
+	pending := pendingSnapshotPath(statePath)
+	if _, err := os.Stat(pending); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("failed to save sync state: %w", err)
+	}
+
+	err := os.Rename(membersLogPath(pending), membersLogPath(statePath))
+	if errors.Is(err, os.ErrNotExist) {
+		err = os.Remove(membersLogPath(statePath))
+	}
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to save sync state: %w", err)
+	}
+
+	if err := os.Rename(pending, statePath); err != nil {
+		return fmt.Errorf("failed to save sync state: %w", err)
+	}
+
+	return nil
+}
+
+// snapshot is what the last sync listed, the state of an incremental sync.
+type snapshot struct {
+	Version int `json:"version"`
+	// Fingerprint is the configuration the snapshot was taken with, another configuration lists other items.
+	Fingerprint string `json:"fingerprint"`
+	// Watermark is when the sync that took the snapshot started.
+	Watermark  time.Time                   `json:"watermark"`
+	FullSyncAt time.Time                   `json:"full_sync_at"`
+	Consoles   map[string]*consoleSnapshot `json:"consoles"`
+}
+
+type consoleSnapshot struct {
+	// Routes holds what each api token of the console listed.
+	Routes       []*routeSnapshot                 `json:"routes"`
+	Users        members[sentinelone.User]        `json:"users"`
+	ServiceUsers members[sentinelone.ServiceUser] `json:"service_users"`
+	Sites        members[sentinelone.Site]        `json:"sites"`
+}
+
+type routeSnapshot struct {
+	Accounts     listing[sentinelone.Account]     `json:"accounts"`
+	Sites        listing[sentinelone.Site]        `json:"sites"`
+	Users        listing[sentinelone.User]        `json:"users"`
+	ServiceUsers listing[sentinelone.ServiceUser] `json:"service_users"`
+}
+
+// listing is every item of a resource type an api token can read.
+type listing[T any] struct {
+	Items map[string]T `json:"items"`
+	// Complete is set once the listing was refreshed, a listing left incomplete by a sync is listed again in full.
+	Complete bool `json:"complete"`
+}
+
+// members is the grants of the accounts and sites to a resource type.
+type members[T any] struct {
+	// Of holds the ids listed by the grants of each account and site, by scope and id.
+	Of    map[string][]string `json:"of"`
+	Items map[string]T        `json:"items"`
+}
+
+// drop forgets the grants of the scopes, they are listed again.
+func (m *members[T]) drop(keys ...string) {
+	for _, key := range keys {
+		delete(m.Of, key)
+	}
+}
+
+func (m *members[T]) dropAll() {
+	m.Of = make(map[string][]string)
+}
+
+func memberKey(scope, id string) string {
+	return scope + "/" + id
+}
+
+func newConsoleSnapshot() *consoleSnapshot {
+	return &consoleSnapshot{
+		Users:        members[sentinelone.User]{Of: make(map[string][]string), Items: make(map[string]sentinelone.User)},
+		ServiceUsers: members[sentinelone.ServiceUser]{Of: make(map[string][]string), Items: make(map[string]sentinelone.ServiceUser)},
+		Sites:        members[sentinelone.Site]{Of: make(map[string][]string), Items: make(map[string]sentinelone.Site)},
+	}
+}
+
+func newRouteSnapshot() *routeSnapshot {
+	return &routeSnapshot{
+		Accounts:     listing[sentinelone.Account]{Items: make(map[string]sentinelone.Account)},
+		Sites:        listing[sentinelone.Site]{Items: make(map[string]sentinelone.Site)},
+		Users:        listing[sentinelone.User]{Items: make(map[string]sentinelone.User)},
+		ServiceUsers: listing[sentinelone.ServiceUser]{Items: make(map[string]sentinelone.ServiceUser)},
+	}
+}
+
+// route returns the snapshot of an api token of the console.
+func (cs *consoleSnapshot) route(index int) *routeSnapshot {
+	for len(cs.Routes) <= index {
+		cs.Routes = append(cs.Routes, newRouteSnapshot())
+	}
+
+	return cs.Routes[index]
+}
+
+// membersOf returns the grants listed for the accounts and sites by resource type.
+func (cs *consoleSnapshot) membersOf(resourceTypeID string) map[string][]string {
+	switch resourceTypeID {
+	case resourceTypeUser.Id:
+		return cs.Users.Of
+	case resourceTypeServiceUser.Id:
+		return cs.ServiceUsers.Of
+	case resourceTypeSite.Id:
+		return cs.Sites.Of
+	default:
+		return nil
+	}
+}
+
+// invalidate drops the grants an added, changed or deleted item may be listed in.
+func (cs *consoleSnapshot) invalidate(item interface{}) {
+	switch v := item.(type) {
+	case sentinelone.Account:
+		key := memberKey(scopeAccount, v.ID)
+		cs.Users.drop(key)
+		cs.ServiceUsers.drop(key)
+		cs.Sites.drop(key)
+	case sentinelone.Site:
+		key := memberKey(scopeSite, v.ID)
+		cs.Users.drop(key)
+		cs.ServiceUsers.drop(key)
+		cs.Sites.drop(memberKey(scopeAccount, v.AccountID))
+	case sentinelone.User:
+		// nothing to drop yet, e.g. in a full sync.
+		if len(cs.Users.Of) == 0 {
+			return
+		}
+		keys, all := cs.principalScopes(v.Scope, v.ScopeRoles)
+		if all {
+			cs.Users.dropAll()
+		}
+		cs.Users.drop(keys...)
+	case sentinelone.ServiceUser:
+		if len(cs.ServiceUsers.Of) == 0 {
+			return
+		}
+		keys, all := cs.principalScopes(v.Scope, v.ScopeRoles)
+		if all {
+			cs.ServiceUsers.dropAll()
+		}
+		cs.ServiceUsers.drop(keys...)
+	}
+}
+
+// principalScopes returns the accounts and sites whose grants may list a principal: the accounts and sites it has a role in,
+// with the sites of these accounts and the accounts of these sites. Tenant and unknown scope principals may be listed by all.
+func (cs *consoleSnapshot) principalScopes(scope string, scopeRoles []sentinelone.Role) ([]string, bool) {
+	if scope != scopeAccount && scope != scopeSite {
+		return nil, true
+	}
+
+	var rv []string
+	for _, scopeRole := range scopeRoles {
+		switch scope {
+		case scopeAccount:
+			rv = append(rv, memberKey(scopeAccount, scopeRole.ID))
+			for _, site := range cs.sites() {
+				if site.AccountID == scopeRole.ID {
+					rv = append(rv, memberKey(scopeSite, site.ID))
+				}
+			}
+		case scopeSite:
+			rv = append(rv, memberKey(scopeSite, scopeRole.ID))
+			if site, ok := cs.sites()[scopeRole.ID]; ok {
+				rv = append(rv, memberKey(scopeAccount, site.AccountID))
+			}
+		}
+	}
+
+	return rv, false
+}
+
+// sites returns the sites known to the snapshot, listed by any api token or by the grants of an account.
+func (cs *consoleSnapshot) sites() map[string]sentinelone.Site {
+	rv := make(map[string]sentinelone.Site, len(cs.Sites.Items))
+	for id, site := range cs.Sites.Items {
+		rv[id] = site
+	}
+	for _, r := range cs.Routes {
+		for id, site := range r.Sites.Items {
+			rv[id] = site
+		}
+	}
+
+	return rv
+}
+
+// listKind is a resource type listed from the api, and where its items are kept in the snapshot.
+type listKind[T any] struct {
+	resourceTypeID string
+	id             func(T) string
+	get            func(*sentinelone.Client) func(context.Context, sentinelone.ParamsMap) ([]T, string, error)
+	count          func(*sentinelone.Client) func(context.Context) (int, error)
+	listing        func(*routeSnapshot) *listing[T]
+	// members is nil for the resource types never granted to an account or site.
+	members func(*consoleSnapshot) *members[T]
+	// each streams a page, nil for the resource types only listed whole.
+	each func(*sentinelone.Client) func(context.Context, sentinelone.ParamsMap, func(T) error) (string, error)
+}
+
+var (
+	accountList = listKind[sentinelone.Account]{
+		resourceTypeID: resourceTypeAccount.Id,
+		id:             func(a sentinelone.Account) string { return a.ID },
+		get: func(c *sentinelone.Client) func(context.Context, sentinelone.ParamsMap) ([]sentinelone.Account, string, error) {
+			return c.GetAccounts
+		},
+		count:   func(c *sentinelone.Client) func(context.Context) (int, error) { return c.CountAccounts },
+		listing: func(r *routeSnapshot) *listing[sentinelone.Account] { return &r.Accounts },
+	}
+	siteList = listKind[sentinelone.Site]{
+		resourceTypeID: resourceTypeSite.Id,
+		id:             func(s sentinelone.Site) string { return s.ID },
+		get: func(c *sentinelone.Client) func(context.Context, sentinelone.ParamsMap) ([]sentinelone.Site, string, error) {
+			return c.GetSites
+		},
+		count:   func(c *sentinelone.Client) func(context.Context) (int, error) { return c.CountSites },
+		listing: func(r *routeSnapshot) *listing[sentinelone.Site] { return &r.Sites },
+		members: func(cs *consoleSnapshot) *members[sentinelone.Site] { return &cs.Sites },
+	}
+	userList = listKind[sentinelone.User]{
+		resourceTypeID: resourceTypeUser.Id,
+		id:             func(u sentinelone.User) string { return u.ID },
+		get: func(c *sentinelone.Client) func(context.Context, sentinelone.ParamsMap) ([]sentinelone.User, string, error) {
+			return c.GetUsers
+		},
+		count:   func(c *sentinelone.Client) func(context.Context) (int, error) { return c.CountUsers },
+		listing: func(r *routeSnapshot) *listing[sentinelone.User] { return &r.Users },
+		members: func(cs *consoleSnapshot) *members[sentinelone.User] { return &cs.Users },
+		each: func(c *sentinelone.Client) func(context.Context, sentinelone.ParamsMap, func(sentinelone.User) error) (string, error) {
+			return c.EachUser
+		},
+	}
+	serviceUserList = listKind[sentinelone.ServiceUser]{
+		resourceTypeID: resourceTypeServiceUser.Id,
+		id:             func(s sentinelone.ServiceUser) string { return s.ID },
+		get: func(c *sentinelone.Client) func(context.Context, sentinelone.ParamsMap) ([]sentinelone.ServiceUser, string, error) {
+			return c.GetServiceUsers
+		},
+		count:   func(c *sentinelone.Client) func(context.Context) (int, error) { return c.CountServiceUsers },
+		listing: func(r *routeSnapshot) *listing[sentinelone.ServiceUser] { return &r.ServiceUsers },
+		members: func(cs *consoleSnapshot) *members[sentinelone.ServiceUser] { return &cs.ServiceUsers },
+		each: func(c *sentinelone.Client) func(context.Context, sentinelone.ParamsMap, func(sentinelone.ServiceUser) error) (string, error) {
+			return c.EachServiceUser
+		},
+	}
+)
+
+// snapshots keeps the snapshot of every console for incremental syncs. The snapshot of the last successful sync is loaded
+// when the sync lists its first items, and the snapshot of this sync is written next to it as it goes, to be committed
+// when the grants of the sync are listed.
+type snapshots struct {
+	// ctx outlives the requests the snapshot is written for.
+	ctx         context.Context
+	cfg         IncrementalSync
+	fingerprint string
+	started     time.Time
+
+	mtx    sync.Mutex
+	loaded bool
+	state  *snapshot
+	// since is the watermark the changes are fetched from, empty for a full sync.
+	since string
+	// complete holds the listings the loaded snapshot held in full.
+	complete map[string]bool
+	// refreshed holds the ids of the listings refreshed by this sync, in the order they are listed.
+	refreshed map[string][]string
+	// pending holds the ids of the grants being listed, until their last page.
+	pending map[string][]string
+}
+
+// newSnapshots returns nil when there is no state file, the syncs are then full syncs without snapshot.
+func newSnapshots(ctx context.Context, cfg IncrementalSync, fingerprint string) *snapshots {
+	if cfg.StatePath == "" {
+		return nil
+	}
+
+	return &snapshots{
+		ctx:         ctx,
+		cfg:         cfg,
+		fingerprint: fingerprint,
+		started:     time.Now(),
+		complete:    make(map[string]bool),
+		refreshed:   make(map[string][]string),
+		pending:     make(map[string][]string),
+	}
+}
+
+// reset is called when a sync starts, the connector may run several syncs. The snapshot of the last successful sync
+// is loaded again, and a snapshot left by a failed sync is removed so the next successful one doesn't commit it.
+func (s *snapshots) reset(_ context.Context) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	pending := pendingSnapshotPath(s.cfg.StatePath)
+	for _, path := range []string{pending, membersLogPath(pending)} {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to remove pending sync state: %w", err)
+		}
+	}
+
+	s.started = time.Now()
+	s.loaded = false
+	s.state = nil
+	s.since = ""
+	s.complete = make(map[string]bool)
+	s.refreshed = make(map[string][]string)
+	s.pending = make(map[string][]string)
+
+	return nil
+}
+
+// commit is called when the grants of the sync are listed, the snapshot becomes the base of the next sync.
+func (s *snapshots) commit(ctx context.Context) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if err := commitSyncState(s.cfg.StatePath); err != nil {
+		ctxzap.Extract(ctx).Warn("failed to commit sync state", zap.String("path", s.cfg.StatePath), zap.Error(err))
+	}
+
+	return nil
+}
+
+// snapshotFingerprint identifies the configuration that decides what the sync lists.
+func snapshotFingerprint(consoles []Console, o *options) string {
+	type fingerprintConsole struct {
+		Name   string
+		URL    string
+		Tokens int
+	}
+
+	disabled := append([]string(nil), o.disabledResourceTypes...)
+	sort.Strings(disabled)
+
+	v := struct {
+		APIVersion string
+		Consoles   []fingerprintConsole
+		Disabled   []string
+		Scopes     ScopeFilter
+	}{
+		APIVersion: o.apiVersion,
+		Disabled:   disabled,
+		Scopes:     o.scopes,
+	}
+	for _, c := range consoles {
+		v.Consoles = append(v.Consoles, fingerprintConsole{
+			Name:   c.Name,
+			URL:    c.URL,
+			Tokens: len(c.Tokens) + len(c.TokenFiles),
+		})
+	}
+
+	data, _ := json.Marshal(v)
+	sum := sha256.Sum256(data)
+
+	return hex.EncodeToString(sum[:])
+}
+
+// loadLocked reads the snapshot of the last successful sync, or starts a full sync when it can't be used.
+func (s *snapshots) loadLocked(ctx context.Context) {
+	if s.loaded {
+		return
+	}
+	s.loaded = true
+
+	l := ctxzap.Extract(ctx)
+
+	prev, reason := s.readState()
+	if prev == nil {
+		l.Info("running a full sync", zap.String("reason", reason))
+		s.state = &snapshot{
+			Version:     snapshotVersion,
+			Fingerprint: s.fingerprint,
+			Watermark:   s.started,
+			FullSyncAt:  s.started,
+			Consoles:    make(map[string]*consoleSnapshot),
+		}
+		return
+	}
+
+	s.since = prev.Watermark.Add(-s.cfg.Overlap).UTC().Format(time.RFC3339Nano)
+	l.Info("running an incremental sync", zap.String("changed_since", s.since), zap.Time("last_full_sync", prev.FullSyncAt))
+
+	// the listings are only complete again once this sync refreshed them.
+	for name, cs := range prev.Consoles {
+		for i, r := range cs.Routes {
+			s.complete[listingKey(name, i, resourceTypeAccount.Id)] = r.Accounts.Complete
+			s.complete[listingKey(name, i, resourceTypeSite.Id)] = r.Sites.Complete
+			s.complete[listingKey(name, i, resourceTypeUser.Id)] = r.Users.Complete
+			s.complete[listingKey(name, i, resourceTypeServiceUser.Id)] = r.ServiceUsers.Complete
+			r.Accounts.Complete = false
+			r.Sites.Complete = false
+			r.Users.Complete = false
+			r.ServiceUsers.Complete = false
+		}
+	}
+
+	prev.Watermark = s.started
+	s.state = prev
+}
+
+// readState returns the snapshot of the last successful sync, or nil and why a full sync is run instead.
+func (s *snapshots) readState() (*snapshot, string) {
+	data, err := os.ReadFile(s.cfg.StatePath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, "no sync state"
+		}
+		return nil, fmt.Sprintf("failed to read sync state: %s", err)
+	}
+
+	var rv snapshot
+	if err := json.Unmarshal(data, &rv); err != nil {
+		return nil, fmt.Sprintf("failed to read sync state: %s", err)
+	}
+
+	switch {
+	case rv.Version != snapshotVersion:
+		return nil, "sync state of another version"
+	case rv.Fingerprint != s.fingerprint:
+		return nil, "configuration changed since the last sync"
+	case s.cfg.FullSyncInterval > 0 && s.started.Sub(rv.FullSyncAt) >= s.cfg.FullSyncInterval:
+		return nil, "full sync interval elapsed"
+	}
+
+	if rv.Consoles == nil {
+		rv.Consoles = make(map[string]*consoleSnapshot)
+	}
+	for _, cs := range rv.Consoles {
+		for _, r := range cs.Routes {
+			initListing(&r.Accounts)
+			initListing(&r.Sites)
+			initListing(&r.Users)
+			initListing(&r.ServiceUsers)
+		}
+		initMembers(&cs.Users)
+		initMembers(&cs.ServiceUsers)
+		initMembers(&cs.Sites)
+	}
+
+	if err := replayMembersLog(&rv, membersLogPath(s.cfg.StatePath)); err != nil {
+		return nil, fmt.Sprintf("failed to read sync state: %s", err)
+	}
+
+	return &rv, ""
+}
+
+// replayMembersLog adds the grants listed after the snapshot was written. A line cut short by the end of the connector
+// ends the log, these grants are listed again.
+func replayMembersLog(state *snapshot, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadBytes('\n')
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+
+		var header struct {
+			Console        string `json:"console"`
+			ResourceTypeID string `json:"resource_type"`
+		}
+		if err := json.Unmarshal(line, &header); err != nil {
+			return err
+		}
+
+		cs, ok := state.Consoles[header.Console]
+		if !ok {
+			cs = newConsoleSnapshot()
+			state.Consoles[header.Console] = cs
+		}
+
+		switch header.ResourceTypeID {
+		case resourceTypeUser.Id:
+			err = replayMembers(cs, userList, line)
+		case resourceTypeServiceUser.Id:
+			err = replayMembers(cs, serviceUserList, line)
+		case resourceTypeSite.Id:
+			err = replayMembers(cs, siteList, line)
+		default:
+			err = fmt.Errorf("unexpected resource type %q in sync state log", header.ResourceTypeID)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func initListing[T any](l *listing[T]) {
+	if l.Items == nil {
+		l.Items = make(map[string]T)
+	}
+}
+
+func initMembers[T any](m *members[T]) {
+	if m.Of == nil {
+		m.Of = make(map[string][]string)
+	}
+	if m.Items == nil {
+		m.Items = make(map[string]T)
+	}
+}
+
+func (s *snapshots) consoleLocked(c *console) *consoleSnapshot {
+	cs, ok := s.state.Consoles[c.name]
+	if !ok {
+		cs = newConsoleSnapshot()
+		s.state.Consoles[c.name] = cs
+	}
+
+	return cs
+}
+
+func listingKey(consoleName string, index int, resourceTypeID string) string {
+	return consoleName + "/" + strconv.Itoa(index) + "/" + resourceTypeID
+}
+
+// fresh reports whether every api token of the console refreshed its listing of the resource type in this sync,
+// so the grants kept for it were dropped when the items changed.
+func (s *snapshots) freshLocked(c *console, resourceTypeID string) bool {
+	for i := range c.routes.all {
+		if _, ok := s.refreshed[listingKey(c.name, i, resourceTypeID)]; !ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+// writeLocked writes the snapshot, when a listing was refreshed. The grants listed afterwards are appended to the log
+// of the snapshot as they complete, so the snapshot is whole when the sync ends.
+func (s *snapshots) writeLocked() {
+	pending := pendingSnapshotPath(s.cfg.StatePath)
+
+	data, err := json.Marshal(s.state)
+	if err == nil {
+		err = writeFile(pending, data)
+	}
+	if err == nil {
+		err = os.Remove(membersLogPath(pending))
+		if errors.Is(err, os.ErrNotExist) {
+			err = nil
+		}
+	}
+	if err != nil {
+		ctxzap.Extract(s.ctx).Warn("failed to write sync state", zap.String("path", s.cfg.StatePath), zap.Error(err))
+	}
+}
+
+// membersRecord is a line of the log of the snapshot, the grants of an account or site to a resource type.
+type membersRecord[T any] struct {
+	Console        string   `json:"console"`
+	ResourceTypeID string   `json:"resource_type"`
+	Key            string   `json:"key"`
+	IDs            []string `json:"ids"`
+	Items          []T      `json:"items"`
+}
+
+func (s *snapshots) appendLocked(record interface{}) {
+	data, err := json.Marshal(record)
+	if err == nil {
+		var f *os.File
+		f, err = os.OpenFile(membersLogPath(pendingSnapshotPath(s.cfg.StatePath)), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+		if err == nil {
+			_, err = f.Write(append(data, '\n'))
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+		}
+	}
+	if err != nil {
+		ctxzap.Extract(s.ctx).Warn("failed to write sync state", zap.String("path", s.cfg.StatePath), zap.Error(err))
+	}
+}
+
+// replayMembers applies a line of the log of the snapshot.
+func replayMembers[T any](cs *consoleSnapshot, kind listKind[T], line []byte) error {
+	var record membersRecord[T]
+	if err := json.Unmarshal(line, &record); err != nil {
+		return err
+	}
+
+	m := kind.members(cs)
+	for _, item := range record.Items {
+		m.Items[kind.id(item)] = item
+	}
+	m.Of[record.Key] = record.IDs
+
+	return nil
+}
+
+// listPage returns a page of the items of a resource type listed with an api token. With incremental syncs the items
+// are listed from the snapshot, refreshed with what changed since the last sync the first time they are listed.
+func listPage[T any](ctx context.Context, c *console, index int, kind listKind[T], page string) ([]T, string, error) {
+	client := c.routes.all[index].client
+	if c.snapshots == nil {
+		return kind.get(client)(ctx, sentinelone.ParamsMap{
+			cursor: page,
+		})
+	}
+
+	s := c.snapshots
+	key := listingKey(c.name, index, kind.resourceTypeID)
+
+	s.mtx.Lock()
+	_, refreshed := s.refreshed[key]
+	s.mtx.Unlock()
+
+	if !refreshed {
+		if err := refreshListing(ctx, c, index, kind); err != nil {
+			return nil, "", err
+		}
+	}
+
+	offset := 0
+	if page != "" {
+		var err error
+		offset, err = strconv.Atoi(page)
+		if err != nil {
+			return nil, "", fmt.Errorf("invalid snapshot page token %q: %w", page, err)
+		}
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	ids := s.refreshed[key]
+	if offset > len(ids) {
+		offset = len(ids)
+	}
+	end := offset + snapshotPageSize
+	if end > len(ids) {
+		end = len(ids)
+	}
+
+	items := kind.listing(s.consoleLocked(c).route(index)).Items
+	rv := make([]T, 0, end-offset)
+	for _, id := range ids[offset:end] {
+		rv = append(rv, items[id])
+	}
+
+	var nextPage string
+	if end < len(ids) {
+		nextPage = strconv.Itoa(end)
+	}
+
+	return rv, nextPage, nil
+}
+
+// eachListed calls fn with every item of a page listed with an api token, streamed from the api unless the syncs are
+// incremental. It returns the token of the next page.
+func eachListed[T any](ctx context.Context, c *console, index int, kind listKind[T], page string, fn func(T) error) (string, error) {
+	if c.snapshots == nil {
+		return kind.each(c.routes.all[index].client)(ctx, sentinelone.ParamsMap{
+			cursor: page,
+		}, fn)
+	}
+
+	items, nextPage, err := listPage(ctx, c, index, kind, page)
+	if err != nil {
+		return "", err
+	}
+
+	for _, item := range items {
+		if err := fn(item); err != nil {
+			return "", err
+		}
+	}
+
+	return nextPage, nil
+}
+
+// refreshListing brings the listing of the snapshot up to date. An incremental sync fetches the items added or changed
+// since the watermark, and lists everything again when the count of the items shows some were deleted.
+// The grants the changed items may be listed in are dropped from the snapshot.
+func refreshListing[T any](ctx context.Context, c *console, index int, kind listKind[T]) error {
+	l := ctxzap.Extract(ctx)
+	s := c.snapshots
+	client := c.routes.all[index].client
+	key := listingKey(c.name, index, kind.resourceTypeID)
+
+	s.mtx.Lock()
+	s.loadLocked(ctx)
+	since := s.since
+	complete := s.complete[key]
+	s.mtx.Unlock()
+
+	if since != "" && complete {
+		var changed []T
+		for _, filter := range []string{updatedAfter, createdAfter} {
+			items, err := listAll(ctx, kind.get(client), sentinelone.ParamsMap{
+				filter: since,
+			})
+			if err != nil {
+				return err
+			}
+			changed = append(changed, items...)
+		}
+
+		s.mtx.Lock()
+		cs := s.consoleLocked(c)
+		current := kind.listing(cs.route(index)).Items
+		for _, item := range changed {
+			mergeItem(cs, current, kind.id(item), item)
+		}
+		size := len(current)
+		s.mtx.Unlock()
+
+		total, err := kind.count(client)(ctx)
+		if err != nil {
+			return err
+		}
+
+		if total == size {
+			l.Debug("listed changes since the last sync",
+				zap.String("console", c.name),
+				zap.String("resource_type", kind.resourceTypeID),
+				zap.Int("changed", len(changed)),
+			)
+			s.mtx.Lock()
+			markRefreshed(s, c, index, kind)
+			s.mtx.Unlock()
+			return nil
+		}
+
+		l.Info("items were deleted since the last sync, listing all of them",
+			zap.String("console", c.name),
+			zap.String("resource_type", kind.resourceTypeID),
+			zap.Int("expected", size),
+			zap.Int("count", total),
+		)
+	}
+
+	all, err := listAll(ctx, kind.get(client), nil)
+	if err != nil {
+		return err
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	cs := s.consoleLocked(c)
+	ls := kind.listing(cs.route(index))
+	listed := make(map[string]T, len(all))
+	for _, item := range all {
+		id := kind.id(item)
+		listed[id] = item
+		mergeItem(cs, ls.Items, id, item)
+	}
+	for id, item := range ls.Items {
+		if _, ok := listed[id]; !ok {
+			cs.invalidate(item)
+			delete(ls.Items, id)
+		}
+	}
+	markRefreshed(s, c, index, kind)
+
+	return nil
+}
+
+// mergeItem stores an item listed by the sync, dropping the grants it may have been or be listed in when it changed.
+func mergeItem[T any](cs *consoleSnapshot, items map[string]T, id string, item T) {
+	old, ok := items[id]
+	if ok && reflect.DeepEqual(old, item) {
+		return
+	}
+
+	if ok {
+		cs.invalidate(old)
+	}
+	cs.invalidate(item)
+	items[id] = item
+}
+
+// markRefreshed completes the listing, the ids are kept in order for the pages listed from it.
+func markRefreshed[T any](s *snapshots, c *console, index int, kind listKind[T]) {
+	ls := kind.listing(s.consoleLocked(c).route(index))
+	ls.Complete = true
+
+	ids := make([]string, 0, len(ls.Items))
+	for id := range ls.Items {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	s.refreshed[listingKey(c.name, index, kind.resourceTypeID)] = ids
+	s.writeLocked()
+}
+
+// cachedMembers returns the items the grants of an account or site listed in the last sync, unless they may have changed.
+func cachedMembers[T any](c *console, kind listKind[T], scope, id string) ([]T, bool) {
+	s := c.snapshots
+	if s == nil {
+		return nil, false
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if !s.loaded || !s.freshLocked(c, kind.resourceTypeID) {
+		return nil, false
+	}
+
+	m := kind.members(s.consoleLocked(c))
+	ids, ok := m.Of[memberKey(scope, id)]
+	if !ok {
+		return nil, false
+	}
+
+	rv := make([]T, 0, len(ids))
+	for _, id := range ids {
+		rv = append(rv, m.Items[id])
+	}
+
+	return rv, true
+}
+
+// hasMembers reports whether the grants of an account or site to a resource type are listed from the snapshot.
+func (s *snapshots) hasMembers(c *console, scope, id, resourceTypeID string) bool {
+	if s == nil {
+		return false
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if !s.loaded || !s.freshLocked(c, resourceTypeID) {
+		return false
+	}
+
+	_, ok := s.consoleLocked(c).membersOf(resourceTypeID)[memberKey(scope, id)]
+	return ok
+}
+
+// recordMembers keeps a page of the grants of an account or site, they are stored with the last page.
+func recordMembers[T any](c *console, kind listKind[T], scope, id, page string, items []T, nextPage string) {
+	s := c.snapshots
+	if s == nil {
+		return
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if !s.loaded {
+		return
+	}
+
+	m := kind.members(s.consoleLocked(c))
+	key := memberKey(scope, id)
+	pendingKey := c.name + "/" + key + "/" + kind.resourceTypeID
+	if page == "" {
+		delete(s.pending, pendingKey)
+	}
+
+	ids := s.pending[pendingKey]
+	for _, item := range items {
+		itemID := kind.id(item)
+		ids = append(ids, itemID)
+		m.Items[itemID] = item
+	}
+
+	if nextPage != "" {
+		s.pending[pendingKey] = ids
+		return
+	}
+
+	delete(s.pending, pendingKey)
+	if ids == nil {
+		ids = []string{}
+	}
+	m.Of[key] = ids
+
+	record := membersRecord[T]{
+		Console:        c.name,
+		ResourceTypeID: kind.resourceTypeID,
+		Key:            key,
+		IDs:            ids,
+	}
+	for _, itemID := range ids {
+		record.Items = append(record.Items, m.Items[itemID])
+	}
+	s.appendLocked(record)
+}
+
+func listAll[T any](ctx context.Context, get func(context.Context, sentinelone.ParamsMap) ([]T, string, error), params sentinelone.ParamsMap) ([]T, error) {
+	var (
+		rv   []T
+		page string
+	)
+	for {
+		p := sentinelone.ParamsMap{}
+		for k, v := range params {
+			p[k] = v
+		}
+		if page != "" {
+			p[cursor] = page
+		}
+
+		items, next, err := get(ctx, p)
+		if err != nil {
+			return nil, err
+		}
+		rv = append(rv, items...)
+
+		if next == "" {
+			return rv, nil
+		}
+		page = next
+	}
+}
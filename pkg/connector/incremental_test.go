@@ -0,0 +1,325 @@
+package connector
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
+
+	"github.com/conductorone/baton-sentinel-one/pkg/sentinelone"
+)
+
+func TestIncrementalSyncValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     IncrementalSync
+		wantErr bool
+	}{
+		{name: "default", cfg: DefaultIncrementalSync()},
+		{name: "no overlap", cfg: IncrementalSync{StatePath: "state.json"}},
+		{name: "full sync interval", cfg: IncrementalSync{FullSyncInterval: time.Hour, Overlap: time.Minute}},
+		{name: "negative full sync interval", cfg: IncrementalSync{FullSyncInterval: -time.Hour}, wantErr: true},
+		{name: "negative overlap", cfg: IncrementalSync{Overlap: -time.Minute}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.cfg.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// memberKeys returns the accounts and sites whose grants are kept, sorted.
+func memberKeys(of map[string][]string) []string {
+	rv := []string{}
+	for key := range of {
+		rv = append(rv, key)
+	}
+	sort.Strings(rv)
+
+	return rv
+}
+
+func accountUser(id string, accountIDs ...string) sentinelone.User {
+	user := sentinelone.User{ID: id, Scope: scopeAccount}
+	for _, accountID := range accountIDs {
+		user.ScopeRoles = append(user.ScopeRoles, sentinelone.Role{ID: accountID})
+	}
+
+	return user
+}
+
+func TestMergeItem(t *testing.T) {
+	all := []string{"account/a1", "account/a2", "site/s1", "site/s2"}
+
+	tests := []struct {
+		name  string
+		merge func(cs *consoleSnapshot)
+		// the accounts and sites whose members are kept, by resource type.
+		wantUsers        []string
+		wantServiceUsers []string
+		wantSites        []string
+	}{
+		{
+			name: "unchanged user",
+			merge: func(cs *consoleSnapshot) {
+				items := map[string]sentinelone.User{"u1": accountUser("u1", "a1")}
+				mergeItem(cs, items, "u1", accountUser("u1", "a1"))
+			},
+			wantUsers:        all,
+			wantServiceUsers: all,
+			wantSites:        []string{"account/a1", "account/a2"},
+		},
+		{
+			name: "new account user",
+			merge: func(cs *consoleSnapshot) {
+				mergeItem(cs, map[string]sentinelone.User{}, "u1", accountUser("u1", "a1"))
+			},
+			wantUsers:        []string{"account/a2", "site/s2"},
+			wantServiceUsers: all,
+			wantSites:        []string{"account/a1", "account/a2"},
+		},
+		{
+			name: "user moved to another account",
+			merge: func(cs *consoleSnapshot) {
+				items := map[string]sentinelone.User{"u1": accountUser("u1", "a1")}
+				mergeItem(cs, items, "u1", accountUser("u1", "a2"))
+			},
+			wantUsers:        []string{},
+			wantServiceUsers: all,
+			wantSites:        []string{"account/a1", "account/a2"},
+		},
+		{
+			name: "new site user",
+			merge: func(cs *consoleSnapshot) {
+				user := sentinelone.User{ID: "u1", Scope: scopeSite, ScopeRoles: []sentinelone.Role{{ID: "s2"}}}
+				mergeItem(cs, map[string]sentinelone.User{}, "u1", user)
+			},
+			wantUsers:        []string{"account/a1", "site/s1"},
+			wantServiceUsers: all,
+			wantSites:        []string{"account/a1", "account/a2"},
+		},
+		{
+			name: "new tenant user",
+			merge: func(cs *consoleSnapshot) {
+				mergeItem(cs, map[string]sentinelone.User{}, "u1", sentinelone.User{ID: "u1", Scope: scopeTenant})
+			},
+			wantUsers:        []string{},
+			wantServiceUsers: all,
+			wantSites:        []string{"account/a1", "account/a2"},
+		},
+		{
+			name: "new account service user",
+			merge: func(cs *consoleSnapshot) {
+				serviceUser := sentinelone.ServiceUser{ID: "su1", Scope: scopeAccount, ScopeRoles: []sentinelone.Role{{ID: "a1"}}}
+				mergeItem(cs, map[string]sentinelone.ServiceUser{}, "su1", serviceUser)
+			},
+			wantUsers:        all,
+			wantServiceUsers: []string{"account/a2", "site/s2"},
+			wantSites:        []string{"account/a1", "account/a2"},
+		},
+		{
+			name: "renamed site",
+			merge: func(cs *consoleSnapshot) {
+				items := map[string]sentinelone.Site{"s1": {ID: "s1", Name: "Paris", AccountID: "a1"}}
+				mergeItem(cs, items, "s1", sentinelone.Site{ID: "s1", Name: "Lyon", AccountID: "a1"})
+			},
+			wantUsers:        []string{"account/a1", "account/a2", "site/s2"},
+			wantServiceUsers: []string{"account/a1", "account/a2", "site/s2"},
+			wantSites:        []string{"account/a2"},
+		},
+		{
+			name: "renamed account",
+			merge: func(cs *consoleSnapshot) {
+				items := map[string]sentinelone.Account{"a2": {ID: "a2", Name: "Globex"}}
+				mergeItem(cs, items, "a2", sentinelone.Account{ID: "a2", Name: "Initech"})
+			},
+			wantUsers:        []string{"account/a1", "site/s1", "site/s2"},
+			wantServiceUsers: []string{"account/a1", "site/s1", "site/s2"},
+			wantSites:        []string{"account/a1"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cs := newConsoleSnapshot()
+			cs.Sites.Items["s1"] = sentinelone.Site{ID: "s1", AccountID: "a1"}
+			cs.Sites.Items["s2"] = sentinelone.Site{ID: "s2", AccountID: "a2"}
+			for _, key := range all {
+				cs.Users.Of[key] = []string{"u0"}
+				cs.ServiceUsers.Of[key] = []string{"su0"}
+			}
+			cs.Sites.Of["account/a1"] = []string{"s1"}
+			cs.Sites.Of["account/a2"] = []string{"s2"}
+
+			tt.merge(cs)
+
+			if got := memberKeys(cs.Users.Of); !reflect.DeepEqual(got, tt.wantUsers) {
+				t.Errorf("users kept for %v, want %v", got, tt.wantUsers)
+			}
+			if got := memberKeys(cs.ServiceUsers.Of); !reflect.DeepEqual(got, tt.wantServiceUsers) {
+				t.Errorf("service users kept for %v, want %v", got, tt.wantServiceUsers)
+			}
+			if got := memberKeys(cs.Sites.Of); !reflect.DeepEqual(got, tt.wantSites) {
+				t.Errorf("sites kept for %v, want %v", got, tt.wantSites)
+			}
+		})
+	}
+}
+
+// syncAccountUsers runs a sync of the accounts and their users, and returns the users listed and the members of the accounts.
+func syncAccountUsers(t *testing.T, s *SentinelOne) ([]string, []string) {
+	accounts := syncer(t, s, resourceTypeAccount)
+	users := syncer(t, s, resourceTypeUser)
+
+	var (
+		listed    []*v2.Resource
+		userIDs   []string
+		memberIDs []string
+	)
+	for _, account := range listResources(t, accounts, nil) {
+		listed = append(listed, account)
+		for _, user := range listResources(t, users, account.Id) {
+			listed = append(listed, user)
+			userIDs = append(userIDs, user.Id.Resource)
+		}
+	}
+
+	for _, resource := range listed {
+		grants, _, err := listGrants(syncer(t, s, &v2.ResourceType{Id: resource.Id.ResourceType}), resource)
+		if err != nil {
+			t.Fatalf("Grants() error = %v", err)
+		}
+		if resource.Id.ResourceType != resourceTypeAccount.Id {
+			continue
+		}
+		for _, g := range grants {
+			if g.Principal.Id.ResourceType == resourceTypeUser.Id {
+				memberIDs = append(memberIDs, g.Principal.Id.Resource)
+			}
+		}
+	}
+
+	sort.Strings(userIDs)
+	sort.Strings(memberIDs)
+
+	return userIDs, memberIDs
+}
+
+// TestIncrementalSyncDeletion runs two syncs with the same connector, like in daemon mode, and deletes a user between them.
+func TestIncrementalSyncDeletion(t *testing.T) {
+	statePath := filepath.Join(t.TempDir(), "state.json")
+
+	f := newFakeConsole(t)
+	f.accounts = []sentinelone.Account{{ID: "a1", Name: "Acme"}}
+	f.users = []sentinelone.User{accountUser("u1", "a1"), accountUser("u2", "a1")}
+	s := f.newConnector(t, WithIncrementalSync(IncrementalSync{StatePath: statePath, Overlap: time.Minute}))
+
+	users, members := syncAccountUsers(t, s)
+	if want := []string{"u1", "u2"}; !reflect.DeepEqual(users, want) || !reflect.DeepEqual(members, want) {
+		t.Fatalf("first sync listed users %v and members %v, want %v", users, members, want)
+	}
+	if _, err := os.Stat(pendingSnapshotPath(statePath)); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("pending state left after the sync: %v", err)
+	}
+
+	data, err := os.ReadFile(statePath)
+	if err != nil {
+		t.Fatalf("state not committed when the sync ended: %v", err)
+	}
+	var first snapshot
+	if err := json.Unmarshal(data, &first); err != nil {
+		t.Fatal(err)
+	}
+
+	f.mtx.Lock()
+	f.users = f.users[:1]
+	f.requests = nil
+	f.mtx.Unlock()
+
+	users, members = syncAccountUsers(t, s)
+	if want := []string{"u1"}; !reflect.DeepEqual(users, want) || !reflect.DeepEqual(members, want) {
+		t.Errorf("second sync listed users %v and members %v, want %v", users, members, want)
+	}
+
+	// the changes are fetched since the watermark of the first sync less the overlap.
+	var since string
+	for _, r := range f.sent(http.MethodGet, "users") {
+		if v := r.Query.Get(updatedAfter); v != "" {
+			since = v
+		}
+	}
+	if want := first.Watermark.Add(-time.Minute).UTC().Format(time.RFC3339Nano); since != want {
+		t.Errorf("changes fetched since %q, want %q", since, want)
+	}
+
+	data, err = os.ReadFile(statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var second snapshot
+	if err := json.Unmarshal(data, &second); err != nil {
+		t.Fatal(err)
+	}
+	if !second.Watermark.After(first.Watermark) {
+		t.Errorf("watermark %v not moved past %v", second.Watermark, first.Watermark)
+	}
+	for _, cs := range second.Consoles {
+		if _, ok := cs.Routes[0].Users.Items["u2"]; ok {
+			t.Error("deleted user kept in the state")
+		}
+	}
+}
+
+// TestIncrementalSyncFailed checks that the state of a failed sync is never committed, and dropped when the next one starts.
+func TestIncrementalSyncFailed(t *testing.T) {
+	statePath := filepath.Join(t.TempDir(), "state.json")
+
+	f := newFakeConsole(t)
+	f.accounts = []sentinelone.Account{{ID: "a1", Name: "Acme"}}
+	f.users = []sentinelone.User{accountUser("u1", "a1")}
+	f.setStatus("sites", http.StatusInternalServerError)
+	s := f.newConnector(t,
+		WithIncrementalSync(IncrementalSync{StatePath: statePath}),
+		WithScopeFailures(ScopeFailures{Mode: ScopeFailureFail}),
+	)
+
+	accounts := syncer(t, s, resourceTypeAccount)
+	account := listResources(t, accounts, nil)[0]
+	listResources(t, syncer(t, s, resourceTypeUser), account.Id)
+	if _, _, err := listGrants(accounts, account); err == nil {
+		t.Fatal("expected the grants to fail")
+	}
+
+	if _, err := os.Stat(statePath); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("state of a failed sync committed: %v", err)
+	}
+	if _, err := os.Stat(pendingSnapshotPath(statePath)); err != nil {
+		t.Fatalf("no pending state written by the sync: %v", err)
+	}
+
+	// the next sync starts over, its snapshot only holds the accounts it listed so far.
+	listResources(t, accounts, nil)
+	data, err := os.ReadFile(pendingSnapshotPath(statePath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pending snapshot
+	if err := json.Unmarshal(data, &pending); err != nil {
+		t.Fatal(err)
+	}
+	for _, cs := range pending.Consoles {
+		if len(cs.Routes[0].Users.Items) != 0 {
+			t.Error("pending state of the failed sync kept when the next one started")
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package connector
+
+import (
+	"context"
+	"sync"
+
+	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
+	"github.com/conductorone/baton-sdk/pkg/annotations"
+)
+
+type syncPhase int
+
+const (
+	syncIdle syncPhase = iota
+	syncListing
+	syncGranting
+)
+
+// syncLifecycle tells the connector when a sync starts and when it ends, the SDK calls the syncers without saying so.
+// A sync starts with the first List after the grants of the previous one. It ends once the grants of every resource it
+// listed, of the resource types with grants, are listed to their last page. A sync that fails before that is ended by
+// the first List of the next one, without running the hooks of its end.
+type syncLifecycle struct {
+	onStart []func(ctx context.Context) error
+	onEnd   []func(ctx context.Context) error
+
+	mtx     sync.Mutex
+	phase   syncPhase
+	pending map[string]struct{}
+}
+
+func newSyncLifecycle() *syncLifecycle {
+	return &syncLifecycle{
+		pending: make(map[string]struct{}),
+	}
+}
+
+// started registers a hook run when a sync starts, before its first List.
+func (l *syncLifecycle) started(fn func(ctx context.Context) error) {
+	l.onStart = append(l.onStart, fn)
+}
+
+// ended registers a hook run when a sync ends, before the last page of its grants is returned.
+func (l *syncLifecycle) ended(fn func(ctx context.Context) error) {
+	l.onEnd = append(l.onEnd, fn)
+}
+
+// listing is called before every List, the first one of a sync starts it.
+func (l *syncLifecycle) listing(ctx context.Context) error {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	if l.phase == syncListing {
+		return nil
+	}
+
+	l.phase = syncListing
+	l.pending = make(map[string]struct{})
+	for _, fn := range l.onStart {
+		if err := fn(ctx); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// listed records the resources returned by a List of the resource type, whose grants the sync lists later.
+func (l *syncLifecycle) listed(resourceType *v2.ResourceType, resources []*v2.Resource) {
+	annos := annotations.Annotations(resourceType.GetAnnotations())
+	if annos.Contains(&v2.SkipEntitlementsAndGrants{}) {
+		return
+	}
+
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	for _, resource := range resources {
+		l.pending[lifecycleKey(resource)] = struct{}{}
+	}
+}
+
+// failed is called when a List, Entitlements or Grants fails, which fails the sync: the next List starts another one.
+func (l *syncLifecycle) failed() {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	l.phase = syncIdle
+}
+
+// granted is called after a page of the grants of the resource, the sync ends with the last page of the last resource.
+func (l *syncLifecycle) granted(ctx context.Context, resource *v2.Resource, nextPage string) error {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	if l.phase == syncIdle {
+		return nil
+	}
+	l.phase = syncGranting
+
+	if nextPage != "" {
+		return nil
+	}
+
+	key := lifecycleKey(resource)
+	if _, ok := l.pending[key]; !ok {
+		return nil
+	}
+
+	delete(l.pending, key)
+	if len(l.pending) > 0 {
+		return nil
+	}
+
+	l.phase = syncIdle
+	for _, fn := range l.onEnd {
+		if err := fn(ctx); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func lifecycleKey(resource *v2.Resource) string {
+	return resource.GetId().GetResourceType() + "/" + resource.GetId().GetResource()
+}
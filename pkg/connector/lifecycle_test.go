@@ -0,0 +1,75 @@
+package connector
+
+import (
+	"context"
+	"testing"
+
+	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
+)
+
+func TestSyncLifecycle(t *testing.T) {
+	ctx := context.Background()
+	l := newSyncLifecycle()
+
+	var starts, ends int
+	l.started(func(context.Context) error { starts++; return nil })
+	l.ended(func(context.Context) error { ends++; return nil })
+
+	a1 := &v2.Resource{Id: &v2.ResourceId{ResourceType: resourceTypeAccount.Id, Resource: "a1"}}
+	a2 := &v2.Resource{Id: &v2.ResourceId{ResourceType: resourceTypeAccount.Id, Resource: "a2"}}
+	u1 := &v2.Resource{Id: &v2.ResourceId{ResourceType: resourceTypeServiceUser.Id, Resource: "u1"}}
+
+	list := func() {
+		for _, err := range []error{l.listing(ctx), l.listing(ctx)} {
+			if err != nil {
+				t.Fatalf("listing() error = %v", err)
+			}
+		}
+		l.listed(resourceTypeAccount, []*v2.Resource{a1, a2})
+		// service users have no grants, the sync doesn't wait for them.
+		l.listed(resourceTypeServiceUser, []*v2.Resource{u1})
+	}
+	grant := func(resource *v2.Resource, next string) {
+		if err := l.granted(ctx, resource, next); err != nil {
+			t.Fatalf("granted() error = %v", err)
+		}
+	}
+
+	list()
+	grant(a1, "")
+	grant(a2, "page")
+	if starts != 1 || ends != 0 {
+		t.Fatalf("after a partial sync: %d starts, %d ends, want 1 and 0", starts, ends)
+	}
+
+	// the sync failed, the next one starts over.
+	list()
+	if starts != 2 {
+		t.Fatalf("%d starts, want 2", starts)
+	}
+	grant(a2, "")
+	grant(a2, "")
+	if ends != 0 {
+		t.Fatal("sync ended before the grants of every account were listed")
+	}
+
+	grant(a1, "")
+	if ends != 1 {
+		t.Fatalf("%d ends, want 1", ends)
+	}
+
+	grant(a1, "")
+	if starts != 2 || ends != 1 {
+		t.Errorf("grants after the end of the sync: %d starts, %d ends, want 2 and 1", starts, ends)
+	}
+
+	// a sync failing while it lists ends without the hooks, the next List starts another one.
+	list()
+	l.failed()
+	grant(a1, "")
+	grant(a2, "")
+	list()
+	if starts != 4 || ends != 1 {
+		t.Errorf("after a failed sync: %d starts, %d ends, want 4 and 1", starts, ends)
+	}
+}
@@ -0,0 +1,156 @@
+package connector
+
+import (
+	"fmt"
+
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type options struct {
+	dryRun                bool
+	guardrails            Guardrails
+	scopes                ScopeFilter
+	disabledResourceTypes []string
+	requestsPerSecond     int
+	scopeFailures         ScopeFailures
+	http                  HTTPOptions
+	apiVersion            string
+	logHashPII            bool
+	meterProvider         metric.MeterProvider
+	tracerProvider        trace.TracerProvider
+	strictDecode          bool
+	schemaDriftReport     string
+	grantWorkers          int
+	incremental           IncrementalSync
+	activity              Activity
+}
+
+// Option configures optional behavior of the connector.
+type Option func(*options)
+
+// WithDryRun makes provisioning validate its inputs and report the planned SentinelOne API calls without sending them.
+func WithDryRun(dryRun bool) Option {
+	return func(o *options) {
+		o.dryRun = dryRun
+	}
+}
+
+// WithGuardrails replaces the default guardrails applied to provisioning requests.
+func WithGuardrails(guardrails Guardrails) Option {
+	return func(o *options) {
+		o.guardrails = guardrails
+	}
+}
+
+// WithScopeFilter limits the synced accounts and sites and the principals and grants that belong to them.
+func WithScopeFilter(scopes ScopeFilter) Option {
+	return func(o *options) {
+		o.scopes = scopes
+	}
+}
+
+// WithDisabledResourceTypes turns off the syncers of the given resource type ids, e.g. "service_user".
+func WithDisabledResourceTypes(resourceTypeIDs ...string) Option {
+	return func(o *options) {
+		o.disabledResourceTypes = append(o.disabledResourceTypes, resourceTypeIDs...)
+	}
+}
+
+// WithRateLimit limits the requests sent to every management console per second, 0 means no limit.
+func WithRateLimit(requestsPerSecond int) Option {
+	return func(o *options) {
+		o.requestsPerSecond = requestsPerSecond
+	}
+}
+
+// WithScopeFailures replaces the default handling of accounts and sites whose grants fail to list.
+func WithScopeFailures(scopeFailures ScopeFailures) Option {
+	return func(o *options) {
+		o.scopeFailures = scopeFailures
+	}
+}
+
+// WithHTTPOptions sets the proxy and TLS settings used to reach the management consoles.
+func WithHTTPOptions(httpOptions HTTPOptions) Option {
+	return func(o *options) {
+		o.http = httpOptions
+	}
+}
+
+// WithAPIVersion sets the version of the management console API, e.g. "v2.1".
+func WithAPIVersion(version string) Option {
+	return func(o *options) {
+		o.apiVersion = version
+	}
+}
+
+// WithLogHashPII replaces emails and names in the logged requests by a hash.
+func WithLogHashPII(hashPII bool) Option {
+	return func(o *options) {
+		o.logHashPII = hashPII
+	}
+}
+
+// WithMeterProvider records the requests sent to the management consoles with the given provider instead of the global one.
+func WithMeterProvider(provider metric.MeterProvider) Option {
+	return func(o *options) {
+		o.meterProvider = provider
+	}
+}
+
+// WithTracerProvider traces the syncers and the requests sent to the management consoles with the given provider instead of the global one.
+func WithTracerProvider(provider trace.TracerProvider) Option {
+	return func(o *options) {
+		o.tracerProvider = provider
+	}
+}
+
+// WithStrictDecode compares every response with the models of the connector and warns about unknown, missing and mistyped fields.
+func WithStrictDecode(strict bool) Option {
+	return func(o *options) {
+		o.strictDecode = strict
+	}
+}
+
+// WithSchemaDriftReport writes the schema drift found in strict decode mode to the given file.
+func WithSchemaDriftReport(path string) Option {
+	return func(o *options) {
+		o.schemaDriftReport = path
+	}
+}
+
+// WithGrantWorkers fetches the grants of the accounts and sites the sync reaches next with up to this many concurrent requests,
+// 0 fetches them one at a time when the sync asks for them.
+func WithGrantWorkers(workers int) Option {
+	return func(o *options) {
+		o.grantWorkers = workers
+	}
+}
+
+// WithIncrementalSync lists only the accounts, sites, users and service users changed since the last successful sync,
+// merged with the snapshot it left in the state file.
+func WithIncrementalSync(incremental IncrementalSync) Option {
+	return func(o *options) {
+		o.incremental = incremental
+	}
+}
+
+// WithActivity reads the activities log of the lookback, for the last login and api activity in the profile of the users
+// and service users.
+func WithActivity(activity Activity) Option {
+	return func(o *options) {
+		o.activity = activity
+	}
+}
+
+// ValidateResourceTypeIDs returns an error if any of the ids is not a resource type synced by the connector.
+func ValidateResourceTypeIDs(resourceTypeIDs []string) error {
+	for _, id := range resourceTypeIDs {
+		if _, ok := allResourceTypes[id]; !ok {
+			return fmt.Errorf("unknown resource type %q", id)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,328 @@
+package connector
+
+import (
+	"context"
+	"sync"
+
+	"github.com/conductorone/baton-sentinel-one/pkg/sentinelone"
+)
+
+// prefetchPagesPerWorker bounds the pages held by the prefetcher, fetched or in flight, per worker.
+const prefetchPagesPerWorker = 4
+
+// grantPrefetcher fetches the grant pages of the accounts and sites the sync is about to reach while it handles the current one.
+// The SDK asks for the grants of one scope at a time, in an order it doesn't expose: the scopes are recorded in the order they
+// are listed, and the direction the sync walks them is learnt from the scopes it asks for. The pages are fetched by a bounded
+// number of workers, every request still waits for the rate limit of its client.
+type grantPrefetcher struct {
+	// base is the context the connector was built with, it outlives the syncs.
+	base    context.Context
+	types   resourceTypeSet
+	workers chan struct{}
+
+	mtx sync.Mutex
+	// ctx is cancelled when the next sync starts, the grants requests the pages are fetched for are cancelled as soon as they return.
+	ctx      context.Context
+	cancel   context.CancelFunc
+	scopes   []prefetchScope
+	index    map[string]int
+	visited  map[int]struct{}
+	last     int
+	backward bool
+	pages    map[prefetchKey]*prefetchedPage
+	// order is the pages in the order they were started, the oldest fetched page is dropped when the prefetcher is full.
+	order []prefetchKey
+}
+
+type prefetchScope struct {
+	c     *console
+	scope string
+	id    string
+}
+
+// prefetchKey is a page of a fan-out of the grants of a scope, the fan-out is the resource type listed.
+type prefetchKey struct {
+	console        string
+	scope          string
+	id             string
+	resourceTypeID string
+	page           string
+}
+
+type prefetchedPage struct {
+	done  chan struct{}
+	items interface{}
+	next  string
+	err   error
+}
+
+// newGrantPrefetcher returns nil when there are no workers, the grants are then fetched when the sync asks for them.
+func newGrantPrefetcher(ctx context.Context, workers int, types resourceTypeSet) *grantPrefetcher {
+	if workers <= 0 {
+		return nil
+	}
+
+	p := &grantPrefetcher{
+		base:    ctx,
+		types:   types,
+		workers: make(chan struct{}, workers),
+	}
+	p.clear()
+
+	return p
+}
+
+// reset is called when a sync starts, the connector may run several syncs. The pages left by the last sync are dropped,
+// they may be stale by now, and the ones still in flight are cancelled.
+func (p *grantPrefetcher) reset(_ context.Context) error {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	p.cancel()
+	p.clear()
+
+	return nil
+}
+
+func (p *grantPrefetcher) clear() {
+	p.ctx, p.cancel = context.WithCancel(p.base)
+	p.scopes = nil
+	p.index = make(map[string]int)
+	p.visited = make(map[int]struct{})
+	p.last = -1
+	p.backward = false
+	p.pages = make(map[prefetchKey]*prefetchedPage)
+	p.order = nil
+}
+
+// register records a listed account or site, whose grants will be asked for later.
+func (p *grantPrefetcher) register(c *console, scope, id string) {
+	if p == nil {
+		return
+	}
+
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	key := scopeKey(c, scope, id)
+	if _, ok := p.index[key]; ok {
+		return
+	}
+
+	p.index[key] = len(p.scopes)
+	p.scopes = append(p.scopes, prefetchScope{c: c, scope: scope, id: id})
+}
+
+// visit is called when the sync starts on the grants of a scope, it prefetches the first pages of the scopes that come next.
+func (p *grantPrefetcher) visit(ctx context.Context, c *console, scope, id string) {
+	if p == nil {
+		return
+	}
+
+	p.mtx.Lock()
+	i, ok := p.index[scopeKey(c, scope, id)]
+	if !ok {
+		p.mtx.Unlock()
+		return
+	}
+
+	p.visited[i] = struct{}{}
+	if p.last >= 0 && i != p.last {
+		p.backward = i < p.last
+	}
+	p.last = i
+
+	step := 1
+	if p.backward {
+		step = -1
+	}
+
+	var next []prefetchScope
+	for j := i + step; j >= 0 && j < len(p.scopes) && len(next) < cap(p.workers); j += step {
+		if _, ok := p.visited[j]; !ok {
+			next = append(next, p.scopes[j])
+		}
+	}
+	p.mtx.Unlock()
+
+	for _, s := range next {
+		p.prefetchScope(ctx, s)
+	}
+}
+
+func (p *grantPrefetcher) prefetchScope(ctx context.Context, s prefetchScope) {
+	if s.c.failures.isSkipped(s.c, s.scope, s.id) {
+		return
+	}
+
+	rt, err := s.c.routes.routeFor(ctx, s.scope, s.id)
+	if err != nil {
+		return
+	}
+
+	resourceTypes := []string{resourceTypeUser.Id, resourceTypeServiceUser.Id}
+	if s.scope == scopeAccount {
+		resourceTypes = append(resourceTypes, resourceTypeSite.Id)
+	}
+
+	for _, resourceTypeID := range resourceTypes {
+		if !p.types.enabled(allResourceTypes[resourceTypeID]) || rt.forbidden(ctx, resourceTypeID, nil) ||
+			s.c.snapshots.hasMembers(s.c, s.scope, s.id, resourceTypeID) {
+			continue
+		}
+
+		p.start(ctx, rt, prefetchKey{
+			console:        s.c.name,
+			scope:          s.scope,
+			id:             s.id,
+			resourceTypeID: resourceTypeID,
+		})
+	}
+}
+
+// start fetches the page in the background, unless it is already fetched or the prefetcher is full.
+// The page is fetched with the values of ctx, like its logger and span, but only cancelled when the next sync starts.
+func (p *grantPrefetcher) start(ctx context.Context, rt *route, key prefetchKey) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	if _, ok := p.pages[key]; ok {
+		return
+	}
+
+	if len(p.pages) >= cap(p.workers)*prefetchPagesPerWorker && !p.evict() {
+		return
+	}
+
+	fetchCtx := prefetchContext{Context: p.ctx, values: ctx}
+	page := &prefetchedPage{done: make(chan struct{})}
+	p.pages[key] = page
+	p.order = append(p.order, key)
+
+	go func() {
+		defer close(page.done)
+
+		p.workers <- struct{}{}
+		defer func() { <-p.workers }()
+
+		page.items, page.next, page.err = fetchGrantPage(fetchCtx, rt, key)
+	}()
+}
+
+// prefetchContext is cancelled with the context of the prefetcher, and has the values of the request that started the prefetch.
+type prefetchContext struct {
+	context.Context
+	values context.Context
+}
+
+func (c prefetchContext) Value(key interface{}) interface{} {
+	return c.values.Value(key)
+}
+
+// evict drops the oldest fetched page, the sync went another way than expected and may never ask for it.
+func (p *grantPrefetcher) evict() bool {
+	for i, key := range p.order {
+		select {
+		case <-p.pages[key].done:
+			delete(p.pages, key)
+			p.order = append(p.order[:i], p.order[i+1:]...)
+			return true
+		default:
+		}
+	}
+
+	return false
+}
+
+// take removes the page from the prefetcher, nil if it wasn't prefetched.
+func (p *grantPrefetcher) take(key prefetchKey) *prefetchedPage {
+	if p == nil {
+		return nil
+	}
+
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	page, ok := p.pages[key]
+	if !ok {
+		return nil
+	}
+
+	delete(p.pages, key)
+	for i, k := range p.order {
+		if k == key {
+			p.order = append(p.order[:i], p.order[i+1:]...)
+			break
+		}
+	}
+
+	return page
+}
+
+// grantPage returns a page of the users, service users or sites of an account or site, prefetched when possible.
+// The following page is prefetched right away, it's fetched while the sync stores this one.
+// With incremental syncs, the grants of the accounts and sites whose members didn't change are listed from the snapshot.
+func grantPage[T any](ctx context.Context, c *console, rt *route, scope, id string, kind listKind[T], page string) ([]T, string, error) {
+	if page == "" {
+		if items, ok := cachedMembers(c, kind, scope, id); ok {
+			return items, "", nil
+		}
+	}
+
+	key := prefetchKey{
+		console:        c.name,
+		scope:          scope,
+		id:             id,
+		resourceTypeID: kind.resourceTypeID,
+		page:           page,
+	}
+
+	var (
+		items interface{}
+		next  string
+		err   error
+	)
+	if prefetched := c.prefetch.take(key); prefetched != nil {
+		select {
+		case <-ctx.Done():
+			return nil, "", ctx.Err()
+		case <-prefetched.done:
+		}
+		items, next, err = prefetched.items, prefetched.next, prefetched.err
+	} else {
+		items, next, err = fetchGrantPage(ctx, rt, key)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	if next != "" && c.prefetch != nil {
+		key.page = next
+		c.prefetch.start(ctx, rt, key)
+	}
+
+	rv, _ := items.([]T)
+	recordMembers(c, kind, scope, id, page, rv, next)
+
+	return rv, next, nil
+}
+
+func fetchGrantPage(ctx context.Context, rt *route, key prefetchKey) (interface{}, string, error) {
+	params := sentinelone.ParamsMap{
+		cursor: key.page,
+	}
+	if key.scope == scopeAccount {
+		params[accountsFilter] = key.id
+	} else {
+		params[sitesFilter] = key.id
+	}
+
+	switch key.resourceTypeID {
+	case resourceTypeUser.Id:
+		return rt.client.GetUsers(ctx, params)
+	case resourceTypeServiceUser.Id:
+		return rt.client.GetServiceUsers(ctx, params)
+	default:
+		return rt.client.GetSites(ctx, params)
+	}
+}
@@ -0,0 +1,236 @@
+package connector
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/conductorone/baton-sentinel-one/pkg/sentinelone"
+)
+
+// blockingConsole answers the users listings once it's released, and records how many requests it held at once.
+type blockingConsole struct {
+	srv     *httptest.Server
+	release chan struct{}
+	once    sync.Once
+
+	mtx      sync.Mutex
+	inFlight int
+	peak     int
+	served   int
+}
+
+func newBlockingConsole(t *testing.T) *blockingConsole {
+	b := &blockingConsole{release: make(chan struct{})}
+	b.srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b.mtx.Lock()
+		b.inFlight++
+		if b.inFlight > b.peak {
+			b.peak = b.inFlight
+		}
+		b.mtx.Unlock()
+
+		<-b.release
+
+		b.mtx.Lock()
+		b.inFlight--
+		b.served++
+		b.mtx.Unlock()
+
+		writeJSON(w, sentinelone.Response[sentinelone.User]{})
+	}))
+	t.Cleanup(b.srv.Close)
+	// a failed test may not have released the requests the server holds.
+	t.Cleanup(b.open)
+
+	return b
+}
+
+func (b *blockingConsole) open() {
+	b.once.Do(func() { close(b.release) })
+}
+
+func (b *blockingConsole) route() *route {
+	return &route{client: sentinelone.NewClient(b.srv.Client(), b.srv.URL+fakeAPIPath, "token")}
+}
+
+func (b *blockingConsole) stats() (int, int, int) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	return b.inFlight, b.peak, b.served
+}
+
+// waitFor polls the condition until it holds, the prefetched pages are fetched in the background.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func userPageKey(id string) prefetchKey {
+	return prefetchKey{scope: scopeAccount, id: id, resourceTypeID: resourceTypeUser.Id}
+}
+
+func TestPrefetchWorkers(t *testing.T) {
+	const workers = 2
+
+	b := newBlockingConsole(t)
+	p := newGrantPrefetcher(context.Background(), workers, nil)
+	rt := b.route()
+
+	for i := 0; i < 6; i++ {
+		p.start(context.Background(), rt, userPageKey(fmt.Sprintf("a%d", i)))
+	}
+
+	waitFor(t, "the workers to fetch", func() bool {
+		inFlight, _, _ := b.stats()
+		return inFlight == workers
+	})
+	// the other pages wait for a worker, give them the chance to go past the bound.
+	time.Sleep(20 * time.Millisecond)
+	if _, peak, _ := b.stats(); peak != workers {
+		t.Fatalf("%d requests in flight, want %d", peak, workers)
+	}
+
+	b.open()
+	waitFor(t, "every page", func() bool {
+		_, _, served := b.stats()
+		return served == 6
+	})
+	if _, peak, _ := b.stats(); peak != workers {
+		t.Errorf("%d requests in flight, want at most %d", peak, workers)
+	}
+}
+
+func TestPrefetchEviction(t *testing.T) {
+	b := newBlockingConsole(t)
+	// a single worker holds prefetchPagesPerWorker pages.
+	p := newGrantPrefetcher(context.Background(), 1, nil)
+	rt := b.route()
+
+	for i := 0; i < prefetchPagesPerWorker; i++ {
+		p.start(context.Background(), rt, userPageKey(fmt.Sprintf("a%d", i)))
+	}
+
+	// no page is fetched yet, none can make room for another.
+	p.start(context.Background(), rt, userPageKey("in-flight"))
+	if page := p.take(userPageKey("in-flight")); page != nil {
+		t.Fatal("page started while the prefetcher is full of pages in flight")
+	}
+
+	b.open()
+	for i := 0; i < prefetchPagesPerWorker; i++ {
+		p.mtx.Lock()
+		page := p.pages[userPageKey(fmt.Sprintf("a%d", i))]
+		p.mtx.Unlock()
+		<-page.done
+	}
+
+	p.start(context.Background(), rt, userPageKey("next"))
+	if page := p.take(userPageKey("a0")); page != nil {
+		t.Error("the oldest fetched page wasn't dropped")
+	}
+	for _, id := range []string{"a1", "a2", "a3", "next"} {
+		if page := p.take(userPageKey(id)); page == nil {
+			t.Errorf("page of %s dropped", id)
+		}
+	}
+}
+
+type prefetchValueKey struct{}
+
+func TestPrefetchContext(t *testing.T) {
+	// the prefetcher is built with the context of the connector, which outlives the syncs.
+	syncCtx, cancelSync := context.WithCancel(context.Background())
+	defer cancelSync()
+
+	// the grants request has returned, its context is cancelled before the page is fetched.
+	requestCtx, cancelRequest := context.WithCancel(context.WithValue(context.Background(), prefetchValueKey{}, "request"))
+	cancelRequest()
+
+	f := newFakeConsole(t)
+	f.users = []sentinelone.User{{ID: "u1", Scope: scopeAccount, ScopeRoles: []sentinelone.Role{{ID: "a1"}}}}
+	rt := &route{client: sentinelone.NewClient(f.srv.Client(), f.srv.URL+fakeAPIPath, "token")}
+
+	p := newGrantPrefetcher(syncCtx, 1, nil)
+	p.start(requestCtx, rt, userPageKey("a1"))
+
+	page := p.take(userPageKey("a1"))
+	<-page.done
+	if page.err != nil {
+		t.Fatalf("prefetch cancelled with the request: %v", page.err)
+	}
+	if users, _ := page.items.([]sentinelone.User); len(users) != 1 {
+		t.Errorf("prefetched %d users, want 1", len(users))
+	}
+
+	ctx := prefetchContext{Context: syncCtx, values: requestCtx}
+	if got := ctx.Value(prefetchValueKey{}); got != "request" {
+		t.Errorf("Value() = %v, want the value of the request", got)
+	}
+	if ctx.Err() != nil {
+		t.Errorf("Err() = %v before the sync is cancelled", ctx.Err())
+	}
+
+	cancelSync()
+	select {
+	case <-ctx.Done():
+	default:
+		t.Error("not cancelled with the context of the prefetcher")
+	}
+}
+
+// TestPrefetchSyncs runs two syncs back to back with the same connector, like in daemon mode. The first one stops after
+// the grants of the first account, the page it prefetched for the second one is stale once the next sync starts.
+func TestPrefetchSyncs(t *testing.T) {
+	f := newFakeConsole(t)
+	f.accounts = []sentinelone.Account{{ID: "a1", Name: "Acme"}, {ID: "a2", Name: "Globex"}}
+	f.users = []sentinelone.User{accountUser("u1", "a2")}
+	s := f.newConnector(t, WithGrantWorkers(1))
+
+	accounts := syncer(t, s, resourceTypeAccount)
+	listed := listResources(t, accounts, nil)
+	if _, _, err := listGrants(accounts, listed[0]); err != nil {
+		t.Fatalf("Grants() error = %v", err)
+	}
+	waitFor(t, "the users of the next account to be prefetched", func() bool {
+		for _, r := range f.sent(http.MethodGet, "users") {
+			if r.Query.Get(accountsFilter) == "a2" {
+				return true
+			}
+		}
+		return false
+	})
+
+	f.mtx.Lock()
+	f.users = append(f.users, accountUser("u2", "a2"))
+	f.mtx.Unlock()
+
+	listed = listResources(t, accounts, nil)
+	grants, _, err := listGrants(accounts, listed[1])
+	if err != nil {
+		t.Fatalf("Grants() error = %v", err)
+	}
+
+	var members []string
+	for _, g := range grants {
+		if g.Principal.Id.ResourceType == resourceTypeUser.Id {
+			members = append(members, g.Principal.Id.Resource)
+		}
+	}
+	sort.Strings(members)
+	if want := []string{"u1", "u2"}; !reflect.DeepEqual(members, want) {
+		t.Errorf("second sync granted %v, want %v", members, want)
+	}
+}
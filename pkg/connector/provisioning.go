@@ -0,0 +1,150 @@
+package connector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/conductorone/baton-sdk/pkg/annotations"
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/conductorone/baton-sentinel-one/pkg/sentinelone"
+)
+
+const (
+	idsFilter = "ids"
+	limit     = "limit"
+
+	revokeAPITokenOperation   = "revoke_api_token"
+	enableTwoFactorOperation  = "enable_2fa"
+	disableTwoFactorOperation = "disable_2fa"
+	resetTwoFactorOperation   = "reset_2fa"
+)
+
+// principal is a user or service user targeted by a provisioning operation.
+type principal struct {
+	resourceTypeID string
+	id             string
+	scope          string
+	scopeRoles     []sentinelone.Role
+}
+
+// resolveUsers looks up all ids with a live read and returns an error if any of them is not an existing console user.
+// It returns the client of the first api token that can read all of them.
+func resolveUsers(ctx context.Context, routes *routes, ids []string) ([]principal, *sentinelone.Client, error) {
+	return resolvePrincipals(ctx, routes, resourceTypeUser.Id, ids, func(client *sentinelone.Client) ([]principal, error) {
+		users, _, err := client.GetUsers(ctx, sentinelone.ParamsMap{
+			idsFilter: strings.Join(ids, ","),
+			limit:     strconv.Itoa(len(ids)),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to look up users: %w", err)
+		}
+
+		rv := make([]principal, 0, len(users))
+		for _, user := range users {
+			rv = append(rv, principal{
+				resourceTypeID: resourceTypeUser.Id,
+				id:             user.ID,
+				scope:          user.Scope,
+				scopeRoles:     user.ScopeRoles,
+			})
+		}
+
+		return rv, nil
+	})
+}
+
+func resolvePrincipals(
+	ctx context.Context,
+	routes *routes,
+	resourceTypeID string,
+	ids []string,
+	lookup func(client *sentinelone.Client) ([]principal, error),
+) ([]principal, *sentinelone.Client, error) {
+	var notFound error
+	for _, rt := range routes.all {
+		found, err := lookup(rt.client)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		notFound = ensureAllFound(resourceTypeID, ids, found)
+		if notFound == nil {
+			return found, rt.client, nil
+		}
+	}
+
+	return nil, nil, notFound
+}
+
+func ensureAllFound(resourceTypeID string, ids []string, found []principal) error {
+	known := make(map[string]struct{}, len(found))
+	for _, p := range found {
+		known[p.id] = struct{}{}
+	}
+
+	for _, id := range ids {
+		if _, ok := known[id]; !ok {
+			return fmt.Errorf("%s %s not found", resourceTypeID, id)
+		}
+	}
+
+	return nil
+}
+
+// provisioningResult logs the outcome of a write call and describes it in annotations, so it can be audited by the caller.
+// In dry-run mode the planned request is described instead and no error is returned.
+func provisioningResult(ctx context.Context, operation, resourceTypeID string, ids []string, affected int, err error) (annotations.Annotations, error) {
+	l := ctxzap.Extract(ctx).With(
+		zap.String("operation", operation),
+		zap.String("resource_type", resourceTypeID),
+		zap.Strings("resource_ids", ids),
+	)
+
+	resourceIDs := make([]interface{}, 0, len(ids))
+	for _, id := range ids {
+		resourceIDs = append(resourceIDs, id)
+	}
+
+	details := map[string]interface{}{
+		"operation":     operation,
+		"resource_type": resourceTypeID,
+		"resource_ids":  resourceIDs,
+	}
+
+	var dryRun *sentinelone.DryRunError
+	switch {
+	case errors.As(err, &dryRun):
+		planned := dryRun.Request
+		l.Info("dry run: request not sent",
+			zap.String("method", planned.Method),
+			zap.String("path", planned.Path),
+			zap.ByteString("body", planned.Body),
+		)
+		details["dry_run"] = true
+		details["method"] = planned.Method
+		details["path"] = planned.Path
+		details["body"] = string(planned.Body)
+
+	case err != nil:
+		return nil, err
+
+	default:
+		l.Info("provisioning operation finished", zap.Int("affected", affected))
+		details["affected"] = affected
+	}
+
+	msg, err := structpb.NewStruct(details)
+	if err != nil {
+		return nil, err
+	}
+
+	annos := annotations.Annotations{}
+	annos.Append(msg)
+	return annos, nil
+}
@@ -0,0 +1,284 @@
+package connector
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/conductorone/baton-sentinel-one/pkg/sentinelone"
+)
+
+// DefaultAdminScopes is the number of scopes a principal may hold the Admin role at before the role report lists it.
+const DefaultAdminScopes = 3
+
+// RoleReport lists the roles and role assignments of every console worth a review.
+type RoleReport struct {
+	// AdminScopes is the number of scopes above which Admins are listed.
+	AdminScopes int                 `json:"admin_scopes"`
+	Consoles    []ConsoleRoleReport `json:"consoles"`
+}
+
+// ConsoleRoleReport is the role report of one management console.
+type ConsoleRoleReport struct {
+	Name string `json:"name,omitempty"`
+	URL  string `json:"url"`
+	// UnusedCustomRoles are the custom roles no user or service user holds.
+	UnusedCustomRoles []ReportedRole `json:"unused_custom_roles"`
+	// TenantAssignments are the roles held at tenant scope, over every account and site.
+	TenantAssignments []RoleAssignment `json:"tenant_assignments"`
+	// BroadAdmins are the principals holding the Admin role at more scopes than the report allows.
+	BroadAdmins []AdminPrincipal `json:"broad_admins"`
+	// AdminServiceUsers are the Admin role assignments of service users.
+	AdminServiceUsers []RoleAssignment `json:"admin_service_users"`
+}
+
+// ReportedRole is a role of the role report.
+type ReportedRole struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// RoleAssignment is a role held by a user or service user at a tenant, account or site.
+type RoleAssignment struct {
+	PrincipalType string `json:"principal_type"`
+	PrincipalID   string `json:"principal_id"`
+	PrincipalName string `json:"principal_name"`
+	RoleID        string `json:"role_id"`
+	RoleName      string `json:"role_name"`
+	Scope         string `json:"scope"`
+	ScopeID       string `json:"scope_id"`
+	ScopeName     string `json:"scope_name"`
+}
+
+// AdminPrincipal is a user or service user and the scopes it holds the Admin role at.
+type AdminPrincipal struct {
+	PrincipalType string           `json:"principal_type"`
+	PrincipalID   string           `json:"principal_id"`
+	PrincipalName string           `json:"principal_name"`
+	Assignments   []RoleAssignment `json:"assignments"`
+}
+
+// reportedPrincipal is a user or service user and its roles.
+type reportedPrincipal struct {
+	resourceTypeID string
+	id             string
+	name           string
+	scope          string
+	scopeRoles     []sentinelone.Role
+}
+
+func (p reportedPrincipal) assignment(scopeRole sentinelone.Role) RoleAssignment {
+	return RoleAssignment{
+		PrincipalType: p.resourceTypeID,
+		PrincipalID:   p.id,
+		PrincipalName: p.name,
+		RoleID:        scopeRole.RoleID,
+		RoleName:      scopeRole.RoleName,
+		Scope:         p.scope,
+		ScopeID:       scopeRole.ID,
+		ScopeName:     scopeRole.Name,
+	}
+}
+
+// ReportRoles lists the custom roles nobody holds, the roles held at tenant scope, the principals holding the Admin role
+// at more than adminScopes scopes and the service users holding the Admin role.
+// It reads the roles, users and service users like a sync does, within the scope filters.
+func (s *SentinelOne) ReportRoles(ctx context.Context, adminScopes int) (*RoleReport, error) {
+	if adminScopes < 0 {
+		return nil, fmt.Errorf("admin scopes must not be negative")
+	}
+
+	rv := &RoleReport{AdminScopes: adminScopes}
+	for _, c := range s.consoles.all {
+		cr, err := reportConsoleRoles(ctx, c, adminScopes)
+		if err != nil {
+			return nil, err
+		}
+
+		rv.Consoles = append(rv.Consoles, *cr)
+	}
+
+	return rv, nil
+}
+
+func reportConsoleRoles(ctx context.Context, c *console, adminScopes int) (*ConsoleRoleReport, error) {
+	roles, adminRoleID, err := reportCustomRoles(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+
+	users, err := reportPrincipals(ctx, c, userList, userRoles, c.scopes.filterUsers, func(user sentinelone.User) string {
+		return user.FullName
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	serviceUsers, err := reportPrincipals(ctx, c, serviceUserList, serviceUserRoles, c.scopes.filterServiceUsers, func(serviceUser sentinelone.ServiceUser) string {
+		return serviceUser.Name
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	principals := append(users, serviceUsers...)
+	sort.Slice(principals, func(i, j int) bool {
+		if principals[i].name != principals[j].name {
+			return principals[i].name < principals[j].name
+		}
+		return principals[i].id < principals[j].id
+	})
+
+	rv := &ConsoleRoleReport{
+		Name:              c.name,
+		URL:               c.url,
+		UnusedCustomRoles: []ReportedRole{},
+		TenantAssignments: []RoleAssignment{},
+		BroadAdmins:       []AdminPrincipal{},
+		AdminServiceUsers: []RoleAssignment{},
+	}
+
+	assigned := make(map[string]struct{})
+	for _, p := range principals {
+		var admin []RoleAssignment
+		for _, scopeRole := range p.scopeRoles {
+			assigned[scopeRole.RoleID] = struct{}{}
+
+			if p.scope == scopeTenant {
+				rv.TenantAssignments = append(rv.TenantAssignments, p.assignment(scopeRole))
+			}
+
+			if isAdminRole(scopeRole, adminRoleID) {
+				admin = append(admin, p.assignment(scopeRole))
+			}
+		}
+
+		if len(admin) > adminScopes {
+			rv.BroadAdmins = append(rv.BroadAdmins, AdminPrincipal{
+				PrincipalType: p.resourceTypeID,
+				PrincipalID:   p.id,
+				PrincipalName: p.name,
+				Assignments:   admin,
+			})
+		}
+
+		if p.resourceTypeID == resourceTypeServiceUser.Id {
+			rv.AdminServiceUsers = append(rv.AdminServiceUsers, admin...)
+		}
+	}
+
+	for _, role := range roles {
+		if _, ok := assigned[role.ID]; !ok {
+			rv.UnusedCustomRoles = append(rv.UnusedCustomRoles, ReportedRole{ID: role.ID, Name: role.Name})
+		}
+	}
+
+	return rv, nil
+}
+
+// reportCustomRoles returns the custom roles listed with any api token of the console, sorted by name, and the id of
+// the predefined Admin role. The roles listing has the predefined roles and the custom roles of the scopes of the token.
+// A token that can't list the roles is skipped like in a sync, the Admin role id is empty when none could.
+func reportCustomRoles(ctx context.Context, c *console) ([]sentinelone.Role, string, error) {
+	var adminRoleID string
+	found := make(map[string]sentinelone.Role)
+	for _, rt := range c.routes.all {
+		if rt.forbidden(ctx, resourceTypeRole.Id, nil) {
+			continue
+		}
+
+		page := ""
+		for {
+			roles, nextCursor, err := rt.client.GetPredefinedRoles(ctx, sentinelone.ParamsMap{
+				cursor: page,
+			})
+			if err != nil {
+				if rt.forbidden(ctx, resourceTypeRole.Id, err) {
+					break
+				}
+				return nil, "", fmt.Errorf("failed to list roles: %w", err)
+			}
+
+			for _, role := range roles {
+				switch {
+				case !role.PredefinedRole:
+					found[role.ID] = role
+				case role.Name == adminRoleName:
+					adminRoleID = role.ID
+				}
+			}
+
+			if nextCursor == "" {
+				break
+			}
+			page = nextCursor
+		}
+	}
+
+	rv := make([]sentinelone.Role, 0, len(found))
+	for _, role := range found {
+		rv = append(rv, role)
+	}
+	sort.Slice(rv, func(i, j int) bool {
+		if rv[i].Name != rv[j].Name {
+			return rv[i].Name < rv[j].Name
+		}
+		return rv[i].ID < rv[j].ID
+	})
+
+	return rv, adminRoleID, nil
+}
+
+// reportPrincipals returns the users or service users of the console, each listed with the token that owns it.
+func reportPrincipals[T any](
+	ctx context.Context,
+	c *console,
+	kind listKind[T],
+	roles func(T) (string, []sentinelone.Role),
+	filter func(context.Context, []T) ([]T, error),
+	name func(T) string,
+) ([]reportedPrincipal, error) {
+	var rv []reportedPrincipal
+	for index, rt := range c.routes.all {
+		if rt.forbidden(ctx, kind.resourceTypeID, nil) {
+			continue
+		}
+
+		page := ""
+		for {
+			// the principals are streamed, only the reported fields are kept.
+			nextCursor, err := kind.each(rt.client)(ctx, sentinelone.ParamsMap{
+				cursor: page,
+			}, func(p T) error {
+				keep, err := keepPrincipal(ctx, c, index, p, roles, filter)
+				if err != nil || !keep {
+					return err
+				}
+
+				scope, scopeRoles := roles(p)
+				rv = append(rv, reportedPrincipal{
+					resourceTypeID: kind.resourceTypeID,
+					id:             kind.id(p),
+					name:           name(p),
+					scope:          scope,
+					scopeRoles:     scopeRoles,
+				})
+
+				return nil
+			})
+			if err != nil {
+				if rt.forbidden(ctx, kind.resourceTypeID, err) {
+					break
+				}
+				return nil, fmt.Errorf("failed to list %s: %w", kind.resourceTypeID, err)
+			}
+
+			if nextCursor == "" {
+				break
+			}
+			page = nextCursor
+		}
+	}
+
+	return rv, nil
+}
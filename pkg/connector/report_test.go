@@ -0,0 +1,122 @@
+package connector
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/conductorone/baton-sentinel-one/pkg/sentinelone"
+)
+
+// adminAt returns the scope roles of a role held at every account.
+func adminAt(roleID, roleName string, accountIDs ...string) []sentinelone.Role {
+	var rv []sentinelone.Role
+	for _, id := range accountIDs {
+		rv = append(rv, sentinelone.Role{ID: id, Name: "Account " + id, RoleID: roleID, RoleName: roleName})
+	}
+
+	return rv
+}
+
+func reportPrincipalIDs(admins []AdminPrincipal) []string {
+	var rv []string
+	for _, a := range admins {
+		rv = append(rv, a.PrincipalID)
+	}
+
+	return rv
+}
+
+func assignmentKeys(assignments []RoleAssignment) []string {
+	var rv []string
+	for _, a := range assignments {
+		rv = append(rv, a.PrincipalID+"/"+a.RoleID+"/"+a.ScopeID)
+	}
+
+	return rv
+}
+
+func TestReportRoles(t *testing.T) {
+	tests := []struct {
+		name string
+		// rolesStatus answers the roles listing with this status instead, 0 lists the roles.
+		rolesStatus           int
+		wantUnused            []ReportedRole
+		wantBroadAdmins       []string
+		wantAdminServiceUsers []string
+	}{
+		{
+			name:       "admins matched by the id of the predefined role",
+			wantUnused: []ReportedRole{{ID: "auditor", Name: "Auditor"}},
+			// the custom role named Admin isn't the Admin role.
+			wantBroadAdmins:       []string{"u-admin"},
+			wantAdminServiceUsers: []string{"s-admin/admin/a1"},
+		},
+		{
+			name:        "admins matched by name when the roles can't be listed",
+			rolesStatus: http.StatusForbidden,
+			wantUnused:  nil,
+			// a custom role named Admin can't be told apart.
+			wantBroadAdmins: []string{"u-admin", "u-custom"},
+			// the principals are sorted by name.
+			wantAdminServiceUsers: []string{"s-custom/custom-admin/a2", "s-admin/admin/a1"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeConsole(t)
+			f.roles = []sentinelone.Role{
+				{ID: "admin", Name: adminRoleName, PredefinedRole: true},
+				{ID: "viewer", Name: "Viewer", PredefinedRole: true},
+				{ID: "custom-admin", Name: adminRoleName},
+				{ID: "helpdesk", Name: "Helpdesk"},
+				{ID: "auditor", Name: "Auditor"},
+			}
+			f.users = []sentinelone.User{
+				{ID: "u-admin", FullName: "Alice", Scope: scopeAccount, ScopeRoles: adminAt("admin", adminRoleName, "a1", "a2", "a3", "a4")},
+				{ID: "u-custom", FullName: "Bob", Scope: scopeAccount, ScopeRoles: adminAt("custom-admin", adminRoleName, "a1", "a2", "a3", "a4")},
+				{ID: "u-few", FullName: "Carol", Scope: scopeAccount, ScopeRoles: adminAt("admin", adminRoleName, "a1", "a2")},
+				{ID: "u-tenant", FullName: "Dave", Scope: scopeTenant, ScopeRoles: []sentinelone.Role{{RoleID: "viewer", RoleName: "Viewer"}}},
+			}
+			f.serviceUsers = []sentinelone.ServiceUser{
+				{ID: "s-admin", Name: "ci", Scope: scopeAccount, ScopeRoles: adminAt("admin", adminRoleName, "a1")},
+				{ID: "s-custom", Name: "cd", Scope: scopeAccount, ScopeRoles: adminAt("custom-admin", adminRoleName, "a2")},
+				{ID: "s-helpdesk", Name: "tickets", Scope: scopeAccount, ScopeRoles: adminAt("helpdesk", "Helpdesk", "a1")},
+			}
+			if tt.rolesStatus != 0 {
+				f.setStatus("rbac/roles", tt.rolesStatus)
+			}
+			s := f.newConnector(t)
+
+			report, err := s.ReportRoles(context.Background(), DefaultAdminScopes)
+			if err != nil {
+				t.Fatalf("ReportRoles() error = %v", err)
+			}
+			if len(report.Consoles) != 1 {
+				t.Fatalf("report has %d consoles, want 1", len(report.Consoles))
+			}
+			cr := report.Consoles[0]
+
+			if !reflect.DeepEqual(cr.UnusedCustomRoles, append([]ReportedRole{}, tt.wantUnused...)) {
+				t.Errorf("unused custom roles = %+v, want %+v", cr.UnusedCustomRoles, tt.wantUnused)
+			}
+			if got, want := assignmentKeys(cr.TenantAssignments), []string{"u-tenant/viewer/"}; !reflect.DeepEqual(got, want) {
+				t.Errorf("tenant assignments = %v, want %v", got, want)
+			}
+			if got := reportPrincipalIDs(cr.BroadAdmins); !reflect.DeepEqual(got, tt.wantBroadAdmins) {
+				t.Errorf("broad admins = %v, want %v", got, tt.wantBroadAdmins)
+			}
+			if got := assignmentKeys(cr.AdminServiceUsers); !reflect.DeepEqual(got, tt.wantAdminServiceUsers) {
+				t.Errorf("admin service users = %v, want %v", got, tt.wantAdminServiceUsers)
+			}
+		})
+	}
+}
+
+func TestReportRolesAdminScopes(t *testing.T) {
+	if _, err := (&SentinelOne{}).ReportRoles(context.Background(), -1); err == nil {
+		t.Error("expected an error for negative admin scopes")
+	}
+}
@@ -15,7 +15,8 @@ import (
 
 type roleResourceType struct {
 	resourceType *v2.ResourceType
-	client       *sentinelone.Client
+	consoles     *consoleSet
+	types        resourceTypeSet
 }
 
 const (
@@ -35,7 +36,7 @@ func (r *roleResourceType) ResourceType(_ context.Context) *v2.ResourceType {
 }
 
 // Create a new connector resource for an SentinelOne Role.
-func roleResource(ctx context.Context, role *sentinelone.Role) (*v2.Resource, error) {
+func roleResource(ctx context.Context, c *console, role *sentinelone.Role) (*v2.Resource, error) {
 	var name string
 	var id string
 
@@ -60,8 +61,9 @@ func roleResource(ctx context.Context, role *sentinelone.Role) (*v2.Resource, er
 	resource, err := rs.NewRoleResource(
 		name,
 		resourceTypeRole,
-		id,
+		c.id(id),
 		roleTraitOptions,
+		rs.WithParentResourceID(c.root),
 	)
 
 	if err != nil {
@@ -72,6 +74,11 @@ func roleResource(ctx context.Context, role *sentinelone.Role) (*v2.Resource, er
 }
 
 func (r *roleResourceType) List(ctx context.Context, parentId *v2.ResourceId, pToken *pagination.Token) ([]*v2.Resource, string, annotations.Annotations, error) {
+	c, ok, err := r.consoles.listedUnder(parentId, r.types, false)
+	if err != nil || !ok {
+		return nil, "", nil, err
+	}
+
 	bag, page, err := parsePageToken(pToken.Token, &v2.ResourceId{ResourceType: resourceTypeRole.Id})
 	if err != nil {
 		return nil, "", nil, err
@@ -80,10 +87,20 @@ func (r *roleResourceType) List(ctx context.Context, parentId *v2.ResourceId, pT
 	var allRoles []sentinelone.Role
 	switch bag.ResourceTypeID() {
 	case resourceTypeRole.Id:
-		predefinedRoles, nextCursor, err := r.client.GetPredefinedRoles(ctx, sentinelone.ParamsMap{
+		// the roles listing has the predefined roles and the custom roles of the scopes of the first token, the custom roles
+		// of the other tokens are discovered from the users and service users, like all of them when it can't be read.
+		rt := c.routes.all[0]
+		if rt.forbidden(ctx, resourceTypeRole.Id, nil) {
+			return r.skipPredefinedRoles(bag, rt)
+		}
+
+		predefinedRoles, nextCursor, err := rt.client.GetPredefinedRoles(ctx, sentinelone.ParamsMap{
 			cursor: page,
 		})
 		if err != nil {
+			if rt.forbidden(ctx, resourceTypeRole.Id, err) {
+				return r.skipPredefinedRoles(bag, rt)
+			}
 			return nil, "", nil, fmt.Errorf("failed to list predefined roles: %w", err)
 		}
 
@@ -95,22 +112,37 @@ func (r *roleResourceType) List(ctx context.Context, parentId *v2.ResourceId, pT
 		allRoles = append(allRoles, predefinedRoles...)
 		if nextCursor == "" {
 			bag.Pop()
-			bag.Push(pagination.PageState{
-				ResourceTypeID: resourceTypeUser.Id,
-			})
-
-			bag.Push(pagination.PageState{
-				ResourceTypeID: resourceTypeServiceUser.Id,
-			})
+			r.types.pushEnabled(bag, resourceTypeUser, resourceTypeServiceUser)
 		}
 
 	case resourceTypeUser.Id:
-		// we have to fetch all users and service users to get the custom roles, as they are not returned by the API
+		index, ready, err := c.routes.fanOut(bag)
+		if err != nil {
+			return nil, "", nil, err
+		}
+		if !ready {
+			pageToken, err := bag.Marshal()
+			return nil, pageToken, nil, err
+		}
+
+		// we have to fetch all users and service users to get the custom roles held at the scopes of every token,
 		// this is very costly now but will be fixed in the future with cache
-		users, nextCursor, err := r.client.GetUsers(ctx, sentinelone.ParamsMap{
-			cursor: page,
+		rt := c.routes.all[index]
+		if rt.forbidden(ctx, resourceTypeUser.Id, nil) {
+			pageToken, annos, err := rt.skipPage(bag, resourceTypeUser.Id)
+			return nil, pageToken, annos, err
+		}
+
+		// the users are streamed, only their roles are kept.
+		nextCursor, err := eachListed(ctx, c, index, userList, page, func(user sentinelone.User) error {
+			allRoles = appendRoles(allRoles, user.ScopeRoles)
+			return nil
 		})
 		if err != nil {
+			if rt.forbidden(ctx, resourceTypeUser.Id, err) {
+				pageToken, annos, err := rt.skipPage(bag, resourceTypeUser.Id)
+				return nil, pageToken, annos, err
+			}
 			return nil, "", nil, fmt.Errorf("failed to get users for custom roles: %w", err)
 		}
 
@@ -119,18 +151,31 @@ func (r *roleResourceType) List(ctx context.Context, parentId *v2.ResourceId, pT
 			return nil, "", nil, paginationErr
 		}
 
-		for _, user := range users {
-			if user.ScopeRoles != nil {
-				allRoles = append(allRoles, user.ScopeRoles...)
-			}
+	case resourceTypeServiceUser.Id:
+		index, ready, err := c.routes.fanOut(bag)
+		if err != nil {
+			return nil, "", nil, err
+		}
+		if !ready {
+			pageToken, err := bag.Marshal()
+			return nil, pageToken, nil, err
 		}
 
-	case resourceTypeServiceUser.Id:
-		serviceUsers, nextCursor, err := r.client.GetServiceUsers(ctx, sentinelone.ParamsMap{
-			cursor: page,
-		})
+		rt := c.routes.all[index]
+		if rt.forbidden(ctx, resourceTypeServiceUser.Id, nil) {
+			pageToken, annos, err := rt.skipPage(bag, resourceTypeServiceUser.Id)
+			return nil, pageToken, annos, err
+		}
 
+		nextCursor, err := eachListed(ctx, c, index, serviceUserList, page, func(serviceUser sentinelone.ServiceUser) error {
+			allRoles = appendRoles(allRoles, serviceUser.ScopeRoles)
+			return nil
+		})
 		if err != nil {
+			if rt.forbidden(ctx, resourceTypeServiceUser.Id, err) {
+				pageToken, annos, err := rt.skipPage(bag, resourceTypeServiceUser.Id)
+				return nil, pageToken, annos, err
+			}
 			return nil, "", nil, fmt.Errorf("failed to get service users for custom roles: %w", err)
 		}
 
@@ -139,12 +184,6 @@ func (r *roleResourceType) List(ctx context.Context, parentId *v2.ResourceId, pT
 			return nil, "", nil, paginationErr
 		}
 
-		for _, serviceUser := range serviceUsers {
-			if serviceUser.ScopeRoles != nil {
-				allRoles = append(allRoles, serviceUser.ScopeRoles...)
-			}
-		}
-
 	default:
 		return nil, "", nil, fmt.Errorf("unexpected resource type while fetching roles")
 	}
@@ -152,7 +191,7 @@ func (r *roleResourceType) List(ctx context.Context, parentId *v2.ResourceId, pT
 	var rv []*v2.Resource
 	for _, role := range allRoles {
 		roleCopy := role
-		rr, err := roleResource(ctx, &roleCopy)
+		rr, err := roleResource(ctx, c, &roleCopy)
 		if err != nil {
 			return nil, "", nil, err
 		}
@@ -168,12 +207,51 @@ func (r *roleResourceType) List(ctx context.Context, parentId *v2.ResourceId, pT
 	return rv, pageToken, nil, nil
 }
 
+// appendRoles adds the roles not in the list yet, most users of a page share a handful of roles.
+func appendRoles(roles []sentinelone.Role, more []sentinelone.Role) []sentinelone.Role {
+	for _, role := range more {
+		known := false
+		for _, r := range roles {
+			if r == role {
+				known = true
+				break
+			}
+		}
+
+		if !known {
+			roles = append(roles, role)
+		}
+	}
+
+	return roles
+}
+
+// skipPredefinedRoles moves on to discovering the custom roles when the token can't list the predefined roles.
+func (r *roleResourceType) skipPredefinedRoles(bag *pagination.Bag, rt *route) ([]*v2.Resource, string, annotations.Annotations, error) {
+	bag.Pop()
+	r.types.pushEnabled(bag, resourceTypeUser, resourceTypeServiceUser)
+
+	pageToken, err := bag.Marshal()
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	annos, err := skippedAnnotations(map[string]string{
+		resourceTypeRole.Id: rt.deniedResourceTypes()[resourceTypeRole.Id],
+	})
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	return nil, pageToken, annos, nil
+}
+
 func (r *roleResourceType) Entitlements(ctx context.Context, resource *v2.Resource, _ *pagination.Token) ([]*v2.Entitlement, string, annotations.Annotations, error) {
 	var rv []*v2.Entitlement
 	var assignmentEntitlement *v2.Entitlement
 	for _, membership := range memberships {
 		assignmentOptions := []ent.EntitlementOption{
-			ent.WithGrantableTo(resourceTypeUser, resourceTypeServiceUser),
+			ent.WithGrantableTo(r.types.only(resourceTypeUser, resourceTypeServiceUser)...),
 			ent.WithDisplayName(fmt.Sprintf("%s Role with %s", resource.DisplayName, membership)),
 			ent.WithDescription(fmt.Sprintf("%s role in SentinelOne", resource.DisplayName)),
 		}
@@ -194,23 +272,42 @@ func (r *roleResourceType) Grants(ctx context.Context, resource *v2.Resource, pT
 		return nil, "", nil, err
 	}
 
+	c, roleID, err := r.consoles.resolve(resource.Id.Resource)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
 	var rv []*v2.Grant
 	switch bag.ResourceTypeID() {
 	case resourceTypeRole.Id:
 		bag.Pop()
-		bag.Push(pagination.PageState{
-			ResourceTypeID: resourceTypeUser.Id,
-		})
-		bag.Push(pagination.PageState{
-			ResourceTypeID: resourceTypeServiceUser.Id,
-		})
+		r.types.pushEnabled(bag, resourceTypeUser, resourceTypeServiceUser)
 
 	case resourceTypeUser.Id:
-		roleUsers, nextCursor, err := r.client.GetUsers(ctx, sentinelone.ParamsMap{
-			rolesFilter: resource.Id.Resource,
+		index, ready, err := c.routes.fanOut(bag)
+		if err != nil {
+			return nil, "", nil, err
+		}
+		if !ready {
+			pageToken, err := bag.Marshal()
+			return nil, pageToken, nil, err
+		}
+
+		rt := c.routes.all[index]
+		if rt.forbidden(ctx, resourceTypeUser.Id, nil) {
+			pageToken, annos, err := rt.skipPage(bag, resourceTypeUser.Id)
+			return nil, pageToken, annos, err
+		}
+
+		roleUsers, nextCursor, err := rt.client.GetUsers(ctx, sentinelone.ParamsMap{
+			rolesFilter: roleID,
 			cursor:      page,
 		})
 		if err != nil {
+			if rt.forbidden(ctx, resourceTypeUser.Id, err) {
+				pageToken, annos, err := rt.skipPage(bag, resourceTypeUser.Id)
+				return nil, pageToken, annos, err
+			}
 			return nil, "", nil, fmt.Errorf("failed to list users for role %s: %w", resource.Id.Resource, err)
 		}
 
@@ -219,9 +316,19 @@ func (r *roleResourceType) Grants(ctx context.Context, resource *v2.Resource, pT
 			return nil, "", nil, paginationErr
 		}
 
+		roleUsers, err = ownedPrincipals(ctx, c.routes, index, roleUsers, userRoles)
+		if err != nil {
+			return nil, "", nil, err
+		}
+
+		roleUsers, err = c.scopes.filterUsers(ctx, roleUsers)
+		if err != nil {
+			return nil, "", nil, err
+		}
+
 		for _, roleUser := range roleUsers {
 			roleUserCopy := roleUser
-			ur, err := userResource(&roleUserCopy, resource.Id)
+			ur, err := userResource(c, &roleUserCopy, resource.Id)
 			if err != nil {
 				return nil, "", nil, fmt.Errorf("error creating user resource for role %s: %w", resource.Id.Resource, err)
 			}
@@ -229,11 +336,30 @@ func (r *roleResourceType) Grants(ctx context.Context, resource *v2.Resource, pT
 		}
 
 	case resourceTypeServiceUser.Id:
-		roleServiceUsers, nextCursor, err := r.client.GetServiceUsers(ctx, sentinelone.ParamsMap{
-			rolesFilter: resource.Id.Resource,
+		index, ready, err := c.routes.fanOut(bag)
+		if err != nil {
+			return nil, "", nil, err
+		}
+		if !ready {
+			pageToken, err := bag.Marshal()
+			return nil, pageToken, nil, err
+		}
+
+		rt := c.routes.all[index]
+		if rt.forbidden(ctx, resourceTypeServiceUser.Id, nil) {
+			pageToken, annos, err := rt.skipPage(bag, resourceTypeServiceUser.Id)
+			return nil, pageToken, annos, err
+		}
+
+		roleServiceUsers, nextCursor, err := rt.client.GetServiceUsers(ctx, sentinelone.ParamsMap{
+			rolesFilter: roleID,
 			cursor:      page,
 		})
 		if err != nil {
+			if rt.forbidden(ctx, resourceTypeServiceUser.Id, err) {
+				pageToken, annos, err := rt.skipPage(bag, resourceTypeServiceUser.Id)
+				return nil, pageToken, annos, err
+			}
 			return nil, "", nil, fmt.Errorf("failed to list service users for role %s: %w", resource.Id.Resource, err)
 		}
 
@@ -242,9 +368,19 @@ func (r *roleResourceType) Grants(ctx context.Context, resource *v2.Resource, pT
 			return nil, "", nil, paginationErr
 		}
 
+		roleServiceUsers, err = ownedPrincipals(ctx, c.routes, index, roleServiceUsers, serviceUserRoles)
+		if err != nil {
+			return nil, "", nil, err
+		}
+
+		roleServiceUsers, err = c.scopes.filterServiceUsers(ctx, roleServiceUsers)
+		if err != nil {
+			return nil, "", nil, err
+		}
+
 		for _, roleServiceUser := range roleServiceUsers {
 			roleServiceUserCopy := roleServiceUser
-			sur, err := serviceUserResource(&roleServiceUserCopy, resource.Id)
+			sur, err := serviceUserResource(c, &roleServiceUserCopy, resource.Id)
 			if err != nil {
 				return nil, "", nil, fmt.Errorf("error creating service user resource for role %s: %w", resource.Id.Resource, err)
 			}
@@ -263,9 +399,10 @@ func (r *roleResourceType) Grants(ctx context.Context, resource *v2.Resource, pT
 	return rv, pageToken, nil, nil
 }
 
-func roleBuilder(client *sentinelone.Client) *roleResourceType {
+func roleBuilder(consoles *consoleSet, types resourceTypeSet) *roleResourceType {
 	return &roleResourceType{
 		resourceType: resourceTypeRole,
-		client:       client,
+		consoles:     consoles,
+		types:        types,
 	}
 }
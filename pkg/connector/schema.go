@@ -0,0 +1,130 @@
+package connector
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+	"go.uber.org/zap"
+
+	"github.com/conductorone/baton-sentinel-one/pkg/sentinelone"
+)
+
+// SchemaDrift is a difference between the responses of a console and the models of the connector.
+type SchemaDrift struct {
+	Console string `json:"console,omitempty"`
+	sentinelone.SchemaDrift
+}
+
+// LoadSchemaDrift reads the schema drift found by the last sync from a report, nil if there is no report.
+func LoadSchemaDrift(path string) ([]SchemaDrift, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var rv []SchemaDrift
+	if err := json.Unmarshal(data, &rv); err != nil {
+		return nil, fmt.Errorf("failed to read schema drift report %s: %w", path, err)
+	}
+
+	return rv, nil
+}
+
+// schemaDrift collects the drift found by the clients of every console, warning once per console, endpoint and field.
+type schemaDrift struct {
+	reportPath string
+
+	mtx   sync.Mutex
+	found []SchemaDrift
+	seen  map[SchemaDrift]struct{}
+}
+
+func newSchemaDrift(reportPath string) *schemaDrift {
+	return &schemaDrift{
+		reportPath: reportPath,
+		seen:       make(map[SchemaDrift]struct{}),
+	}
+}
+
+// reset forgets the drift found by the last sync when another one starts, each sync reports what it found.
+func (d *schemaDrift) reset(_ context.Context) error {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	d.found = nil
+	d.seen = make(map[SchemaDrift]struct{})
+
+	// a report left by an earlier sync would be mistaken for the drift of this one.
+	if d.reportPath != "" {
+		if err := os.Remove(d.reportPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to remove schema drift report: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// report writes the drift found by the sync once it ends, there is no report when none was.
+// A sync that fails before its end writes no report, its drift is still in the warnings of the log.
+func (d *schemaDrift) report(ctx context.Context) error {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	if d.reportPath == "" || len(d.found) == 0 {
+		return nil
+	}
+
+	if err := writeReport(d.reportPath, d.found); err != nil {
+		ctxzap.Extract(ctx).Warn("failed to write schema drift report", zap.String("path", d.reportPath), zap.Error(err))
+	}
+
+	return nil
+}
+
+// forConsole returns the recorder of the clients of a console.
+func (d *schemaDrift) forConsole(name string) sentinelone.SchemaRecorder {
+	return &consoleSchemaDrift{
+		drift:   d,
+		console: name,
+	}
+}
+
+func (d *schemaDrift) record(ctx context.Context, drift SchemaDrift) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	if _, ok := d.seen[drift]; ok {
+		return
+	}
+	d.seen[drift] = struct{}{}
+	d.found = append(d.found, drift)
+
+	ctxzap.Extract(ctx).Warn("response differs from the expected schema",
+		zap.String("console", drift.Console),
+		zap.String("endpoint", drift.Endpoint),
+		zap.String("kind", drift.Kind),
+		zap.String("path", drift.Path),
+		zap.String("expected", drift.Expected),
+		zap.String("actual", drift.Actual),
+	)
+}
+
+type consoleSchemaDrift struct {
+	drift   *schemaDrift
+	console string
+}
+
+func (c *consoleSchemaDrift) RecordSchemaDrift(ctx context.Context, drift sentinelone.SchemaDrift) {
+	c.drift.record(ctx, SchemaDrift{
+		Console:     c.console,
+		SchemaDrift: drift,
+	})
+}
@@ -0,0 +1,77 @@
+package connector
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/conductorone/baton-sentinel-one/pkg/sentinelone"
+)
+
+// TestSchemaDriftSyncs runs the hooks of two syncs: the report is written when a sync ends, and only has its own drift.
+func TestSchemaDriftSyncs(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "drift.json")
+	if err := os.WriteFile(path, []byte("[]"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	d := newSchemaDrift(path)
+	recorder := d.forConsole("eu")
+	unknown := sentinelone.SchemaDrift{Endpoint: "users", Kind: sentinelone.DriftUnknownField, Path: "data[].tenant"}
+	missing := sentinelone.SchemaDrift{Endpoint: "sites", Kind: sentinelone.DriftMissingField, Path: "data.sites[].name"}
+
+	if err := d.reset(ctx); err != nil {
+		t.Fatalf("reset() error = %v", err)
+	}
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("report of an earlier sync left when the sync started: %v", err)
+	}
+
+	recorder.RecordSchemaDrift(ctx, unknown)
+	recorder.RecordSchemaDrift(ctx, unknown)
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("report written before the sync ended: %v", err)
+	}
+	if err := d.report(ctx); err != nil {
+		t.Fatalf("report() error = %v", err)
+	}
+
+	got, err := LoadSchemaDrift(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []SchemaDrift{{Console: "eu", SchemaDrift: unknown}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("first sync reported %+v, want %+v", got, want)
+	}
+
+	if err := d.reset(ctx); err != nil {
+		t.Fatalf("reset() error = %v", err)
+	}
+	recorder.RecordSchemaDrift(ctx, missing)
+	if err := d.report(ctx); err != nil {
+		t.Fatalf("report() error = %v", err)
+	}
+
+	got, err = LoadSchemaDrift(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []SchemaDrift{{Console: "eu", SchemaDrift: missing}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("second sync reported %+v, want %+v", got, want)
+	}
+
+	// a sync without drift leaves no report.
+	if err := d.reset(ctx); err != nil {
+		t.Fatalf("reset() error = %v", err)
+	}
+	if err := d.report(ctx); err != nil {
+		t.Fatalf("report() error = %v", err)
+	}
+	if got, err := LoadSchemaDrift(path); err != nil || got != nil {
+		t.Errorf("LoadSchemaDrift() = %+v, %v after a sync without drift, want no report", got, err)
+	}
+}
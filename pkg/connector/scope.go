@@ -0,0 +1,250 @@
+package connector
+
+import (
+	"context"
+	"fmt"
+	"path"
+
+	"github.com/conductorone/baton-sentinel-one/pkg/sentinelone"
+)
+
+// ScopeFilter limits the synced accounts and sites, and the principals and grants that belong to them.
+// Name patterns use path.Match syntax, e.g. "Acme*". Excludes win over includes.
+type ScopeFilter struct {
+	IncludeAccountIDs   []string
+	ExcludeAccountIDs   []string
+	IncludeSiteIDs      []string
+	ExcludeSiteIDs      []string
+	IncludeAccountNames []string
+	ExcludeAccountNames []string
+	IncludeSiteNames    []string
+	ExcludeSiteNames    []string
+}
+
+// Validate returns an error if the filter contains invalid patterns or includes and excludes the same value.
+func (f ScopeFilter) Validate() error {
+	for _, patterns := range [][]string{f.IncludeAccountNames, f.ExcludeAccountNames, f.IncludeSiteNames, f.ExcludeSiteNames} {
+		for _, pattern := range patterns {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("invalid name pattern %q: %w", pattern, err)
+			}
+		}
+	}
+
+	checks := []struct {
+		kind    string
+		include []string
+		exclude []string
+	}{
+		{"account id", f.IncludeAccountIDs, f.ExcludeAccountIDs},
+		{"site id", f.IncludeSiteIDs, f.ExcludeSiteIDs},
+		{"account name pattern", f.IncludeAccountNames, f.ExcludeAccountNames},
+		{"site name pattern", f.IncludeSiteNames, f.ExcludeSiteNames},
+	}
+	for _, check := range checks {
+		excluded := toSet(check.exclude)
+		for _, v := range check.include {
+			if _, ok := excluded[v]; ok {
+				return fmt.Errorf("%s %s is both included and excluded", check.kind, v)
+			}
+		}
+	}
+
+	return nil
+}
+
+type scopeFilter struct {
+	routes *routes
+
+	includeAccountIDs   map[string]struct{}
+	excludeAccountIDs   map[string]struct{}
+	includeSiteIDs      map[string]struct{}
+	excludeSiteIDs      map[string]struct{}
+	includeAccountNames []string
+	excludeAccountNames []string
+	includeSiteNames    []string
+	excludeSiteNames    []string
+}
+
+func newScopeFilter(routes *routes, cfg ScopeFilter) *scopeFilter {
+	return &scopeFilter{
+		routes:              routes,
+		includeAccountIDs:   toSet(cfg.IncludeAccountIDs),
+		excludeAccountIDs:   toSet(cfg.ExcludeAccountIDs),
+		includeSiteIDs:      toSet(cfg.IncludeSiteIDs),
+		excludeSiteIDs:      toSet(cfg.ExcludeSiteIDs),
+		includeAccountNames: cfg.IncludeAccountNames,
+		excludeAccountNames: cfg.ExcludeAccountNames,
+		includeSiteNames:    cfg.IncludeSiteNames,
+		excludeSiteNames:    cfg.ExcludeSiteNames,
+	}
+}
+
+func (f *scopeFilter) enabled() bool {
+	return f.accountRulesEnabled() || f.siteRulesEnabled()
+}
+
+func (f *scopeFilter) accountRulesEnabled() bool {
+	return len(f.includeAccountIDs) > 0 || len(f.excludeAccountIDs) > 0 || len(f.includeAccountNames) > 0 || len(f.excludeAccountNames) > 0
+}
+
+func (f *scopeFilter) siteRulesEnabled() bool {
+	return len(f.includeSiteIDs) > 0 || len(f.excludeSiteIDs) > 0 || len(f.includeSiteNames) > 0 || len(f.excludeSiteNames) > 0
+}
+
+// accountAllowed reports whether the account passes the account rules.
+func (f *scopeFilter) accountAllowed(id, name string) bool {
+	return allowed(id, name, f.includeAccountIDs, f.excludeAccountIDs, f.includeAccountNames, f.excludeAccountNames)
+}
+
+// siteAllowed reports whether the site passes the site rules and its account passes the account rules.
+func (f *scopeFilter) siteAllowed(site *sentinelone.Site) bool {
+	if !allowed(site.ID, site.Name, f.includeSiteIDs, f.excludeSiteIDs, f.includeSiteNames, f.excludeSiteNames) {
+		return false
+	}
+
+	return f.accountAllowed(site.AccountID, site.AccountName)
+}
+
+// principalAllowed reports whether a user or service user has a role in at least one allowed scope.
+// Tenant scope principals have access to every account and are always kept.
+func (f *scopeFilter) principalAllowed(ctx context.Context, scope string, scopeRoles []sentinelone.Role) (bool, error) {
+	if !f.enabled() || scope == scopeTenant {
+		return true, nil
+	}
+
+	for _, scopeRole := range scopeRoles {
+		switch scope {
+		case scopeAccount:
+			if f.accountAllowed(scopeRole.ID, scopeRole.Name) {
+				return true, nil
+			}
+
+		case scopeSite:
+			site := &sentinelone.Site{
+				ID:          scopeRole.ID,
+				Name:        scopeRole.Name,
+				AccountName: scopeRole.AccountName,
+			}
+			if f.accountRulesEnabled() {
+				accountID, err := f.routes.siteAccountID(ctx, scopeRole.ID)
+				if err != nil {
+					return false, err
+				}
+				site.AccountID = accountID
+			}
+
+			if f.siteAllowed(site) {
+				return true, nil
+			}
+		}
+	}
+
+	// principals without any role are only kept when nothing is explicitly included.
+	return len(scopeRoles) == 0 && !f.includesEnabled(), nil
+}
+
+func (f *scopeFilter) includesEnabled() bool {
+	return len(f.includeAccountIDs) > 0 || len(f.includeAccountNames) > 0 || len(f.includeSiteIDs) > 0 || len(f.includeSiteNames) > 0
+}
+
+func (f *scopeFilter) filterAccounts(accounts []sentinelone.Account) []sentinelone.Account {
+	if !f.enabled() {
+		return accounts
+	}
+
+	rv := make([]sentinelone.Account, 0, len(accounts))
+	for _, account := range accounts {
+		if f.accountAllowed(account.ID, account.Name) {
+			rv = append(rv, account)
+		}
+	}
+
+	return rv
+}
+
+func (f *scopeFilter) filterSites(sites []sentinelone.Site) []sentinelone.Site {
+	if !f.enabled() {
+		return sites
+	}
+
+	rv := make([]sentinelone.Site, 0, len(sites))
+	for _, site := range sites {
+		siteCopy := site
+		if f.siteAllowed(&siteCopy) {
+			rv = append(rv, site)
+		}
+	}
+
+	return rv
+}
+
+func (f *scopeFilter) filterUsers(ctx context.Context, users []sentinelone.User) ([]sentinelone.User, error) {
+	if !f.enabled() {
+		return users, nil
+	}
+
+	rv := make([]sentinelone.User, 0, len(users))
+	for _, user := range users {
+		ok, err := f.principalAllowed(ctx, user.Scope, user.ScopeRoles)
+		if err != nil {
+			return nil, err
+		}
+
+		if ok {
+			rv = append(rv, user)
+		}
+	}
+
+	return rv, nil
+}
+
+func (f *scopeFilter) filterServiceUsers(ctx context.Context, serviceUsers []sentinelone.ServiceUser) ([]sentinelone.ServiceUser, error) {
+	if !f.enabled() {
+		return serviceUsers, nil
+	}
+
+	rv := make([]sentinelone.ServiceUser, 0, len(serviceUsers))
+	for _, serviceUser := range serviceUsers {
+		ok, err := f.principalAllowed(ctx, serviceUser.Scope, serviceUser.ScopeRoles)
+		if err != nil {
+			return nil, err
+		}
+
+		if ok {
+			rv = append(rv, serviceUser)
+		}
+	}
+
+	return rv, nil
+}
+
+func allowed(id, name string, includeIDs, excludeIDs map[string]struct{}, includeNames, excludeNames []string) bool {
+	if _, ok := excludeIDs[id]; ok {
+		return false
+	}
+
+	if matchesAny(name, excludeNames) {
+		return false
+	}
+
+	if len(includeIDs) == 0 && len(includeNames) == 0 {
+		return true
+	}
+
+	if _, ok := includeIDs[id]; ok {
+		return true
+	}
+
+	return matchesAny(name, includeNames)
+}
+
+func matchesAny(name string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+
+	return false
+}
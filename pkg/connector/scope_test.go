@@ -0,0 +1,138 @@
+package connector
+
+import (
+	"context"
+	"testing"
+
+	"github.com/conductorone/baton-sentinel-one/pkg/sentinelone"
+)
+
+func TestScopeFilterValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		filter  ScopeFilter
+		wantErr bool
+	}{
+		{name: "empty", filter: ScopeFilter{}},
+		{name: "includes and excludes", filter: ScopeFilter{
+			IncludeAccountIDs:   []string{"a1"},
+			ExcludeAccountIDs:   []string{"a2"},
+			IncludeSiteNames:    []string{"Prod*"},
+			ExcludeSiteNames:    []string{"Prod Test"},
+			ExcludeAccountNames: []string{"[AB]cme"},
+		}},
+		{name: "invalid include pattern", filter: ScopeFilter{IncludeAccountNames: []string{"Acme["}}, wantErr: true},
+		{name: "invalid exclude pattern", filter: ScopeFilter{ExcludeSiteNames: []string{"\\"}}, wantErr: true},
+		{name: "account id included and excluded", filter: ScopeFilter{IncludeAccountIDs: []string{"a1"}, ExcludeAccountIDs: []string{"a1"}}, wantErr: true},
+		{name: "site id included and excluded", filter: ScopeFilter{IncludeSiteIDs: []string{"s1"}, ExcludeSiteIDs: []string{"s1"}}, wantErr: true},
+		{name: "pattern included and excluded", filter: ScopeFilter{IncludeSiteNames: []string{"Prod*"}, ExcludeSiteNames: []string{"Prod*"}}, wantErr: true},
+		{name: "same id as account and site", filter: ScopeFilter{IncludeAccountIDs: []string{"1"}, ExcludeSiteIDs: []string{"1"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.filter.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestScopeFilterSites(t *testing.T) {
+	sites := []sentinelone.Site{
+		{ID: "s1", Name: "Prod EU", AccountID: "a1", AccountName: "Acme"},
+		{ID: "s2", Name: "Prod US", AccountID: "a1", AccountName: "Acme"},
+		{ID: "s3", Name: "Test", AccountID: "a1", AccountName: "Acme"},
+		{ID: "s4", Name: "Prod", AccountID: "a2", AccountName: "Globex"},
+	}
+
+	tests := []struct {
+		name   string
+		filter ScopeFilter
+		want   []string
+	}{
+		{name: "no filter", filter: ScopeFilter{}, want: []string{"s1", "s2", "s3", "s4"}},
+		{name: "site pattern", filter: ScopeFilter{IncludeSiteNames: []string{"Prod*"}}, want: []string{"s1", "s2", "s4"}},
+		{name: "exclude wins", filter: ScopeFilter{IncludeSiteNames: []string{"Prod*"}, ExcludeSiteIDs: []string{"s2"}}, want: []string{"s1", "s4"}},
+		{name: "include by id or name", filter: ScopeFilter{IncludeSiteIDs: []string{"s3"}, IncludeSiteNames: []string{"Prod EU"}}, want: []string{"s1", "s3"}},
+		{name: "account of the site excluded", filter: ScopeFilter{IncludeSiteNames: []string{"Prod*"}, ExcludeAccountNames: []string{"Glob*"}}, want: []string{"s1", "s2"}},
+		{name: "only the sites of an account", filter: ScopeFilter{IncludeAccountIDs: []string{"a2"}}, want: []string{"s4"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := newScopeFilter(nil, tt.filter).filterSites(sites)
+
+			var ids []string
+			for _, site := range got {
+				ids = append(ids, site.ID)
+			}
+			if !equalStrings(ids, tt.want) {
+				t.Errorf("filterSites() = %v, want %v", ids, tt.want)
+			}
+		})
+	}
+}
+
+func TestScopeFilterPrincipals(t *testing.T) {
+	users := []sentinelone.User{
+		{ID: "tenant", Scope: scopeTenant},
+		{ID: "acme", Scope: scopeAccount, ScopeRoles: []sentinelone.Role{{ID: "a1", Name: "Acme"}}},
+		{ID: "globex", Scope: scopeAccount, ScopeRoles: []sentinelone.Role{{ID: "a2", Name: "Globex"}}},
+		{ID: "both", Scope: scopeAccount, ScopeRoles: []sentinelone.Role{{ID: "a2", Name: "Globex"}, {ID: "a1", Name: "Acme"}}},
+		{ID: "acme-site", Scope: scopeSite, ScopeRoles: []sentinelone.Role{{ID: "s1", Name: "Prod", AccountName: "Acme"}}},
+		{ID: "globex-site", Scope: scopeSite, ScopeRoles: []sentinelone.Role{{ID: "s2", Name: "Prod", AccountName: "Globex"}}},
+		{ID: "no-role", Scope: scopeAccount},
+	}
+
+	tests := []struct {
+		name   string
+		filter ScopeFilter
+		want   []string
+	}{
+		{name: "no filter", filter: ScopeFilter{}, want: []string{"tenant", "acme", "globex", "both", "acme-site", "globex-site", "no-role"}},
+		{name: "include account", filter: ScopeFilter{IncludeAccountIDs: []string{"a1"}}, want: []string{"tenant", "acme", "both", "acme-site"}},
+		{name: "exclude account", filter: ScopeFilter{ExcludeAccountIDs: []string{"a1"}}, want: []string{"tenant", "globex", "both", "globex-site", "no-role"}},
+		{name: "include site", filter: ScopeFilter{IncludeSiteIDs: []string{"s2"}}, want: []string{"tenant", "acme", "globex", "both", "globex-site"}},
+		{name: "include site of an included account", filter: ScopeFilter{IncludeAccountIDs: []string{"a2"}, IncludeSiteIDs: []string{"s2"}}, want: []string{"tenant", "globex", "both", "globex-site"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeConsole(t)
+			f.sites = []sentinelone.Site{
+				{ID: "s1", Name: "Prod", AccountID: "a1", AccountName: "Acme"},
+				{ID: "s2", Name: "Prod", AccountID: "a2", AccountName: "Globex"},
+			}
+			s := f.newConnector(t)
+			c := s.consoles.all[0]
+
+			got, err := newScopeFilter(c.routes, tt.filter).filterUsers(context.Background(), users)
+			if err != nil {
+				t.Fatalf("filterUsers() error = %v", err)
+			}
+
+			var ids []string
+			for _, user := range got {
+				ids = append(ids, user.ID)
+			}
+			if !equalStrings(ids, tt.want) {
+				t.Errorf("filterUsers() = %v, want %v", ids, tt.want)
+			}
+		})
+	}
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
@@ -12,7 +12,8 @@ import (
 
 type serviceUserResourceType struct {
 	resourceType *v2.ResourceType
-	client       *sentinelone.Client
+	consoles     *consoleSet
+	types        resourceTypeSet
 }
 
 func (s *serviceUserResourceType) ResourceType(_ context.Context) *v2.ResourceType {
@@ -20,7 +21,7 @@ func (s *serviceUserResourceType) ResourceType(_ context.Context) *v2.ResourceTy
 }
 
 // Create a new connector resource for a SentinelOne service user.
-func serviceUserResource(serviceUser *sentinelone.ServiceUser, parentResourceID *v2.ResourceId) (*v2.Resource, error) {
+func serviceUserResource(c *console, serviceUser *sentinelone.ServiceUser, parentResourceID *v2.ResourceId) (*v2.Resource, error) {
 	firstName, lastName := splitFullName(serviceUser.Name)
 
 	profile := map[string]interface{}{
@@ -29,6 +30,8 @@ func serviceUserResource(serviceUser *sentinelone.ServiceUser, parentResourceID
 		"user_id":    serviceUser.ID,
 	}
 
+	c.activity.addToProfile(profile, serviceUser.ID)
+
 	userTraitOptions := []rs.UserTraitOption{
 		rs.WithUserProfile(profile),
 		rs.WithStatus(v2.UserTrait_Status_STATUS_UNSPECIFIED),
@@ -38,7 +41,7 @@ func serviceUserResource(serviceUser *sentinelone.ServiceUser, parentResourceID
 	ret, err := rs.NewUserResource(
 		serviceUser.Name,
 		resourceTypeServiceUser,
-		serviceUser.ID,
+		c.id(serviceUser.ID),
 		userTraitOptions,
 		rs.WithParentResourceID(parentResourceID),
 	)
@@ -50,8 +53,10 @@ func serviceUserResource(serviceUser *sentinelone.ServiceUser, parentResourceID
 }
 
 func (s *serviceUserResourceType) List(ctx context.Context, parentId *v2.ResourceId, pToken *pagination.Token) ([]*v2.Resource, string, annotations.Annotations, error) {
-	if parentId == nil {
-		return nil, "", nil, nil
+	// service users are listed under their accounts, unless accounts are not synced.
+	c, ok, err := s.consoles.listedUnder(parentId, s.types, true)
+	if err != nil || !ok {
+		return nil, "", nil, err
 	}
 
 	bag, page, err := parsePageToken(pToken.Token, &v2.ResourceId{ResourceType: resourceTypeServiceUser.Id})
@@ -59,26 +64,52 @@ func (s *serviceUserResourceType) List(ctx context.Context, parentId *v2.Resourc
 		return nil, "", nil, err
 	}
 
-	users, nextCursor, err := s.client.GetServiceUsers(ctx, sentinelone.ParamsMap{
-		cursor: page,
-	})
+	index, ready, err := c.routes.fanOut(bag)
 	if err != nil {
 		return nil, "", nil, err
 	}
+	if !ready {
+		pageToken, err := bag.Marshal()
+		return nil, pageToken, nil, err
+	}
 
-	pageToken, err := bag.NextToken(nextCursor)
-	if err != nil {
+	rt := c.routes.all[index]
+	if rt.forbidden(ctx, resourceTypeServiceUser.Id, nil) {
+		pageToken, annos, err := rt.skipPage(bag, resourceTypeServiceUser.Id)
+		return nil, pageToken, annos, err
+	}
+
+	if err := c.activity.load(ctx); err != nil {
 		return nil, "", nil, err
 	}
 
+	// the page is streamed, each serviceUser becomes a resource as it's decoded.
 	var rv []*v2.Resource
-	for _, serviceUser := range users {
-		serviceUserCopy := serviceUser
-		sur, err := serviceUserResource(&serviceUserCopy, parentId)
+	nextCursor, err := eachListed(ctx, c, index, serviceUserList, page, func(serviceUser sentinelone.ServiceUser) error {
+		keep, err := keepPrincipal(ctx, c, index, serviceUser, serviceUserRoles, c.scopes.filterServiceUsers)
+		if err != nil || !keep {
+			return err
+		}
+
+		r, err := serviceUserResource(c, &serviceUser, parentId)
 		if err != nil {
-			return nil, "", nil, err
+			return err
 		}
-		rv = append(rv, sur)
+		rv = append(rv, r)
+
+		return nil
+	})
+	if err != nil {
+		if rt.forbidden(ctx, resourceTypeServiceUser.Id, err) {
+			pageToken, annos, err := rt.skipPage(bag, resourceTypeServiceUser.Id)
+			return nil, pageToken, annos, err
+		}
+		return nil, "", nil, err
+	}
+
+	pageToken, err := bag.NextToken(nextCursor)
+	if err != nil {
+		return nil, "", nil, err
 	}
 
 	return rv, pageToken, nil, nil
@@ -92,9 +123,10 @@ func (s *serviceUserResourceType) Grants(_ context.Context, _ *v2.Resource, _ *p
 	return nil, "", nil, nil
 }
 
-func serviceUserBuilder(client *sentinelone.Client) *serviceUserResourceType {
+func serviceUserBuilder(consoles *consoleSet, types resourceTypeSet) *serviceUserResourceType {
 	return &serviceUserResourceType{
 		resourceType: resourceTypeServiceUser,
-		client:       client,
+		consoles:     consoles,
+		types:        types,
 	}
 }
@@ -15,7 +15,8 @@ import (
 
 type siteResourceType struct {
 	resourceType *v2.ResourceType
-	client       *sentinelone.Client
+	consoles     *consoleSet
+	types        resourceTypeSet
 }
 
 func (s *siteResourceType) ResourceType(_ context.Context) *v2.ResourceType {
@@ -25,11 +26,11 @@ func (s *siteResourceType) ResourceType(_ context.Context) *v2.ResourceType {
 const siteMembership = "member"
 
 // Create a new connector resource for an SentinelOne site.
-func siteResource(site *sentinelone.Site, parentResourceID *v2.ResourceId) (*v2.Resource, error) {
+func siteResource(c *console, site *sentinelone.Site, parentResourceID *v2.ResourceId) (*v2.Resource, error) {
 	resource, err := rs.NewResource(
 		site.Name,
 		resourceTypeSite,
-		site.ID,
+		c.id(site.ID),
 		rs.WithParentResourceID(parentResourceID),
 	)
 	if err != nil {
@@ -40,8 +41,10 @@ func siteResource(site *sentinelone.Site, parentResourceID *v2.ResourceId) (*v2.
 }
 
 func (s *siteResourceType) List(ctx context.Context, parentId *v2.ResourceId, pToken *pagination.Token) ([]*v2.Resource, string, annotations.Annotations, error) {
-	if parentId == nil {
-		return nil, "", nil, nil
+	// sites are listed under their accounts, unless accounts are not synced.
+	c, ok, err := s.consoles.listedUnder(parentId, s.types, true)
+	if err != nil || !ok {
+		return nil, "", nil, err
 	}
 
 	bag, page, err := parsePageToken(pToken.Token, &v2.ResourceId{ResourceType: resourceTypeSite.Id})
@@ -49,10 +52,27 @@ func (s *siteResourceType) List(ctx context.Context, parentId *v2.ResourceId, pT
 		return nil, "", nil, err
 	}
 
-	sites, nextCursor, err := s.client.GetSites(ctx, sentinelone.ParamsMap{
-		cursor: page,
-	})
+	index, ready, err := c.routes.fanOut(bag)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	if !ready {
+		pageToken, err := bag.Marshal()
+		return nil, pageToken, nil, err
+	}
+
+	rt := c.routes.all[index]
+	if rt.forbidden(ctx, resourceTypeSite.Id, nil) {
+		pageToken, annos, err := rt.skipPage(bag, resourceTypeSite.Id)
+		return nil, pageToken, annos, err
+	}
+
+	sites, nextCursor, err := listPage(ctx, c, index, siteList, page)
 	if err != nil {
+		if rt.forbidden(ctx, resourceTypeSite.Id, err) {
+			pageToken, annos, err := rt.skipPage(bag, resourceTypeSite.Id)
+			return nil, pageToken, annos, err
+		}
 		return nil, "", nil, fmt.Errorf("failed to list sites: %w", err)
 	}
 
@@ -62,15 +82,26 @@ func (s *siteResourceType) List(ctx context.Context, parentId *v2.ResourceId, pT
 	}
 
 	var rv []*v2.Resource
-	for _, site := range sites {
+	for _, site := range c.scopes.filterSites(sites) {
+		owner, err := c.routes.siteOwner(ctx, site.ID)
+		if err != nil {
+			return nil, "", nil, err
+		}
+
+		// sites readable with several api tokens are listed with the first of them.
+		if owner != index {
+			continue
+		}
+
 		siteCopy := site
-		sr, err := siteResource(&siteCopy, parentId)
+		sr, err := siteResource(c, &siteCopy, parentId)
 
 		if err != nil {
 			return nil, "", nil, err
 		}
 
 		rv = append(rv, sr)
+		c.prefetch.register(c, scopeSite, site.ID)
 	}
 
 	return rv, pageToken, nil, nil
@@ -80,7 +111,7 @@ func (s *siteResourceType) Entitlements(ctx context.Context, resource *v2.Resour
 	var rv []*v2.Entitlement
 
 	assignmentOptions := []ent.EntitlementOption{
-		ent.WithGrantableTo(resourceTypeUser, resourceTypeServiceUser),
+		ent.WithGrantableTo(s.types.only(resourceTypeUser, resourceTypeServiceUser)...),
 		ent.WithDisplayName(fmt.Sprintf("%s Site %s", resource.DisplayName, siteMembership)),
 		ent.WithDescription(fmt.Sprintf("Access to %s site in SentinelOne", resource.DisplayName)),
 	}
@@ -100,34 +131,58 @@ func (s *siteResourceType) Grants(ctx context.Context, resource *v2.Resource, pT
 		return nil, "", nil, err
 	}
 
+	c, siteID, err := s.consoles.resolve(resource.Id.Resource)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	rt, err := c.routes.routeFor(ctx, scopeSite, siteID)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
 	var rv []*v2.Grant
 	switch bag.ResourceTypeID() {
 	case resourceTypeSite.Id:
 		bag.Pop()
-		bag.Push(pagination.PageState{
-			ResourceTypeID: resourceTypeUser.Id,
-		})
-		bag.Push(pagination.PageState{
-			ResourceTypeID: resourceTypeServiceUser.Id,
-		})
+		s.types.pushEnabled(bag, resourceTypeUser, resourceTypeServiceUser)
+		c.prefetch.visit(ctx, c, scopeSite, siteID)
 
 	case resourceTypeUser.Id:
-		siteUsers, nextCursor, err := s.client.GetUsers(ctx, sentinelone.ParamsMap{
-			sitesFilter: resource.Id.Resource,
-			cursor:      page,
+		if rt.forbidden(ctx, resourceTypeUser.Id, nil) {
+			pageToken, annos, err := rt.skipPage(bag, resourceTypeUser.Id)
+			return nil, pageToken, annos, err
+		}
+
+		var (
+			siteUsers  []sentinelone.User
+			nextCursor string
+		)
+		skipped, err := c.failures.call(ctx, c, scopeSite, siteID, resource.DisplayName, func(ctx context.Context) error {
+			var err error
+			siteUsers, nextCursor, err = grantPage(ctx, c, rt, scopeSite, siteID, userList, page)
+			return err
 		})
 		if err != nil {
 			return nil, "", nil, fmt.Errorf("failed to list users for site %s: %w", resource.Id.Resource, err)
 		}
+		if skipped {
+			return skippedScope(c, bag, scopeSite, siteID)
+		}
 
 		paginationErr := bag.Next(nextCursor)
 		if paginationErr != nil {
 			return nil, "", nil, paginationErr
 		}
 
+		siteUsers, err = c.scopes.filterUsers(ctx, siteUsers)
+		if err != nil {
+			return nil, "", nil, err
+		}
+
 		for _, siteUser := range siteUsers {
 			siteUserCopy := siteUser
-			ur, err := userResource(&siteUserCopy, resource.Id)
+			ur, err := userResource(c, &siteUserCopy, resource.Id)
 			if err != nil {
 				return nil, "", nil, fmt.Errorf("error creating user resource for site %s: %w", resource.Id.Resource, err)
 			}
@@ -137,22 +192,40 @@ func (s *siteResourceType) Grants(ctx context.Context, resource *v2.Resource, pT
 		}
 
 	case resourceTypeServiceUser.Id:
-		siteServiceUsers, nextCursor, err := s.client.GetServiceUsers(ctx, sentinelone.ParamsMap{
-			sitesFilter: resource.Id.Resource,
-			cursor:      page,
+		if rt.forbidden(ctx, resourceTypeServiceUser.Id, nil) {
+			pageToken, annos, err := rt.skipPage(bag, resourceTypeServiceUser.Id)
+			return nil, pageToken, annos, err
+		}
+
+		var (
+			siteServiceUsers []sentinelone.ServiceUser
+			nextCursor       string
+		)
+		skipped, err := c.failures.call(ctx, c, scopeSite, siteID, resource.DisplayName, func(ctx context.Context) error {
+			var err error
+			siteServiceUsers, nextCursor, err = grantPage(ctx, c, rt, scopeSite, siteID, serviceUserList, page)
+			return err
 		})
 		if err != nil {
 			return nil, "", nil, fmt.Errorf("failed to list service users for site %s: %w", resource.Id.Resource, err)
 		}
+		if skipped {
+			return skippedScope(c, bag, scopeSite, siteID)
+		}
 
 		paginationErr := bag.Next(nextCursor)
 		if paginationErr != nil {
 			return nil, "", nil, paginationErr
 		}
 
+		siteServiceUsers, err = c.scopes.filterServiceUsers(ctx, siteServiceUsers)
+		if err != nil {
+			return nil, "", nil, err
+		}
+
 		for _, siteServiceUser := range siteServiceUsers {
 			siteServiceUserCopy := siteServiceUser
-			ur, err := serviceUserResource(&siteServiceUserCopy, resource.Id)
+			ur, err := serviceUserResource(c, &siteServiceUserCopy, resource.Id)
 			if err != nil {
 				return nil, "", nil, fmt.Errorf("error creating service user resource for site %s: %w", resource.Id.Resource, err)
 			}
@@ -173,9 +246,10 @@ func (s *siteResourceType) Grants(ctx context.Context, resource *v2.Resource, pT
 	return rv, pageToken, nil, nil
 }
 
-func siteBuilder(client *sentinelone.Client) *siteResourceType {
+func siteBuilder(consoles *consoleSet, types resourceTypeSet) *siteResourceType {
 	return &siteResourceType{
 		resourceType: resourceTypeSite,
-		client:       client,
+		consoles:     consoles,
+		types:        types,
 	}
 }
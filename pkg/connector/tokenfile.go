@@ -0,0 +1,121 @@
+package connector
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+	"go.uber.org/zap"
+
+	"github.com/conductorone/baton-sentinel-one/pkg/sentinelone"
+)
+
+// tokenFile is an api token read from a file, e.g. a mounted Kubernetes secret, and re-read when the file changes.
+type tokenFile struct {
+	path string
+
+	mtx     sync.Mutex
+	token   string
+	clients []*sentinelone.Client
+}
+
+func newTokenFile(path string) (*tokenFile, error) {
+	rv := &tokenFile{path: path}
+	if _, err := rv.reload(); err != nil {
+		return nil, err
+	}
+
+	return rv, nil
+}
+
+func readTokenFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read api token file: %w", err)
+	}
+
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return "", fmt.Errorf("api token file %s is empty", path)
+	}
+
+	return token, nil
+}
+
+// reload re-reads the file and hands a changed token to the clients using it.
+func (f *tokenFile) reload() (string, error) {
+	token, err := readTokenFile(f.path)
+	if err != nil {
+		return "", err
+	}
+
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	if token != f.token {
+		f.token = token
+		for _, client := range f.clients {
+			client.SetToken(token)
+		}
+	}
+
+	return token, nil
+}
+
+// newClient returns a client using the token of the file, reloaded when a request is rejected with 401 Unauthorized.
+func (f *tokenFile) newClient(httpClient *http.Client, baseUrl string, opts ...sentinelone.ClientOption) *sentinelone.Client {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	client := sentinelone.NewClient(httpClient, baseUrl, f.token, append(opts, sentinelone.WithTokenReload(f.reload))...)
+	f.clients = append(f.clients, client)
+
+	return client
+}
+
+// watch reloads the token whenever the directory of the file changes, until the context is done.
+// The directory is watched because secret mounts replace the file through a symlink instead of writing it.
+func (f *tokenFile) watch(ctx context.Context) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to watch api token file: %w", err)
+	}
+
+	if err := watcher.Add(filepath.Dir(f.path)); err != nil {
+		watcher.Close()
+		return fmt.Errorf("failed to watch api token file: %w", err)
+	}
+
+	go func() {
+		defer watcher.Close()
+
+		l := ctxzap.Extract(ctx)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				l.Warn("failed to watch api token file", zap.String("path", f.path), zap.Error(err))
+			case _, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				// a rotation briefly leaves the file missing or empty, the next event or a 401 reloads it.
+				if _, err := f.reload(); err != nil {
+					l.Debug("failed to reload api token file", zap.String("path", f.path), zap.Error(err))
+				}
+			}
+		}
+	}()
+
+	return nil
+}
@@ -0,0 +1,106 @@
+package connector
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/conductorone/baton-sentinel-one/pkg/sentinelone"
+)
+
+// tokenConsole only accepts the valid api token, and records the tokens it got.
+type tokenConsole struct {
+	srv *httptest.Server
+
+	mtx    sync.Mutex
+	valid  string
+	tokens []string
+}
+
+func newTokenConsole(t *testing.T, valid string) *tokenConsole {
+	c := &tokenConsole{valid: valid}
+	c.srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "ApiToken ")
+
+		c.mtx.Lock()
+		defer c.mtx.Unlock()
+
+		c.tokens = append(c.tokens, token)
+		if token != c.valid {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		writeJSON(w, sentinelone.SingleResponse[sentinelone.User]{Data: sentinelone.User{ID: "owner"}})
+	}))
+	t.Cleanup(c.srv.Close)
+
+	return c
+}
+
+func (c *tokenConsole) sent() []string {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	rv := c.tokens
+	c.tokens = nil
+
+	return rv
+}
+
+func writeToken(t *testing.T, path, token string) {
+	if err := os.WriteFile(path, []byte(token+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTokenFileReload(t *testing.T) {
+	tests := []struct {
+		name string
+		// rotated is written to the file after the console stopped accepting the old token, empty leaves the file alone.
+		rotated   string
+		wantErr   bool
+		wantSent  []string
+		wantOther []string
+	}{
+		{name: "rotated token", rotated: "new-token", wantSent: []string{"old-token", "new-token"}, wantOther: []string{"new-token"}},
+		{name: "unchanged file", wantErr: true, wantSent: []string{"old-token"}, wantOther: []string{"old-token"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "token")
+			writeToken(t, path, "old-token")
+
+			f, err := newTokenFile(path)
+			if err != nil {
+				t.Fatalf("newTokenFile() error = %v", err)
+			}
+
+			console := newTokenConsole(t, "new-token")
+			client := f.newClient(console.srv.Client(), console.srv.URL+fakeAPIPath)
+			other := f.newClient(console.srv.Client(), console.srv.URL+fakeAPIPath)
+
+			if tt.rotated != "" {
+				writeToken(t, path, tt.rotated)
+			}
+
+			if _, err := client.GetCurrentUser(context.Background()); (err != nil) != tt.wantErr {
+				t.Fatalf("GetCurrentUser() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := console.sent(); strings.Join(got, ",") != strings.Join(tt.wantSent, ",") {
+				t.Errorf("sent tokens %v, want %v", got, tt.wantSent)
+			}
+
+			// the other clients of the file got the reloaded token too.
+			_, _ = other.GetCurrentUser(context.Background())
+			if got := console.sent(); len(got) == 0 || got[0] != tt.wantOther[0] {
+				t.Errorf("other client sent tokens %v, want %v first", got, tt.wantOther)
+			}
+		})
+	}
+}
@@ -0,0 +1,368 @@
+package connector
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/conductorone/baton-sdk/pkg/pagination"
+
+	"github.com/conductorone/baton-sentinel-one/pkg/sentinelone"
+)
+
+// route is one api token of a console and the accounts and sites it can read.
+// Routes are only discovered when a console has several tokens, a single token is used for everything.
+type route struct {
+	client *sentinelone.Client
+
+	tenant   bool
+	accounts map[string]struct{}
+	// sites maps the readable sites to their account.
+	sites map[string]string
+
+	mtx sync.Mutex
+	// denied maps the resource types the token got a 403 for to the error.
+	denied map[string]string
+}
+
+// discover works out the scope of the token from the identity that owns it, and the accounts and sites it can read.
+func (r *route) discover(ctx context.Context) error {
+	owner, err := r.client.GetCurrentUser(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get the owner of the api token: %w", err)
+	}
+
+	if owner.Scope == scopeTenant {
+		r.tenant = true
+		return nil
+	}
+
+	r.accounts = make(map[string]struct{})
+	r.sites = make(map[string]string)
+
+	// site scope tokens may list the account of their sites, but can't read the rest of it.
+	if owner.Scope == scopeAccount {
+		page := ""
+		for {
+			accounts, nextCursor, err := r.client.GetAccounts(ctx, sentinelone.ParamsMap{
+				cursor: page,
+			})
+			if err != nil {
+				if r.forbidden(ctx, resourceTypeAccount.Id, err) {
+					break
+				}
+				return fmt.Errorf("failed to list accounts: %w", err)
+			}
+
+			for _, account := range accounts {
+				r.accounts[account.ID] = struct{}{}
+			}
+
+			if nextCursor == "" {
+				break
+			}
+			page = nextCursor
+		}
+	}
+
+	page := ""
+	for {
+		sites, nextCursor, err := r.client.GetSites(ctx, sentinelone.ParamsMap{
+			cursor: page,
+		})
+		if err != nil {
+			if r.forbidden(ctx, resourceTypeSite.Id, err) {
+				break
+			}
+			return fmt.Errorf("failed to list sites: %w", err)
+		}
+
+		for _, site := range sites {
+			r.sites[site.ID] = site.AccountID
+		}
+
+		if nextCursor == "" {
+			break
+		}
+		page = nextCursor
+	}
+
+	return nil
+}
+
+func (r *route) readsAccount(accountID string) bool {
+	_, ok := r.accounts[accountID]
+	return r.tenant || ok
+}
+
+func (r *route) readsSite(siteID string) bool {
+	_, ok := r.sites[siteID]
+	return r.tenant || ok
+}
+
+// routes holds the api tokens of a console. Every account, site and principal is owned by the first token that can read it,
+// so objects readable with several tokens are only synced once.
+type routes struct {
+	all []*route
+
+	once         sync.Once
+	discoverErr  error
+	mtx          sync.Mutex
+	siteAccounts map[string]string
+}
+
+func newRoutes(clients []*sentinelone.Client) *routes {
+	rv := &routes{
+		siteAccounts: make(map[string]string),
+	}
+	for _, client := range clients {
+		rv.all = append(rv.all, &route{client: client})
+	}
+
+	return rv
+}
+
+func (r *routes) single() bool {
+	return len(r.all) == 1
+}
+
+// first returns the client of the first token, used for calls whose result doesn't depend on the scope of the token.
+func (r *routes) first() *sentinelone.Client {
+	return r.all[0].client
+}
+
+func (r *routes) discover(ctx context.Context) error {
+	if r.single() {
+		return nil
+	}
+
+	r.once.Do(func() {
+		for i, rt := range r.all {
+			if err := rt.discover(ctx); err != nil {
+				r.discoverErr = fmt.Errorf("failed to discover the scope of api token %d: %w", i+1, err)
+				return
+			}
+		}
+	})
+
+	return r.discoverErr
+}
+
+// accountOwner returns the index of the first token that can read the account, or -1 if no token can.
+func (r *routes) accountOwner(ctx context.Context, accountID string) (int, error) {
+	if err := r.discover(ctx); err != nil {
+		return -1, err
+	}
+
+	for i, rt := range r.all {
+		if r.single() || rt.readsAccount(accountID) {
+			return i, nil
+		}
+	}
+
+	return -1, nil
+}
+
+// siteOwner returns the index of the first token that can read the site, or -1 if no token can.
+func (r *routes) siteOwner(ctx context.Context, siteID string) (int, error) {
+	if err := r.discover(ctx); err != nil {
+		return -1, err
+	}
+
+	for i, rt := range r.all {
+		if r.single() || rt.readsSite(siteID) {
+			return i, nil
+		}
+	}
+
+	return -1, nil
+}
+
+// principalOwner returns the index of the first token that can read one of the roles of the principal.
+// Principals without account or site roles are owned by the first tenant token, or the first token when there is none.
+func (r *routes) principalOwner(ctx context.Context, scope string, scopeRoles []sentinelone.Role) (int, error) {
+	if r.single() {
+		return 0, nil
+	}
+
+	owner := -1
+	for _, scopeRole := range scopeRoles {
+		var (
+			i   int
+			err error
+		)
+		switch scope {
+		case scopeAccount:
+			i, err = r.accountOwner(ctx, scopeRole.ID)
+		case scopeSite:
+			i, err = r.siteOwner(ctx, scopeRole.ID)
+		default:
+			continue
+		}
+		if err != nil {
+			return -1, err
+		}
+
+		if i >= 0 && (owner < 0 || i < owner) {
+			owner = i
+		}
+	}
+
+	if owner >= 0 {
+		return owner, nil
+	}
+
+	if err := r.discover(ctx); err != nil {
+		return -1, err
+	}
+
+	for i, rt := range r.all {
+		if rt.tenant {
+			return i, nil
+		}
+	}
+
+	return 0, nil
+}
+
+// clientFor returns the client of the token that owns the tenant, account or site.
+func (r *routes) clientFor(ctx context.Context, scope, scopeID string) (*sentinelone.Client, error) {
+	rt, err := r.routeFor(ctx, scope, scopeID)
+	if err != nil {
+		return nil, err
+	}
+
+	return rt.client, nil
+}
+
+// routeFor returns the token that owns the tenant, account or site.
+func (r *routes) routeFor(ctx context.Context, scope, scopeID string) (*route, error) {
+	var (
+		i   int
+		err error
+	)
+	switch scope {
+	case scopeAccount:
+		i, err = r.accountOwner(ctx, scopeID)
+	case scopeSite:
+		i, err = r.siteOwner(ctx, scopeID)
+	default:
+		i, err = r.principalOwner(ctx, scope, nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if i < 0 {
+		return nil, fmt.Errorf("no api token can read %s %s", scope, scopeID)
+	}
+
+	return r.all[i], nil
+}
+
+// siteAccountID returns the id of the account the site belongs to, scope roles only carry the account name.
+func (r *routes) siteAccountID(ctx context.Context, siteID string) (string, error) {
+	if err := r.discover(ctx); err != nil {
+		return "", err
+	}
+
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	for _, rt := range r.all {
+		if accountID, ok := rt.sites[siteID]; ok {
+			return accountID, nil
+		}
+	}
+
+	if accountID, ok := r.siteAccounts[siteID]; ok {
+		return accountID, nil
+	}
+
+	client, err := r.clientFor(ctx, scopeSite, siteID)
+	if err != nil {
+		return "", err
+	}
+
+	sites, _, err := client.GetSites(ctx, sentinelone.ParamsMap{
+		sitesFilter: siteID,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to look up account of site %s: %w", siteID, err)
+	}
+
+	for _, site := range sites {
+		r.siteAccounts[site.ID] = site.AccountID
+	}
+
+	return r.siteAccounts[siteID], nil
+}
+
+// fanOut replaces the first page state of a listing by one page state per token, so the listing runs with every token.
+// It returns the index of the token to list with, and false while the fan out is pending and there is nothing to list yet.
+func (r *routes) fanOut(bag *pagination.Bag) (int, bool, error) {
+	if r.single() {
+		return 0, true, nil
+	}
+
+	if bag.ResourceID() == "" {
+		state := bag.Pop()
+		for i := len(r.all) - 1; i >= 0; i-- {
+			bag.Push(pagination.PageState{
+				ResourceTypeID: state.ResourceTypeID,
+				ResourceID:     strconv.Itoa(i),
+			})
+		}
+
+		return 0, false, nil
+	}
+
+	i, err := strconv.Atoi(bag.ResourceID())
+	if err != nil || i < 0 || i >= len(r.all) {
+		return 0, false, fmt.Errorf("invalid api token index %q in page token", bag.ResourceID())
+	}
+
+	return i, true, nil
+}
+
+// ownedPrincipals keeps the principals listed with the token at index that the token owns.
+func ownedPrincipals[T any](ctx context.Context, r *routes, index int, principals []T, roles func(T) (string, []sentinelone.Role)) ([]T, error) {
+	if r.single() {
+		return principals, nil
+	}
+
+	rv := make([]T, 0, len(principals))
+	for _, p := range principals {
+		scope, scopeRoles := roles(p)
+		owner, err := r.principalOwner(ctx, scope, scopeRoles)
+		if err != nil {
+			return nil, err
+		}
+
+		if owner == index {
+			rv = append(rv, p)
+		}
+	}
+
+	return rv, nil
+}
+
+// keepPrincipal tells whether a principal streamed with the token at index is owned by the token and kept by the filter.
+func keepPrincipal[T any](ctx context.Context, c *console, index int, p T, roles func(T) (string, []sentinelone.Role), filter func(context.Context, []T) ([]T, error)) (bool, error) {
+	kept, err := ownedPrincipals(ctx, c.routes, index, []T{p}, roles)
+	if err != nil || len(kept) == 0 {
+		return false, err
+	}
+
+	kept, err = filter(ctx, kept)
+	return len(kept) > 0, err
+}
+
+func userRoles(user sentinelone.User) (string, []sentinelone.Role) {
+	return user.Scope, user.ScopeRoles
+}
+
+func serviceUserRoles(serviceUser sentinelone.ServiceUser) (string, []sentinelone.Role) {
+	return serviceUser.Scope, serviceUser.ScopeRoles
+}
@@ -0,0 +1,144 @@
+package connector
+
+import (
+	"context"
+	"testing"
+
+	"github.com/conductorone/baton-sdk/pkg/pagination"
+
+	"github.com/conductorone/baton-sentinel-one/pkg/sentinelone"
+)
+
+// discoveredRoutes returns routes whose scopes are already known, so nothing is discovered from the api.
+func discoveredRoutes(all ...*route) *routes {
+	rv := &routes{
+		all:          all,
+		siteAccounts: make(map[string]string),
+	}
+	rv.once.Do(func() {})
+
+	return rv
+}
+
+func accountRoute(accountIDs ...string) *route {
+	rv := &route{accounts: make(map[string]struct{}), sites: make(map[string]string)}
+	for _, id := range accountIDs {
+		rv.accounts[id] = struct{}{}
+	}
+
+	return rv
+}
+
+func siteRoute(sites map[string]string) *route {
+	return &route{accounts: make(map[string]struct{}), sites: sites}
+}
+
+func TestRoutesPrincipalOwner(t *testing.T) {
+	r := discoveredRoutes(
+		siteRoute(map[string]string{"s1": "a1"}),
+		accountRoute("a1"),
+		&route{tenant: true},
+	)
+
+	tests := []struct {
+		name       string
+		scope      string
+		scopeRoles []sentinelone.Role
+		want       int
+	}{
+		{name: "site read by the site token", scope: scopeSite, scopeRoles: []sentinelone.Role{{ID: "s1"}}, want: 0},
+		{name: "account read by the account token", scope: scopeAccount, scopeRoles: []sentinelone.Role{{ID: "a1"}}, want: 1},
+		{name: "first token of any role", scope: scopeSite, scopeRoles: []sentinelone.Role{{ID: "s2"}, {ID: "s1"}}, want: 0},
+		{name: "account only the tenant token reads", scope: scopeAccount, scopeRoles: []sentinelone.Role{{ID: "a2"}}, want: 2},
+		{name: "tenant principal", scope: scopeTenant, want: 2},
+		{name: "principal without roles", scope: scopeAccount, want: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := r.principalOwner(context.Background(), tt.scope, tt.scopeRoles)
+			if err != nil {
+				t.Fatalf("principalOwner() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("principalOwner() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRoutesOwnersWithoutTenantToken(t *testing.T) {
+	r := discoveredRoutes(accountRoute("a1"), siteRoute(map[string]string{"s2": "a2"}))
+	ctx := context.Background()
+
+	if i, _ := r.accountOwner(ctx, "a2"); i != -1 {
+		t.Errorf("accountOwner() of an unreadable account = %d, want -1", i)
+	}
+	if i, _ := r.siteOwner(ctx, "s2"); i != 1 {
+		t.Errorf("siteOwner() = %d, want 1", i)
+	}
+	if i, _ := r.principalOwner(ctx, scopeTenant, nil); i != 0 {
+		t.Errorf("principalOwner() of a tenant principal = %d, want the first token", i)
+	}
+	if _, err := r.routeFor(ctx, scopeAccount, "a2"); err == nil {
+		t.Error("expected an error for an account no token reads")
+	}
+
+	accountID, err := r.siteAccountID(ctx, "s2")
+	if err != nil || accountID != "a2" {
+		t.Errorf("siteAccountID() = %q, %v, want a2", accountID, err)
+	}
+}
+
+// TestOwnedPrincipals checks that a principal readable with several tokens is only listed with the token that owns it.
+func TestOwnedPrincipals(t *testing.T) {
+	r := discoveredRoutes(accountRoute("a1"), accountRoute("a1", "a2"))
+	users := []sentinelone.User{
+		{ID: "u1", Scope: scopeAccount, ScopeRoles: []sentinelone.Role{{ID: "a1"}}},
+		{ID: "u2", Scope: scopeAccount, ScopeRoles: []sentinelone.Role{{ID: "a2"}}},
+		{ID: "u3", Scope: scopeAccount, ScopeRoles: []sentinelone.Role{{ID: "a2"}, {ID: "a1"}}},
+	}
+
+	for index, want := range [][]string{{"u1", "u3"}, {"u2"}} {
+		owned, err := ownedPrincipals(context.Background(), r, index, users, userRoles)
+		if err != nil {
+			t.Fatalf("ownedPrincipals() error = %v", err)
+		}
+
+		var ids []string
+		for _, user := range owned {
+			ids = append(ids, user.ID)
+		}
+		if !equalStrings(ids, want) {
+			t.Errorf("ownedPrincipals() of token %d = %v, want %v", index, ids, want)
+		}
+	}
+}
+
+func TestRoutesFanOut(t *testing.T) {
+	r := discoveredRoutes(&route{tenant: true}, accountRoute("a1"), accountRoute("a2"))
+
+	bag := &pagination.Bag{}
+	bag.Push(pagination.PageState{ResourceTypeID: resourceTypeUser.Id})
+
+	if _, ready, err := r.fanOut(bag); err != nil || ready {
+		t.Fatalf("fanOut() of a new listing = %v, %v, want a pending fan out", ready, err)
+	}
+
+	for want := 0; want < 3; want++ {
+		index, ready, err := r.fanOut(bag)
+		if err != nil || !ready || index != want {
+			t.Fatalf("fanOut() = %d, %v, %v, want token %d", index, ready, err, want)
+		}
+		bag.Pop()
+	}
+
+	if bag.Current() != nil {
+		t.Errorf("page states left after every token listed: %v", bag.Current())
+	}
+
+	bag.Push(pagination.PageState{ResourceTypeID: resourceTypeUser.Id, ResourceID: "3"})
+	if _, _, err := r.fanOut(bag); err == nil {
+		t.Error("expected an error for the index of an unknown token")
+	}
+}
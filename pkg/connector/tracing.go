@@ -0,0 +1,142 @@
+package connector
+
+import (
+	"context"
+
+	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
+	"github.com/conductorone/baton-sdk/pkg/annotations"
+	"github.com/conductorone/baton-sdk/pkg/connectorbuilder"
+	"github.com/conductorone/baton-sdk/pkg/pagination"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentationName is the name of the tracer of the syncers, the requests they send are traced by the client package.
+const instrumentationName = "github.com/conductorone/baton-sentinel-one/pkg/connector"
+
+// tracedSyncer wraps every call of a syncer in a span, so a slow account or role fan-out shows up in the trace of a sync.
+// It also reports the listings to the lifecycle of the sync, so the connector knows when a sync starts and ends.
+type tracedSyncer struct {
+	connectorbuilder.ResourceSyncer
+	tracer    trace.Tracer
+	lifecycle *syncLifecycle
+}
+
+// tracedProvisioner keeps the provisioning of a traced syncer visible to the SDK, which looks it up with a type assertion.
+type tracedProvisioner struct {
+	*tracedSyncer
+	provisioner connectorbuilder.ResourceProvisioner
+}
+
+func newTracedSyncer(syncer connectorbuilder.ResourceSyncer, tracer trace.Tracer, lifecycle *syncLifecycle) connectorbuilder.ResourceSyncer {
+	rv := &tracedSyncer{
+		ResourceSyncer: syncer,
+		tracer:         tracer,
+		lifecycle:      lifecycle,
+	}
+
+	if provisioner, ok := syncer.(connectorbuilder.ResourceProvisioner); ok {
+		return &tracedProvisioner{
+			tracedSyncer: rv,
+			provisioner:  provisioner,
+		}
+	}
+
+	return rv
+}
+
+func (t *tracedSyncer) start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	resourceTypeID := t.ResourceType(ctx).Id
+	return t.tracer.Start(ctx, resourceTypeID+"."+name, trace.WithAttributes(
+		append(attrs, attribute.String("baton.resource_type", resourceTypeID))...,
+	))
+}
+
+func pageAttribute(pToken *pagination.Token) attribute.KeyValue {
+	var page string
+	if pToken != nil {
+		page = pToken.Token
+	}
+
+	return attribute.String("baton.page_token", page)
+}
+
+func resourceAttribute(resource *v2.Resource) attribute.KeyValue {
+	return attribute.String("baton.resource_id", resource.GetId().GetResource())
+}
+
+// end ends the span with the number of items returned, whether another page follows, or the error.
+func end(span trace.Span, count int, nextPage string, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	} else {
+		span.SetAttributes(
+			attribute.Int("baton.item_count", count),
+			attribute.Bool("baton.has_next_page", nextPage != ""),
+		)
+	}
+
+	span.End()
+}
+
+func (t *tracedSyncer) List(ctx context.Context, parentId *v2.ResourceId, pToken *pagination.Token) ([]*v2.Resource, string, annotations.Annotations, error) {
+	if err := t.lifecycle.listing(ctx); err != nil {
+		return nil, "", nil, err
+	}
+
+	ctx, span := t.start(ctx, "List", attribute.String("baton.parent_resource_id", parentId.GetResource()), pageAttribute(pToken))
+	rv, nextPage, annos, err := t.ResourceSyncer.List(ctx, parentId, pToken)
+	end(span, len(rv), nextPage, err)
+	if err != nil {
+		t.lifecycle.failed()
+		return nil, "", nil, err
+	}
+
+	t.lifecycle.listed(t.ResourceType(ctx), rv)
+	return rv, nextPage, annos, nil
+}
+
+func (t *tracedSyncer) Entitlements(ctx context.Context, resource *v2.Resource, pToken *pagination.Token) ([]*v2.Entitlement, string, annotations.Annotations, error) {
+	ctx, span := t.start(ctx, "Entitlements", resourceAttribute(resource), pageAttribute(pToken))
+	rv, nextPage, annos, err := t.ResourceSyncer.Entitlements(ctx, resource, pToken)
+	end(span, len(rv), nextPage, err)
+	if err != nil {
+		t.lifecycle.failed()
+	}
+
+	return rv, nextPage, annos, err
+}
+
+func (t *tracedSyncer) Grants(ctx context.Context, resource *v2.Resource, pToken *pagination.Token) ([]*v2.Grant, string, annotations.Annotations, error) {
+	ctx, span := t.start(ctx, "Grants", resourceAttribute(resource), pageAttribute(pToken))
+	rv, nextPage, annos, err := t.ResourceSyncer.Grants(ctx, resource, pToken)
+	end(span, len(rv), nextPage, err)
+	if err != nil {
+		t.lifecycle.failed()
+		return nil, "", nil, err
+	}
+
+	if err := t.lifecycle.granted(ctx, resource, nextPage); err != nil {
+		return nil, "", nil, err
+	}
+
+	return rv, nextPage, annos, nil
+}
+
+func (t *tracedProvisioner) Grant(ctx context.Context, resource *v2.Resource, entitlement *v2.Entitlement) (annotations.Annotations, error) {
+	ctx, span := t.start(ctx, "Grant", resourceAttribute(resource), attribute.String("baton.entitlement_id", entitlement.GetId()))
+	annos, err := t.provisioner.Grant(ctx, resource, entitlement)
+	end(span, 0, "", err)
+
+	return annos, err
+}
+
+func (t *tracedProvisioner) Revoke(ctx context.Context, grant *v2.Grant) (annotations.Annotations, error) {
+	ctx, span := t.start(ctx, "Revoke", attribute.String("baton.grant_id", grant.GetId()))
+	annos, err := t.provisioner.Revoke(ctx, grant)
+	end(span, 0, "", err)
+
+	return annos, err
+}
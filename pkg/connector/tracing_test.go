@@ -0,0 +1,148 @@
+package connector
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"github.com/conductorone/baton-sentinel-one/pkg/sentinelone"
+)
+
+func spanAttribute(span sdktrace.ReadOnlySpan, key attribute.Key) (attribute.Value, bool) {
+	for _, kv := range span.Attributes() {
+		if kv.Key == key {
+			return kv.Value, true
+		}
+	}
+
+	return attribute.Value{}, false
+}
+
+// childSpans returns the spans started under the parent with the name.
+func childSpans(spans []sdktrace.ReadOnlySpan, parent sdktrace.ReadOnlySpan, name string) []sdktrace.ReadOnlySpan {
+	var rv []sdktrace.ReadOnlySpan
+	for _, span := range spans {
+		if span.Name() == name && span.Parent().SpanID() == parent.SpanContext().SpanID() {
+			rv = append(rv, span)
+		}
+	}
+
+	return rv
+}
+
+func TestTracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	f := newFakeConsole(t)
+	f.accounts = []sentinelone.Account{{ID: "a1", Name: "Acme"}, {ID: "a2", Name: "Globex"}}
+	f.users = []sentinelone.User{{ID: "u1", Scope: scopeAccount, ScopeRoles: []sentinelone.Role{{ID: "a1"}}}}
+	s := f.newConnector(t, WithTracerProvider(provider))
+	accounts := syncer(t, s, resourceTypeAccount)
+
+	listed := listResources(t, accounts, nil)
+	if _, _, err := listGrants(accounts, listed[0]); err != nil {
+		t.Fatalf("Grants() error = %v", err)
+	}
+
+	spans := recorder.Ended()
+
+	var list sdktrace.ReadOnlySpan
+	for _, span := range spans {
+		if count, ok := spanAttribute(span, "baton.item_count"); ok && span.Name() == "account.List" && count.AsInt64() == 2 {
+			list = span
+		}
+	}
+	if list == nil {
+		t.Fatal("no account.List span with the 2 accounts")
+	}
+	if list.Status().Code != codes.Unset {
+		t.Errorf("account.List status = %v, want unset", list.Status())
+	}
+	if rt, _ := spanAttribute(list, "baton.resource_type"); rt.AsString() != resourceTypeAccount.Id {
+		t.Errorf("account.List resource type = %q", rt.AsString())
+	}
+
+	requests := childSpans(spans, list, "GET accounts")
+	if len(requests) != 1 {
+		t.Fatalf("account.List has %d GET accounts spans, want 1", len(requests))
+	}
+	if count, _ := spanAttribute(requests[0], "sentinelone.item_count"); count.AsInt64() != 2 {
+		t.Errorf("GET accounts item count = %d, want 2", count.AsInt64())
+	}
+
+	var users []sdktrace.ReadOnlySpan
+	for _, span := range spans {
+		if span.Name() == "account.Grants" {
+			users = append(users, childSpans(spans, span, "GET users")...)
+		}
+	}
+	if len(users) != 1 {
+		t.Fatalf("account.Grants has %d GET users spans, want 1", len(users))
+	}
+	if count, _ := spanAttribute(users[0], "sentinelone.item_count"); count.AsInt64() != 1 {
+		t.Errorf("GET users item count = %d, want 1", count.AsInt64())
+	}
+}
+
+func TestTracingError(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	f := newFakeConsole(t)
+	f.accounts = []sentinelone.Account{{ID: "a1", Name: "Acme"}}
+	f.setStatus("sites", http.StatusInternalServerError)
+	s := f.newConnector(t, WithTracerProvider(provider), WithScopeFailures(ScopeFailures{Mode: ScopeFailureFail}))
+	accounts := syncer(t, s, resourceTypeAccount)
+
+	if _, _, err := listGrants(accounts, listResources(t, accounts, nil)[0]); err == nil {
+		t.Fatal("expected the grants to fail")
+	}
+
+	spans := recorder.Ended()
+	for _, span := range spans {
+		if span.Name() != "account.Grants" || span.Status().Code != codes.Error {
+			continue
+		}
+
+		sites := childSpans(spans, span, "GET sites")
+		if len(sites) != 1 || sites[0].Status().Code != codes.Error {
+			t.Fatalf("failed account.Grants has GET sites spans %v, want one with an error", sites)
+		}
+		if _, ok := spanAttribute(sites[0], "sentinelone.item_count"); ok {
+			t.Error("failed request has an item count")
+		}
+		return
+	}
+
+	t.Fatal("no failed account.Grants span")
+}
+
+// TestTracesFlushedAtSyncEnd checks that the batched spans are exported when the grants of the sync are listed,
+// the SDK may exit the connector right after.
+func TestTracesFlushedAtSyncEnd(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter, sdktrace.WithBatchTimeout(time.Hour)))
+
+	f := newFakeConsole(t)
+	f.accounts = []sentinelone.Account{{ID: "a1", Name: "Acme"}}
+	s := f.newConnector(t, WithTracerProvider(provider))
+	accounts := syncer(t, s, resourceTypeAccount)
+
+	listed := listResources(t, accounts, nil)
+	if spans := exporter.GetSpans(); len(spans) != 0 {
+		t.Fatalf("exported %d spans before the sync ended", len(spans))
+	}
+
+	if _, _, err := listGrants(accounts, listed[0]); err != nil {
+		t.Fatalf("Grants() error = %v", err)
+	}
+	if len(exporter.GetSpans()) == 0 {
+		t.Error("spans not exported when the sync ended")
+	}
+}
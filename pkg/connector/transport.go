@@ -0,0 +1,136 @@
+package connector
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+
+	"github.com/conductorone/baton-sdk/pkg/uhttp"
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+
+	"github.com/conductorone/baton-sentinel-one/pkg/sentinelone"
+)
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// HTTPOptions configures how the management consoles are reached, e.g. for on-premises consoles or a TLS-inspecting proxy.
+type HTTPOptions struct {
+	// ProxyURL is the proxy every request goes through, the proxy environment variables are used when it's empty.
+	ProxyURL string
+	// CABundle is a PEM file of certificates trusted in addition to the system ones.
+	CABundle string
+	// ClientCert and ClientKey are PEM files of the client certificate presented for mutual TLS.
+	ClientCert string
+	ClientKey  string
+	// MinTLSVersion is the lowest TLS version accepted, e.g. "1.2", empty for 1.2.
+	MinTLSVersion string
+}
+
+// Validate returns an error if the options are incomplete or malformed. The files are only read by the connector.
+func (o HTTPOptions) Validate() error {
+	if o.ProxyURL != "" {
+		if _, err := parseProxyURL(o.ProxyURL); err != nil {
+			return err
+		}
+	}
+
+	if (o.ClientCert == "") != (o.ClientKey == "") {
+		return fmt.Errorf("client certificate and client key must be given together")
+	}
+
+	if o.MinTLSVersion != "" {
+		if _, ok := tlsVersions[o.MinTLSVersion]; !ok {
+			return fmt.Errorf("unsupported minimum tls version %q, must be 1.0, 1.1, 1.2 or 1.3", o.MinTLSVersion)
+		}
+	}
+
+	return nil
+}
+
+func parseProxyURL(rawURL string) (*url.URL, error) {
+	proxyURL, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy url: %w", err)
+	}
+
+	if proxyURL.Scheme == "" || proxyURL.Host == "" {
+		return nil, fmt.Errorf("invalid proxy url %q, it needs a scheme and a host", rawURL)
+	}
+
+	return proxyURL, nil
+}
+
+func (o HTTPOptions) tlsConfig() (*tls.Config, error) {
+	rv := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+	if o.MinTLSVersion != "" {
+		rv.MinVersion = tlsVersions[o.MinTLSVersion]
+	}
+
+	if o.CABundle != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+
+		pem, err := os.ReadFile(o.CABundle)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read ca bundle: %w", err)
+		}
+
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("ca bundle %s holds no PEM certificate", o.CABundle)
+		}
+		rv.RootCAs = pool
+	}
+
+	if o.ClientCert != "" {
+		cert, err := tls.LoadX509KeyPair(o.ClientCert, o.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		rv.Certificates = []tls.Certificate{cert}
+	}
+
+	return rv, nil
+}
+
+// newHTTPClient returns the client shared by every console.
+// The SDK transport always takes the proxy from the environment, so an explicit proxy gets a standard transport.
+// Requests are logged by the redacting transport of the client package instead of the SDK transport.
+func newHTTPClient(ctx context.Context, o HTTPOptions, hashPII bool) (*http.Client, error) {
+	tlsConfig, err := o.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	var transport http.RoundTripper
+	if o.ProxyURL == "" {
+		transport, err = uhttp.NewTransport(ctx, uhttp.WithLogger(false, ctxzap.Extract(ctx)), uhttp.WithTLSClientConfig(tlsConfig))
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		proxyURL, err := parseProxyURL(o.ProxyURL)
+		if err != nil {
+			return nil, err
+		}
+
+		proxyTransport := http.DefaultTransport.(*http.Transport).Clone()
+		proxyTransport.Proxy = http.ProxyURL(proxyURL)
+		proxyTransport.TLSClientConfig = tlsConfig
+		transport = proxyTransport
+	}
+
+	return &http.Client{Transport: sentinelone.NewLoggingTransport(transport, hashPII)}, nil
+}
@@ -0,0 +1,170 @@
+package connector
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestHTTPOptionsValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		options HTTPOptions
+		wantErr bool
+	}{
+		{name: "empty", options: HTTPOptions{}},
+		{name: "proxy", options: HTTPOptions{ProxyURL: "http://proxy.internal:3128"}},
+		{name: "proxy without scheme", options: HTTPOptions{ProxyURL: "proxy.internal:3128"}, wantErr: true},
+		{name: "proxy without host", options: HTTPOptions{ProxyURL: "http://"}, wantErr: true},
+		{name: "malformed proxy", options: HTTPOptions{ProxyURL: "http://proxy internal"}, wantErr: true},
+		{name: "client certificate", options: HTTPOptions{ClientCert: "cert.pem", ClientKey: "key.pem"}},
+		{name: "client certificate without key", options: HTTPOptions{ClientCert: "cert.pem"}, wantErr: true},
+		{name: "client key without certificate", options: HTTPOptions{ClientKey: "key.pem"}, wantErr: true},
+		{name: "tls 1.3", options: HTTPOptions{MinTLSVersion: "1.3"}},
+		{name: "unknown tls version", options: HTTPOptions{MinTLSVersion: "1.4"}, wantErr: true},
+		{name: "tls version with prefix", options: HTTPOptions{MinTLSVersion: "TLS1.2"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.options.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// writePEM writes the certificate and the key of the test server, so the client can trust it or present it.
+func writePEM(t *testing.T, srv *httptest.Server) (string, string) {
+	dir := t.TempDir()
+
+	certPath := filepath.Join(dir, "cert.pem")
+	cert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	if err := os.WriteFile(certPath, cert, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(srv.TLS.Certificates[0].PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPath := filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	return certPath, keyPath
+}
+
+func TestHTTPClientTLS(t *testing.T) {
+	tests := []struct {
+		name string
+		// server configures the tls of the server before it starts.
+		server  func(*tls.Config)
+		options func(certPath, keyPath string) HTTPOptions
+		wantErr bool
+	}{
+		{
+			name:    "untrusted certificate",
+			options: func(string, string) HTTPOptions { return HTTPOptions{} },
+			wantErr: true,
+		},
+		{
+			name:    "ca bundle",
+			options: func(certPath, _ string) HTTPOptions { return HTTPOptions{CABundle: certPath} },
+		},
+		{
+			name:   "client certificate",
+			server: func(c *tls.Config) { c.ClientAuth = tls.RequireAnyClientCert },
+			options: func(certPath, keyPath string) HTTPOptions {
+				return HTTPOptions{CABundle: certPath, ClientCert: certPath, ClientKey: keyPath}
+			},
+		},
+		{
+			name:    "missing client certificate",
+			server:  func(c *tls.Config) { c.ClientAuth = tls.RequireAnyClientCert },
+			options: func(certPath, _ string) HTTPOptions { return HTTPOptions{CABundle: certPath} },
+			wantErr: true,
+		},
+		{
+			name:    "server below the minimum version",
+			server:  func(c *tls.Config) { c.MaxVersion = tls.VersionTLS12 },
+			options: func(certPath, _ string) HTTPOptions { return HTTPOptions{CABundle: certPath, MinTLSVersion: "1.3"} },
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+			srv.TLS = &tls.Config{}
+			if tt.server != nil {
+				tt.server(srv.TLS)
+			}
+			srv.StartTLS()
+			defer srv.Close()
+
+			client, err := newHTTPClient(context.Background(), tt.options(writePEM(t, srv)), false)
+			if err != nil {
+				t.Fatalf("newHTTPClient() error = %v", err)
+			}
+
+			res, err := client.Get(srv.URL)
+			if err == nil {
+				res.Body.Close()
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Get() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestHTTPClientInvalidFiles(t *testing.T) {
+	dir := t.TempDir()
+	notPEM := filepath.Join(dir, "bundle.pem")
+	if err := os.WriteFile(notPEM, []byte("not a certificate"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, options := range map[string]HTTPOptions{
+		"missing ca bundle":          {CABundle: filepath.Join(dir, "missing.pem")},
+		"ca bundle without pem":      {CABundle: notPEM},
+		"unreadable client key pair": {ClientCert: notPEM, ClientKey: notPEM},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := newHTTPClient(context.Background(), options, false); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestHTTPClientProxy(t *testing.T) {
+	var proxied []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = append(proxied, r.URL.String())
+	}))
+	defer proxy.Close()
+
+	client, err := newHTTPClient(context.Background(), HTTPOptions{ProxyURL: proxy.URL}, false)
+	if err != nil {
+		t.Fatalf("newHTTPClient() error = %v", err)
+	}
+
+	res, err := client.Get("http://console.invalid/web/api/v2.1/users")
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	res.Body.Close()
+
+	if len(proxied) != 1 || proxied[0] != "http://console.invalid/web/api/v2.1/users" {
+		t.Errorf("proxied %v, want the request to the console", proxied)
+	}
+}
@@ -0,0 +1,74 @@
+package connector
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// DefaultAPIVersion is the version of the management console API used when none is configured.
+const DefaultAPIVersion = "v2.1"
+
+// consoleAPIPath is the path of the console UI and API, everything from it on is dropped from a configured url.
+const consoleAPIPath = "/web"
+
+var apiVersionPattern = regexp.MustCompile(`^v[0-9]+\.[0-9]+$`)
+
+// ValidateAPIVersion returns an error if the version doesn't look like "v2.1".
+func ValidateAPIVersion(version string) error {
+	if !apiVersionPattern.MatchString(version) {
+		return fmt.Errorf("api version %q must have the form v<major>.<minor>, e.g. %s", version, DefaultAPIVersion)
+	}
+
+	return nil
+}
+
+// NormalizeConsoleURL turns the configured url of a management console into its base url.
+// The scheme defaults to https, and the console paths copied from the browser, such as /web or /web/api/v2.1, are dropped.
+// A path before them is kept, for consoles served under a prefix by a reverse proxy.
+func NormalizeConsoleURL(rawURL string) (string, error) {
+	trimmed := strings.TrimSpace(rawURL)
+	if trimmed == "" {
+		return "", fmt.Errorf("management console url is empty")
+	}
+
+	if !strings.Contains(trimmed, "://") {
+		trimmed = "https://" + trimmed
+	}
+
+	u, err := url.Parse(trimmed)
+	if err != nil {
+		return "", fmt.Errorf("management console url %q is not a valid url: %w", rawURL, err)
+	}
+
+	if u.Scheme != "https" && u.Scheme != "http" {
+		return "", fmt.Errorf("management console url %q must use https or http, not %s", rawURL, u.Scheme)
+	}
+
+	if u.Host == "" {
+		return "", fmt.Errorf("management console url %q has no host", rawURL)
+	}
+
+	if u.User != nil {
+		return "", fmt.Errorf("management console url %q must not contain credentials, use an api token", rawURL)
+	}
+
+	path := u.Path
+	if i := strings.Index(path+"/", consoleAPIPath+"/"); i >= 0 {
+		path = path[:i]
+	}
+
+	rv := url.URL{
+		Scheme: u.Scheme,
+		Host:   u.Host,
+		Path:   strings.TrimRight(path, "/"),
+	}
+
+	return rv.String(), nil
+}
+
+// apiURL returns the base url of the API of the console, ending with a slash.
+func apiURL(consoleURL, version string) string {
+	return fmt.Sprintf("%s%s/api/%s/", consoleURL, consoleAPIPath, version)
+}
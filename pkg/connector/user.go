@@ -2,17 +2,38 @@ package connector
 
 import (
 	"context"
+	"fmt"
 
 	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
 	"github.com/conductorone/baton-sdk/pkg/annotations"
 	"github.com/conductorone/baton-sdk/pkg/pagination"
+	ent "github.com/conductorone/baton-sdk/pkg/types/entitlement"
+	grant "github.com/conductorone/baton-sdk/pkg/types/grant"
 	rs "github.com/conductorone/baton-sdk/pkg/types/resource"
 	"github.com/conductorone/baton-sentinel-one/pkg/sentinelone"
 )
 
+const (
+	apiTokenEntitlement            = "api_token"
+	twoFactorRequiredEntitlement   = "two_factor_required"
+	twoFactorEnrollmentEntitlement = "two_factor_enrollment"
+
+	apiTokenCreatedAtProfileKey = "api_token_created_at"
+	apiTokenExpiresAtProfileKey = "api_token_expires_at"
+	twoFactorEnabledProfileKey  = "two_factor_enabled"
+	twoFactorStatusProfileKey   = "two_factor_status"
+)
+
+var userRevokeOperations = map[string]string{
+	apiTokenEntitlement:            revokeAPITokenOperation,
+	twoFactorRequiredEntitlement:   disableTwoFactorOperation,
+	twoFactorEnrollmentEntitlement: resetTwoFactorOperation,
+}
+
 type userResourceType struct {
 	resourceType *v2.ResourceType
-	client       *sentinelone.Client
+	consoles     *consoleSet
+	types        resourceTypeSet
 }
 
 func (u *userResourceType) ResourceType(_ context.Context) *v2.ResourceType {
@@ -20,7 +41,7 @@ func (u *userResourceType) ResourceType(_ context.Context) *v2.ResourceType {
 }
 
 // Create a new connector resource for a SentinelOne user.
-func userResource(user *sentinelone.User, parentResourceID *v2.ResourceId) (*v2.Resource, error) {
+func userResource(c *console, user *sentinelone.User, parentResourceID *v2.ResourceId) (*v2.Resource, error) {
 	firstName, lastName := splitFullName(user.FullName)
 
 	profile := map[string]interface{}{
@@ -28,8 +49,18 @@ func userResource(user *sentinelone.User, parentResourceID *v2.ResourceId) (*v2.
 		"last_name":  lastName,
 		"login":      user.Email,
 		"user_id":    user.ID,
+
+		twoFactorEnabledProfileKey: user.TwoFaEnabled,
+		twoFactorStatusProfileKey:  user.TwoFaStatus,
 	}
 
+	if user.APIToken != nil {
+		profile[apiTokenCreatedAtProfileKey] = user.APIToken.CreatedAt
+		profile[apiTokenExpiresAtProfileKey] = user.APIToken.ExpiresAt
+	}
+
+	c.activity.addToProfile(profile, user.ID)
+
 	userTraitOptions := []rs.UserTraitOption{
 		rs.WithUserProfile(profile),
 		rs.WithStatus(v2.UserTrait_Status_STATUS_ENABLED),
@@ -39,7 +70,7 @@ func userResource(user *sentinelone.User, parentResourceID *v2.ResourceId) (*v2.
 	ret, err := rs.NewUserResource(
 		user.FullName,
 		resourceTypeUser,
-		user.ID,
+		c.id(user.ID),
 		userTraitOptions,
 		rs.WithParentResourceID(parentResourceID),
 	)
@@ -51,8 +82,10 @@ func userResource(user *sentinelone.User, parentResourceID *v2.ResourceId) (*v2.
 }
 
 func (u *userResourceType) List(ctx context.Context, parentId *v2.ResourceId, pToken *pagination.Token) ([]*v2.Resource, string, annotations.Annotations, error) {
-	if parentId == nil {
-		return nil, "", nil, nil
+	// users are listed under their accounts, unless accounts are not synced.
+	c, ok, err := u.consoles.listedUnder(parentId, u.types, true)
+	if err != nil || !ok {
+		return nil, "", nil, err
 	}
 
 	bag, page, err := parsePageToken(pToken.Token, &v2.ResourceId{ResourceType: resourceTypeUser.Id})
@@ -60,42 +93,201 @@ func (u *userResourceType) List(ctx context.Context, parentId *v2.ResourceId, pT
 		return nil, "", nil, err
 	}
 
-	users, nextCursor, err := u.client.GetUsers(ctx, sentinelone.ParamsMap{
-		cursor: page,
-	})
+	index, ready, err := c.routes.fanOut(bag)
 	if err != nil {
 		return nil, "", nil, err
 	}
+	if !ready {
+		pageToken, err := bag.Marshal()
+		return nil, pageToken, nil, err
+	}
 
-	pageToken, err := bag.NextToken(nextCursor)
-	if err != nil {
+	rt := c.routes.all[index]
+	if rt.forbidden(ctx, resourceTypeUser.Id, nil) {
+		pageToken, annos, err := rt.skipPage(bag, resourceTypeUser.Id)
+		return nil, pageToken, annos, err
+	}
+
+	if err := c.activity.load(ctx); err != nil {
 		return nil, "", nil, err
 	}
 
+	// the page is streamed, each user becomes a resource as it's decoded.
 	var rv []*v2.Resource
-	for _, user := range users {
-		userCopy := user
-		ur, err := userResource(&userCopy, parentId)
+	nextCursor, err := eachListed(ctx, c, index, userList, page, func(user sentinelone.User) error {
+		keep, err := keepPrincipal(ctx, c, index, user, userRoles, c.scopes.filterUsers)
+		if err != nil || !keep {
+			return err
+		}
+
+		r, err := userResource(c, &user, parentId)
 		if err != nil {
-			return nil, "", nil, err
+			return err
 		}
-		rv = append(rv, ur)
+		rv = append(rv, r)
+
+		return nil
+	})
+	if err != nil {
+		if rt.forbidden(ctx, resourceTypeUser.Id, err) {
+			pageToken, annos, err := rt.skipPage(bag, resourceTypeUser.Id)
+			return nil, pageToken, annos, err
+		}
+		return nil, "", nil, err
+	}
+
+	pageToken, err := bag.NextToken(nextCursor)
+	if err != nil {
+		return nil, "", nil, err
 	}
 
 	return rv, pageToken, nil, nil
 }
 
-func (u *userResourceType) Entitlements(_ context.Context, _ *v2.Resource, _ *pagination.Token) ([]*v2.Entitlement, string, annotations.Annotations, error) {
-	return nil, "", nil, nil
+func (u *userResourceType) Entitlements(_ context.Context, resource *v2.Resource, _ *pagination.Token) ([]*v2.Entitlement, string, annotations.Annotations, error) {
+	rv := []*v2.Entitlement{
+		ent.NewPermissionEntitlement(
+			resource,
+			apiTokenEntitlement,
+			ent.WithGrantableTo(resourceTypeUser),
+			ent.WithDisplayName(fmt.Sprintf("%s personal API token", resource.DisplayName)),
+			ent.WithDescription(fmt.Sprintf("Personal API token of %s in SentinelOne", resource.DisplayName)),
+		),
+		ent.NewPermissionEntitlement(
+			resource,
+			twoFactorRequiredEntitlement,
+			ent.WithGrantableTo(resourceTypeUser),
+			ent.WithDisplayName(fmt.Sprintf("%s two-factor authentication required", resource.DisplayName)),
+			ent.WithDescription(fmt.Sprintf("SentinelOne requires two-factor authentication for %s", resource.DisplayName)),
+		),
+		ent.NewPermissionEntitlement(
+			resource,
+			twoFactorEnrollmentEntitlement,
+			ent.WithGrantableTo(resourceTypeUser),
+			ent.WithDisplayName(fmt.Sprintf("%s two-factor enrollment", resource.DisplayName)),
+			ent.WithDescription(fmt.Sprintf("%s has enrolled a two-factor authentication device in SentinelOne", resource.DisplayName)),
+		),
+	}
+
+	return rv, "", nil, nil
+}
+
+// Grants returns the grants of the user to itself: the personal API token and the two-factor authentication state.
+func (u *userResourceType) Grants(_ context.Context, resource *v2.Resource, _ *pagination.Token) ([]*v2.Grant, string, annotations.Annotations, error) {
+	userTrait, err := rs.GetUserTrait(resource)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	var rv []*v2.Grant
+	if _, ok := rs.GetProfileStringValue(userTrait.Profile, apiTokenCreatedAtProfileKey); ok {
+		rv = append(rv, grant.NewGrant(resource, apiTokenEntitlement, resource.Id))
+	}
+
+	if getProfileBoolValue(userTrait.Profile, twoFactorEnabledProfileKey) {
+		rv = append(rv, grant.NewGrant(resource, twoFactorRequiredEntitlement, resource.Id))
+	}
+
+	if status, _ := rs.GetProfileStringValue(userTrait.Profile, twoFactorStatusProfileKey); status == sentinelone.TwoFaStatusConfigured {
+		rv = append(rv, grant.NewGrant(resource, twoFactorEnrollmentEntitlement, resource.Id))
+	}
+
+	return rv, "", nil, nil
+}
+
+// Grant requires two-factor authentication for the user.
+// Personal API tokens and two-factor enrollments can only be created by the user in the console.
+func (u *userResourceType) Grant(ctx context.Context, principal *v2.Resource, entitlement *v2.Entitlement) (annotations.Annotations, error) {
+	if entitlement.Slug != twoFactorRequiredEntitlement {
+		return nil, fmt.Errorf("entitlement %s can only be granted by the user in SentinelOne console", entitlement.Id)
+	}
+
+	if err := ensureSelf(principal.GetId(), entitlement); err != nil {
+		return nil, err
+	}
+
+	targetIDs := []string{entitlement.Resource.Id.Resource}
+	client, ids, err := u.prepare(ctx, enableTwoFactorOperation, targetIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	affected, err := client.EnableTwoFactor(ctx, sentinelone.Filter{IDs: ids})
+	if err != nil {
+		err = fmt.Errorf("failed to require two-factor authentication for user %s: %w", ids[0], err)
+	}
+
+	return provisioningResult(ctx, enableTwoFactorOperation, resourceTypeUser.Id, targetIDs, affected, err)
 }
 
-func (u *userResourceType) Grants(_ context.Context, _ *v2.Resource, _ *pagination.Token) ([]*v2.Grant, string, annotations.Annotations, error) {
-	return nil, "", nil, nil
+// Revoke revokes the personal API token, stops requiring two-factor authentication or resets the two-factor enrollment of the user.
+// The user itself is kept.
+func (u *userResourceType) Revoke(ctx context.Context, userGrant *v2.Grant) (annotations.Annotations, error) {
+	entitlement := userGrant.Entitlement
+	operation, ok := userRevokeOperations[entitlement.Slug]
+	if !ok {
+		return nil, fmt.Errorf("unsupported entitlement %s for user", entitlement.Id)
+	}
+
+	if err := ensureSelf(userGrant.Principal.GetId(), entitlement); err != nil {
+		return nil, err
+	}
+
+	targetIDs := []string{entitlement.Resource.Id.Resource}
+	client, ids, err := u.prepare(ctx, operation, targetIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	var affected int
+	switch operation {
+	case revokeAPITokenOperation:
+		err = client.RevokeAPIToken(ctx, ids[0])
+		if err == nil {
+			affected = 1
+		}
+	case disableTwoFactorOperation:
+		affected, err = client.DisableTwoFactor(ctx, sentinelone.Filter{IDs: ids})
+	case resetTwoFactorOperation:
+		affected, err = client.ResetTwoFactor(ctx, sentinelone.Filter{IDs: ids})
+	}
+	if err != nil {
+		err = fmt.Errorf("failed to revoke %s of user %s: %w", entitlement.Slug, ids[0], err)
+	}
+
+	return provisioningResult(ctx, operation, resourceTypeUser.Id, targetIDs, affected, err)
+}
+
+// ensureSelf returns an error unless the principal is the user the entitlement belongs to,
+// the api token and two-factor state of a user are only ever granted to the user itself.
+func ensureSelf(principal *v2.ResourceId, entitlement *v2.Entitlement) error {
+	user := entitlement.GetResource().GetId()
+	if principal.GetResourceType() != user.GetResourceType() || principal.GetResource() != user.GetResource() {
+		return fmt.Errorf("entitlement %s belongs to user %s, not to %s %s", entitlement.GetId(), user.GetResource(), principal.GetResourceType(), principal.GetResource())
+	}
+
+	return nil
+}
+
+// prepare resolves the console, the api token and the targeted users and checks the operation against the guardrails of the console.
+func (u *userResourceType) prepare(ctx context.Context, operation string, targetIDs []string) (*sentinelone.Client, []string, error) {
+	c, ids, err := u.consoles.resolveAll(targetIDs)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	targets, client, err := resolveUsers(ctx, c.routes, ids)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return client, ids, c.guardrails.check(ctx, operation, targets)
 }
 
-func userBuilder(client *sentinelone.Client) *userResourceType {
+func userBuilder(consoles *consoleSet, types resourceTypeSet) *userResourceType {
 	return &userResourceType{
 		resourceType: resourceTypeUser,
-		client:       client,
+		consoles:     consoles,
+		types:        types,
 	}
 }
@@ -0,0 +1,118 @@
+package connector
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/conductorone/baton-sentinel-one/pkg/sentinelone"
+)
+
+func TestRevokeAPIToken(t *testing.T) {
+	f := newFakeConsole(t)
+	f.users = []sentinelone.User{{ID: "u1", Scope: scopeTenant}}
+	s := f.newConnector(t, WithGuardrails(Guardrails{}))
+
+	_, err := userBuilder(s.consoles, s.types).Revoke(context.Background(), userGrant(t, s, "u1", apiTokenEntitlement, "u1"))
+	if err != nil {
+		t.Fatalf("Revoke() error = %v", err)
+	}
+
+	sent := f.sent(http.MethodPost, "users/revoke-api-token")
+	if len(sent) != 1 {
+		t.Fatalf("sent %d revoke requests, want 1", len(sent))
+	}
+	if want := `{"data":{"id":"u1"}}`; sent[0].Body != want {
+		t.Errorf("body = %s, want %s", sent[0].Body, want)
+	}
+}
+
+// TestRevokeOtherPrincipal checks that the api token of a user is never revoked for a grant to someone else.
+func TestRevokeOtherPrincipal(t *testing.T) {
+	f := newFakeConsole(t)
+	f.users = []sentinelone.User{{ID: "u1", Scope: scopeTenant}, {ID: "u2", Scope: scopeTenant}}
+	s := f.newConnector(t, WithGuardrails(Guardrails{}))
+
+	_, err := userBuilder(s.consoles, s.types).Revoke(context.Background(), userGrant(t, s, "u1", apiTokenEntitlement, "u2"))
+	if err == nil {
+		t.Fatal("expected an error for a grant to another principal")
+	}
+
+	if writes := f.writes(); len(writes) != 0 {
+		t.Errorf("sent %v, want no write", writes)
+	}
+}
+
+func TestTwoFactor(t *testing.T) {
+	tests := []struct {
+		name  string
+		slug  string
+		grant bool
+		path  string
+	}{
+		{name: "require", slug: twoFactorRequiredEntitlement, grant: true, path: "users/2fa/enable"},
+		{name: "stop requiring", slug: twoFactorRequiredEntitlement, path: "users/2fa/disable"},
+		{name: "reset enrollment", slug: twoFactorEnrollmentEntitlement, path: "users/2fa/reset"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeConsole(t)
+			f.users = []sentinelone.User{{ID: "u1", Scope: scopeTenant}}
+			s := f.newConnector(t, WithGuardrails(Guardrails{}))
+			builder := userBuilder(s.consoles, s.types)
+			g := userGrant(t, s, "u1", tt.slug, "u1")
+
+			var err error
+			if tt.grant {
+				_, err = builder.Grant(context.Background(), g.Principal, g.Entitlement)
+			} else {
+				_, err = builder.Revoke(context.Background(), g)
+			}
+			if err != nil {
+				t.Fatalf("error = %v", err)
+			}
+
+			writes := f.writes()
+			if len(writes) != 1 || writes[0].Path != tt.path {
+				t.Fatalf("sent %v, want POST %s", writes, tt.path)
+			}
+			if want := `{"filter":{"ids":["u1"]}}`; writes[0].Body != want {
+				t.Errorf("body = %s, want %s", writes[0].Body, want)
+			}
+		})
+	}
+}
+
+func TestGrantTwoFactorToOtherPrincipal(t *testing.T) {
+	f := newFakeConsole(t)
+	f.users = []sentinelone.User{{ID: "u1", Scope: scopeTenant}, {ID: "u2", Scope: scopeTenant}}
+	s := f.newConnector(t, WithGuardrails(Guardrails{}))
+	g := userGrant(t, s, "u1", twoFactorRequiredEntitlement, "u2")
+
+	if _, err := userBuilder(s.consoles, s.types).Grant(context.Background(), g.Principal, g.Entitlement); err == nil {
+		t.Fatal("expected an error for a grant to another principal")
+	}
+
+	if writes := f.writes(); len(writes) != 0 {
+		t.Errorf("sent %v, want no write", writes)
+	}
+}
+
+// TestUserRevokeOperations checks that every entitlement of a user can be revoked.
+func TestUserRevokeOperations(t *testing.T) {
+	f := newFakeConsole(t)
+	s := f.newConnector(t)
+	resource := userGrant(t, s, "u1", apiTokenEntitlement, "u1").Entitlement.Resource
+
+	entitlements, _, _, err := userBuilder(s.consoles, s.types).Entitlements(context.Background(), resource, nil)
+	if err != nil {
+		t.Fatalf("Entitlements() error = %v", err)
+	}
+
+	for _, e := range entitlements {
+		if _, ok := userRevokeOperations[e.Slug]; !ok {
+			t.Errorf("entitlement %s has no revoke operation", e.Slug)
+		}
+	}
+}
@@ -1,17 +1,51 @@
 package sentinelone
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/ratelimit"
 )
 
 type Client struct {
 	httpClient *http.Client
-	token      string
-	baseUrl    string
+	// token holds the api token as a string, it is swapped when the token is rotated.
+	token       atomic.Value
+	reloadToken func() (string, error)
+	baseUrl     string
+	dryRun      bool
+	limiter     ratelimit.Limiter
+	metrics     *metrics
+	tracer      trace.Tracer
+	schema      SchemaRecorder
+}
+
+type ClientOption func(*Client)
+
+// WithDryRun makes the client return a DryRunError with the planned request instead of sending mutations.
+// Reads are still sent so the inputs of a mutation can be validated.
+func WithDryRun(dryRun bool) ClientOption {
+	return func(c *Client) {
+		c.dryRun = dryRun
+	}
+}
+
+// WithTokenReload makes the client reload the api token and retry once when a request is rejected with 401 Unauthorized.
+func WithTokenReload(reload func() (string, error)) ClientOption {
+	return func(c *Client) {
+		c.reloadToken = reload
+	}
 }
 
 type ParamsMap map[string]string
@@ -30,7 +64,7 @@ type Error struct {
 }
 
 type ErrorResponse struct {
-	Errors []Error `json:"errors"`
+	Errors []Error `json:"errors,omitempty"`
 }
 
 type Response[T any] struct {
@@ -39,20 +73,109 @@ type Response[T any] struct {
 	Data []T `json:"data"`
 }
 
+type SingleResponse[T any] struct {
+	ErrorResponse
+	Data T `json:"data"`
+}
+
+// Filter narrows down the records affected by a bulk operation.
+type Filter struct {
+	IDs []string `json:"ids,omitempty"`
+}
+
+type FilterRequest struct {
+	Filter Filter `json:"filter"`
+}
+
+type DataRequest[T any] struct {
+	Data T `json:"data"`
+}
+
+type IDRequest struct {
+	ID string `json:"id"`
+}
+
+type AffectedResponse struct {
+	Affected int `json:"affected"`
+}
+
+type SuccessResponse struct {
+	Success bool `json:"success"`
+}
+
 const (
-	usersEndpoint        = "users"
-	serviceUsersEndpoint = "service-users"
-	accountsEndpoint     = "accounts"
-	sitesEndpoint        = "sites"
-	rolesEndpoint        = "rbac/roles"
+	usersEndpoint         = "users"
+	serviceUsersEndpoint  = "service-users"
+	accountsEndpoint      = "accounts"
+	sitesEndpoint         = "sites"
+	rolesEndpoint         = "rbac/roles"
+	currentUserEndpoint   = "user"
+	systemInfoEndpoint    = "system/info"
+	activitiesEndpoint    = "activities"
+	activityTypesEndpoint = "activities/types"
+
+	revokeAPITokenEndpoint   = "users/revoke-api-token"
+	enableTwoFactorEndpoint  = "users/2fa/enable"
+	disableTwoFactorEndpoint = "users/2fa/disable"
+	resetTwoFactorEndpoint   = "users/2fa/reset"
 )
 
-func NewClient(httpClient *http.Client, baseUrl, token string) *Client {
-	return &Client{
+// Endpoint is a request the client sends, its path is relative to the API and "{id}" stands for the id of an object.
+type Endpoint struct {
+	Method string
+	Path   string
+}
+
+// WriteEndpoints returns the endpoints of the requests that change the management console.
+func WriteEndpoints() []Endpoint {
+	return []Endpoint{
+		{Method: http.MethodPost, Path: revokeAPITokenEndpoint},
+		{Method: http.MethodPost, Path: enableTwoFactorEndpoint},
+		{Method: http.MethodPost, Path: disableTwoFactorEndpoint},
+		{Method: http.MethodPost, Path: resetTwoFactorEndpoint},
+	}
+}
+
+// WithRateLimit limits the requests the client sends per second, every client has its own limit.
+// A limit of 0 disables rate limiting.
+func WithRateLimit(requestsPerSecond int) ClientOption {
+	return func(c *Client) {
+		if requestsPerSecond > 0 {
+			c.limiter = ratelimit.New(requestsPerSecond)
+		}
+	}
+}
+
+func NewClient(httpClient *http.Client, baseUrl, token string, opts ...ClientOption) *Client {
+	c := &Client{
 		httpClient: httpClient,
-		token:      token,
 		baseUrl:    baseUrl,
+		limiter:    ratelimit.NewUnlimited(),
+	}
+	c.token.Store(token)
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	if c.metrics == nil {
+		c.metrics = newMetrics(nil)
+	}
+
+	if c.tracer == nil {
+		c.tracer = defaultTracer()
 	}
+
+	return c
+}
+
+// SetToken replaces the api token used by the following requests.
+func (c *Client) SetToken(token string) {
+	c.token.Store(token)
+}
+
+func (c *Client) currentToken() string {
+	return c.token.Load().(string)
 }
 
 // GetUsers returns a list of all users.
@@ -63,7 +186,7 @@ func (c *Client) GetUsers(ctx context.Context, params ParamsMap) ([]User, string
 	}
 
 	var res Response[User]
-	if err := c.doRequest(ctx, fmt.Sprint(c.baseUrl, usersEndpoint), &res, queryParams); err != nil {
+	if err := c.doRequest(ctx, http.MethodGet, fmt.Sprint(c.baseUrl, usersEndpoint), &res, queryParams, nil); err != nil {
 		return nil, "", err
 	}
 
@@ -86,7 +209,7 @@ func (c *Client) GetServiceUsers(ctx context.Context, params ParamsMap) ([]Servi
 	}
 
 	var res Response[ServiceUser]
-	if err := c.doRequest(ctx, fmt.Sprint(c.baseUrl, serviceUsersEndpoint), &res, queryParams); err != nil {
+	if err := c.doRequest(ctx, http.MethodGet, fmt.Sprint(c.baseUrl, serviceUsersEndpoint), &res, queryParams, nil); err != nil {
 		return nil, "", err
 	}
 
@@ -109,7 +232,7 @@ func (c *Client) GetAccounts(ctx context.Context, params ParamsMap) ([]Account,
 	}
 
 	var res Response[Account]
-	if err := c.doRequest(ctx, fmt.Sprint(c.baseUrl, accountsEndpoint), &res, queryParams); err != nil {
+	if err := c.doRequest(ctx, http.MethodGet, fmt.Sprint(c.baseUrl, accountsEndpoint), &res, queryParams, nil); err != nil {
 		return nil, "", err
 	}
 
@@ -124,6 +247,15 @@ func (c *Client) GetAccounts(ctx context.Context, params ParamsMap) ([]Account,
 	return res.Data, "", nil
 }
 
+// sitesResponse is the list of sites, nested in the data unlike the other lists.
+type sitesResponse struct {
+	PaginationResponse
+	ErrorResponse
+	Data struct {
+		Sites []Site `json:"sites"`
+	} `json:"data"`
+}
+
 // GetSites returns a list of all sites.
 func (c *Client) GetSites(ctx context.Context, params ParamsMap) ([]Site, string, error) {
 	var queryParams url.Values
@@ -131,14 +263,8 @@ func (c *Client) GetSites(ctx context.Context, params ParamsMap) ([]Site, string
 		queryParams = createParams(params)
 	}
 
-	var res struct {
-		PaginationResponse
-		ErrorResponse
-		Data struct {
-			Sites []Site `json:"sites"`
-		} `json:"data"`
-	}
-	if err := c.doRequest(ctx, fmt.Sprint(c.baseUrl, sitesEndpoint), &res, queryParams); err != nil {
+	var res sitesResponse
+	if err := c.doRequest(ctx, http.MethodGet, fmt.Sprint(c.baseUrl, sitesEndpoint), &res, queryParams, nil); err != nil {
 		return nil, "", err
 	}
 
@@ -161,7 +287,7 @@ func (c *Client) GetPredefinedRoles(ctx context.Context, params ParamsMap) ([]Ro
 	}
 
 	var res Response[Role]
-	if err := c.doRequest(ctx, fmt.Sprint(c.baseUrl, rolesEndpoint), &res, queryParams); err != nil {
+	if err := c.doRequest(ctx, http.MethodGet, fmt.Sprint(c.baseUrl, rolesEndpoint), &res, queryParams, nil); err != nil {
 		return nil, "", err
 	}
 
@@ -176,6 +302,165 @@ func (c *Client) GetPredefinedRoles(ctx context.Context, params ParamsMap) ([]Ro
 	return res.Data, "", nil
 }
 
+// GetCurrentUser returns the user or service user that owns the API token.
+func (c *Client) GetCurrentUser(ctx context.Context) (*User, error) {
+	var res SingleResponse[User]
+	if err := c.doRequest(ctx, http.MethodGet, fmt.Sprint(c.baseUrl, currentUserEndpoint), &res, nil, nil); err != nil {
+		return nil, err
+	}
+
+	if res.ErrorResponse.Errors != nil {
+		return nil, fmt.Errorf("failed to get current user: %v", res.ErrorResponse.Errors)
+	}
+
+	return &res.Data, nil
+}
+
+// GetSystemInfo returns the version of the management console.
+func (c *Client) GetSystemInfo(ctx context.Context) (*SystemInfo, error) {
+	var res SingleResponse[SystemInfo]
+	if err := c.doRequest(ctx, http.MethodGet, fmt.Sprint(c.baseUrl, systemInfoEndpoint), &res, nil, nil); err != nil {
+		return nil, err
+	}
+
+	if res.ErrorResponse.Errors != nil {
+		return nil, fmt.Errorf("failed to get system info: %v", res.ErrorResponse.Errors)
+	}
+
+	return &res.Data, nil
+}
+
+// GetActivities returns a page of the activities log.
+func (c *Client) GetActivities(ctx context.Context, params ParamsMap) ([]Activity, string, error) {
+	var queryParams url.Values
+	if params != nil {
+		queryParams = createParams(params)
+	}
+
+	var res Response[Activity]
+	if err := c.doRequest(ctx, http.MethodGet, fmt.Sprint(c.baseUrl, activitiesEndpoint), &res, queryParams, nil); err != nil {
+		return nil, "", err
+	}
+
+	if res.ErrorResponse.Errors != nil {
+		return nil, "", fmt.Errorf("failed to get activities: %v", res.ErrorResponse.Errors)
+	}
+
+	return res.Data, res.Pagination.NextCursor, nil
+}
+
+// GetActivityTypes returns the types of the entries of the activities log.
+func (c *Client) GetActivityTypes(ctx context.Context) ([]ActivityType, error) {
+	var res Response[ActivityType]
+	if err := c.doRequest(ctx, http.MethodGet, fmt.Sprint(c.baseUrl, activityTypesEndpoint), &res, nil, nil); err != nil {
+		return nil, err
+	}
+
+	if res.ErrorResponse.Errors != nil {
+		return nil, fmt.Errorf("failed to get activity types: %v", res.ErrorResponse.Errors)
+	}
+
+	return res.Data, nil
+}
+
+// CountUsers returns the total number of users.
+func (c *Client) CountUsers(ctx context.Context) (int, error) {
+	return c.count(ctx, usersEndpoint)
+}
+
+// CountServiceUsers returns the total number of service users.
+func (c *Client) CountServiceUsers(ctx context.Context) (int, error) {
+	return c.count(ctx, serviceUsersEndpoint)
+}
+
+// CountAccounts returns the total number of accounts.
+func (c *Client) CountAccounts(ctx context.Context) (int, error) {
+	return c.count(ctx, accountsEndpoint)
+}
+
+// CountSites returns the total number of sites.
+func (c *Client) CountSites(ctx context.Context) (int, error) {
+	return c.count(ctx, sitesEndpoint)
+}
+
+// CountPredefinedRoles returns the total number of predefined roles.
+func (c *Client) CountPredefinedRoles(ctx context.Context) (int, error) {
+	return c.count(ctx, rolesEndpoint)
+}
+
+// count asks the endpoint for the total number of items only, skipping the items themselves.
+func (c *Client) count(ctx context.Context, endpoint string) (int, error) {
+	queryParams := url.Values{}
+	queryParams.Add("countOnly", "true")
+
+	var res struct {
+		PaginationResponse
+		ErrorResponse
+	}
+	if err := c.doRequest(ctx, http.MethodGet, fmt.Sprint(c.baseUrl, endpoint), &res, queryParams, nil); err != nil {
+		return 0, err
+	}
+
+	if res.ErrorResponse.Errors != nil {
+		return 0, fmt.Errorf("failed to count %s: %v", endpoint, res.ErrorResponse.Errors)
+	}
+
+	return res.Pagination.TotalItems, nil
+}
+
+// RevokeAPIToken revokes the personal API token of a console user, the user itself is kept.
+func (c *Client) RevokeAPIToken(ctx context.Context, userID string) error {
+	var res SingleResponse[SuccessResponse]
+	body := DataRequest[IDRequest]{Data: IDRequest{ID: userID}}
+	if err := c.doRequest(ctx, http.MethodPost, fmt.Sprint(c.baseUrl, revokeAPITokenEndpoint), &res, nil, body); err != nil {
+		return err
+	}
+
+	if res.ErrorResponse.Errors != nil {
+		return fmt.Errorf("failed to revoke api token of user %s: %v", userID, res.ErrorResponse.Errors)
+	}
+
+	if !res.Data.Success {
+		return fmt.Errorf("failed to revoke api token of user %s", userID)
+	}
+
+	return nil
+}
+
+// EnableTwoFactor requires two-factor authentication for all users matching the filter.
+func (c *Client) EnableTwoFactor(ctx context.Context, filter Filter) (int, error) {
+	return c.filterAction(ctx, enableTwoFactorEndpoint, filter)
+}
+
+// DisableTwoFactor stops requiring two-factor authentication for all users matching the filter.
+func (c *Client) DisableTwoFactor(ctx context.Context, filter Filter) (int, error) {
+	return c.filterAction(ctx, disableTwoFactorEndpoint, filter)
+}
+
+// ResetTwoFactor clears the two-factor enrollment of all users matching the filter, they have to enroll again on next login.
+func (c *Client) ResetTwoFactor(ctx context.Context, filter Filter) (int, error) {
+	return c.filterAction(ctx, resetTwoFactorEndpoint, filter)
+}
+
+// filterAction calls a bulk endpoint that accepts a filter and returns the number of affected records.
+func (c *Client) filterAction(ctx context.Context, endpoint string, filter Filter) (int, error) {
+	// an empty filter would match every record in the console.
+	if len(filter.IDs) == 0 {
+		return 0, fmt.Errorf("refusing to call %s without an ids filter", endpoint)
+	}
+
+	var res SingleResponse[AffectedResponse]
+	if err := c.doRequest(ctx, http.MethodPost, fmt.Sprint(c.baseUrl, endpoint), &res, nil, FilterRequest{Filter: filter}); err != nil {
+		return 0, err
+	}
+
+	if res.ErrorResponse.Errors != nil {
+		return 0, fmt.Errorf("failed to call %s: %v", endpoint, res.ErrorResponse.Errors)
+	}
+
+	return res.Data.Affected, nil
+}
+
 func createParams(params ParamsMap) url.Values {
 	urlParams := url.Values{}
 
@@ -189,10 +474,70 @@ func createParams(params ParamsMap) url.Values {
 	return urlParams
 }
 
-func (c *Client) doRequest(ctx context.Context, url string, res interface{}, queryParams url.Values) error {
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+func (c *Client) doRequest(ctx context.Context, method, url string, res interface{}, queryParams url.Values, body interface{}) error {
+	ctx, span := c.startRequestSpan(ctx, method, c.endpoint(url), queryParams)
+	err := c.do(ctx, method, url, res, queryParams, body)
+	endRequestSpan(span, res, err)
+
+	return err
+}
+
+func (c *Client) do(ctx context.Context, method, url string, res interface{}, queryParams url.Values, body interface{}) error {
+	var payload []byte
+	if body != nil {
+		var err error
+		payload, err = json.Marshal(body)
+		if err != nil {
+			return err
+		}
+	}
+
+	if c.dryRun && method != http.MethodGet {
+		req, err := c.newRequest(ctx, c.currentToken(), method, url, queryParams, payload)
+		if err != nil {
+			return err
+		}
+
+		return &DryRunError{
+			Request: PlannedRequest{
+				Method: method,
+				Path:   req.URL.RequestURI(),
+				Body:   payload,
+			},
+		}
+	}
+
+	sent := c.currentToken()
+	err := c.send(ctx, sent, method, url, res, queryParams, payload)
+
+	// a rotated token is re-read once, the request is only retried when the token differs from the one it was sent with.
+	// The reload may already have handed the new token to the client.
+	var apiErr *APIError
+	if c.reloadToken != nil && errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusUnauthorized {
+		token, reloadErr := c.reloadToken()
+		if reloadErr != nil {
+			return fmt.Errorf("%w, failed to reload api token: %v", err, reloadErr)
+		}
+
+		if token != sent {
+			c.SetToken(token)
+			c.metrics.retry(ctx, c.endpoint(url), "token_reloaded")
+			return c.send(ctx, token, method, url, res, queryParams, payload)
+		}
+	}
+
+	return err
+}
+
+func (c *Client) newRequest(ctx context.Context, token, method, url string, queryParams url.Values, payload []byte) (*http.Request, error) {
+	var reqBody io.Reader
+	if payload != nil {
+		reqBody = bytes.NewReader(payload)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, reqBody)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	if queryParams != nil {
@@ -200,17 +545,103 @@ func (c *Client) doRequest(ctx context.Context, url string, res interface{}, que
 	}
 
 	req.Header.Add("Accept", "application/json")
-	req.Header.Add("Authorization", fmt.Sprintf("ApiToken %s", c.token))
+	if payload != nil {
+		req.Header.Add("Content-Type", "application/json")
+	}
+	req.Header.Add("Authorization", fmt.Sprintf("ApiToken %s", token))
+
+	return req, nil
+}
+
+func (c *Client) send(ctx context.Context, token, method, url string, res interface{}, queryParams url.Values, payload []byte) error {
+	req, err := c.newRequest(ctx, token, method, url, queryParams, payload)
+	if err != nil {
+		return err
+	}
+
+	endpoint := c.endpoint(url)
+
+	if reason, ok := ctx.Value(retryReasonKey{}).(string); ok {
+		c.metrics.retry(ctx, endpoint, reason)
+	}
+
+	waitStart := time.Now()
+	c.limiter.Take()
+	c.metrics.waited(ctx, time.Since(waitStart))
+
+	start := time.Now()
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
+		c.metrics.request(ctx, endpoint, method, 0, time.Since(start))
 		return err
 	}
 
 	defer resp.Body.Close()
+	c.metrics.request(ctx, endpoint, method, resp.StatusCode, time.Since(start))
+	trace.SpanFromContext(ctx).SetAttributes(attribute.Int("http.status_code", resp.StatusCode))
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		var errRes ErrorResponse
+		// the error details are optional, the status is enough to tell what happened.
+		_ = json.NewDecoder(resp.Body).Decode(&errRes)
+		return &APIError{
+			StatusCode: resp.StatusCode,
+			Errors:     errRes.Errors,
+		}
+	}
+
+	body := &countingReader{r: resp.Body}
+	if c.schema != nil {
+		return c.decodeStrict(ctx, endpoint, body, res)
+	}
 
-	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+	if stream, ok := res.(streamDecoder); ok {
+		err = stream.decodeStream(body)
+	} else {
+		err = json.NewDecoder(body).Decode(&res)
+	}
+	c.metrics.decoded(ctx, endpoint, body.n)
+	if err != nil {
 		return err
 	}
 
 	return nil
 }
+
+// decodeStrict decodes the response like send does, after handing its differences from the model to the schema recorder.
+func (c *Client) decodeStrict(ctx context.Context, endpoint string, body *countingReader, res interface{}) error {
+	data, err := io.ReadAll(body)
+	c.metrics.decoded(ctx, endpoint, body.n)
+	if err != nil {
+		return err
+	}
+
+	stream, isStream := res.(streamDecoder)
+	model := res
+	if isStream {
+		model = stream.model()
+	}
+
+	for _, drift := range checkSchema(endpoint, data, model) {
+		c.schema.RecordSchemaDrift(ctx, drift)
+	}
+
+	if isStream {
+		return stream.decodeStream(bytes.NewReader(data))
+	}
+
+	return json.Unmarshal(data, res)
+}
+
+// endpoint returns the path of the url relative to the API, e.g. "users", used to label the metrics.
+// Object ids in the path are replaced by "{id}", so every object doesn't get its own label.
+func (c *Client) endpoint(url string) string {
+	segments := strings.Split(strings.TrimPrefix(url, c.baseUrl), "/")
+	for i, segment := range segments {
+		if segment != "" && strings.Trim(segment, "0123456789") == "" {
+			segments[i] = "{id}"
+		}
+	}
+
+	return strings.Join(segments, "/")
+}
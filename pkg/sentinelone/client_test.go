@@ -0,0 +1,267 @@
+package sentinelone
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const testAPIPath = "/web/api/v2.1/"
+
+// recordedRequest is a request the test server got, its path is relative to the API.
+type recordedRequest struct {
+	Method string
+	Path   string
+	Query  string
+	Header http.Header
+	Body   string
+}
+
+// testServer answers every request with the response, or with the status when it isn't 200, and records the requests.
+type testServer struct {
+	srv *httptest.Server
+
+	mtx      sync.Mutex
+	status   int
+	response string
+	requests []recordedRequest
+}
+
+func newTestServer(t testing.TB, response string) *testServer {
+	s := &testServer{
+		status:   http.StatusOK,
+		response: response,
+	}
+	s.srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		s.mtx.Lock()
+		defer s.mtx.Unlock()
+
+		s.requests = append(s.requests, recordedRequest{
+			Method: r.Method,
+			Path:   strings.TrimPrefix(r.URL.Path, testAPIPath),
+			Query:  r.URL.RawQuery,
+			Header: r.Header.Clone(),
+			Body:   string(body),
+		})
+
+		w.WriteHeader(s.status)
+		_, _ = io.WriteString(w, s.response)
+	}))
+	t.Cleanup(s.srv.Close)
+
+	return s
+}
+
+func (s *testServer) baseURL() string {
+	return s.srv.URL + testAPIPath
+}
+
+func (s *testServer) client(opts ...ClientOption) *Client {
+	return NewClient(s.srv.Client(), s.baseURL(), "secret-token", opts...)
+}
+
+func (s *testServer) setStatus(status int) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.status = status
+}
+
+func (s *testServer) received() []recordedRequest {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	return append([]recordedRequest(nil), s.requests...)
+}
+
+func TestFilterActions(t *testing.T) {
+	tests := []struct {
+		name string
+		call func(*Client, context.Context, Filter) (int, error)
+		path string
+	}{
+		{name: "enable two-factor", call: (*Client).EnableTwoFactor, path: "users/2fa/enable"},
+		{name: "disable two-factor", call: (*Client).DisableTwoFactor, path: "users/2fa/disable"},
+		{name: "reset two-factor", call: (*Client).ResetTwoFactor, path: "users/2fa/reset"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, `{"data":{"affected":2}}`)
+
+			affected, err := tt.call(s.client(), context.Background(), Filter{IDs: []string{"101", "102"}})
+			if err != nil {
+				t.Fatalf("error = %v", err)
+			}
+			if affected != 2 {
+				t.Errorf("affected = %d, want 2", affected)
+			}
+
+			requests := s.received()
+			if len(requests) != 1 {
+				t.Fatalf("sent %d requests, want 1", len(requests))
+			}
+			r := requests[0]
+			if r.Method != http.MethodPost || r.Path != tt.path {
+				t.Errorf("sent %s %s, want POST %s", r.Method, r.Path, tt.path)
+			}
+			if want := `{"filter":{"ids":["101","102"]}}`; r.Body != want {
+				t.Errorf("body = %s, want %s", r.Body, want)
+			}
+		})
+	}
+}
+
+// TestFilterActionRequiresIDs checks that a bulk action is never sent without ids, the console would apply it to everyone.
+func TestFilterActionRequiresIDs(t *testing.T) {
+	for name, call := range map[string]func(*Client, context.Context, Filter) (int, error){
+		"enable two-factor":  (*Client).EnableTwoFactor,
+		"disable two-factor": (*Client).DisableTwoFactor,
+		"reset two-factor":   (*Client).ResetTwoFactor,
+	} {
+		t.Run(name, func(t *testing.T) {
+			s := newTestServer(t, `{"data":{"affected":100}}`)
+
+			if _, err := call(s.client(), context.Background(), Filter{}); err == nil {
+				t.Fatal("expected an error for an empty ids filter")
+			}
+			if requests := s.received(); len(requests) != 0 {
+				t.Errorf("sent %d requests, want none", len(requests))
+			}
+		})
+	}
+}
+
+// TestWriteEndpoints checks that every write of the client is sent to one of the write endpoints.
+func TestWriteEndpoints(t *testing.T) {
+	s := newTestServer(t, `{"data":{"success":true,"affected":1}}`)
+	c := s.client()
+	ctx := context.Background()
+	filter := Filter{IDs: []string{"101"}}
+
+	_ = c.RevokeAPIToken(ctx, "101")
+	_, _ = c.EnableTwoFactor(ctx, filter)
+	_, _ = c.DisableTwoFactor(ctx, filter)
+	_, _ = c.ResetTwoFactor(ctx, filter)
+
+	endpoints := make(map[Endpoint]struct{})
+	for _, e := range WriteEndpoints() {
+		endpoints[e] = struct{}{}
+	}
+
+	requests := s.received()
+	if len(requests) != len(endpoints) {
+		t.Fatalf("sent %d requests, want %d", len(requests), len(endpoints))
+	}
+	for _, r := range requests {
+		e := Endpoint{Method: r.Method, Path: c.endpoint(s.baseURL() + r.Path)}
+		if _, ok := endpoints[e]; !ok {
+			t.Errorf("%s %s is not a write endpoint", e.Method, e.Path)
+		}
+		delete(endpoints, e)
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	s := newTestServer(t, `{"data":[],"pagination":{"totalItems":0}}`)
+	c := s.client(WithDryRun(true))
+
+	_, err := c.ResetTwoFactor(context.Background(), Filter{IDs: []string{"101"}})
+
+	var dryRunErr *DryRunError
+	if !errors.As(err, &dryRunErr) {
+		t.Fatalf("error = %v, want a DryRunError", err)
+	}
+	planned := dryRunErr.Request
+	if planned.Method != http.MethodPost || planned.Path != testAPIPath+"users/2fa/reset" {
+		t.Errorf("planned %s %s, want POST %susers/2fa/reset", planned.Method, planned.Path, testAPIPath)
+	}
+	if want := `{"filter":{"ids":["101"]}}`; string(planned.Body) != want {
+		t.Errorf("planned body = %s, want %s", planned.Body, want)
+	}
+	if requests := s.received(); len(requests) != 0 {
+		t.Errorf("sent %v in dry run", requests)
+	}
+
+	// reads are still sent.
+	if _, _, err := c.GetUsers(context.Background(), nil); err != nil {
+		t.Fatalf("GetUsers() error = %v", err)
+	}
+	if requests := s.received(); len(requests) != 1 || requests[0].Method != http.MethodGet {
+		t.Errorf("sent %v, want the read", requests)
+	}
+}
+
+func TestTokenReload(t *testing.T) {
+	tests := []struct {
+		name       string
+		reloaded   string
+		reloadErr  error
+		wantErr    bool
+		wantTokens []string
+	}{
+		{name: "rotated token", reloaded: "rotated-token", wantTokens: []string{"secret-token", "rotated-token"}},
+		{name: "same token", reloaded: "secret-token", wantErr: true, wantTokens: []string{"secret-token"}},
+		{name: "reload failed", reloadErr: errors.New("no such file"), wantErr: true, wantTokens: []string{"secret-token"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, `{"data":{"id":"1"}}`)
+			s.setStatus(http.StatusUnauthorized)
+
+			reloads := 0
+			c := s.client(WithTokenReload(func() (string, error) {
+				reloads++
+				s.setStatus(http.StatusOK)
+				return tt.reloaded, tt.reloadErr
+			}))
+
+			_, err := c.GetCurrentUser(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("GetCurrentUser() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if reloads != 1 {
+				t.Errorf("reloaded %d times, want 1", reloads)
+			}
+
+			var tokens []string
+			for _, r := range s.received() {
+				tokens = append(tokens, strings.TrimPrefix(r.Header.Get("Authorization"), "ApiToken "))
+			}
+			if strings.Join(tokens, ",") != strings.Join(tt.wantTokens, ",") {
+				t.Errorf("sent tokens %v, want %v", tokens, tt.wantTokens)
+			}
+		})
+	}
+}
+
+func TestEndpoint(t *testing.T) {
+	c := NewClient(http.DefaultClient, "https://acme.sentinelone.net/web/api/v2.1/", "secret-token")
+
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{url: "https://acme.sentinelone.net/web/api/v2.1/users", want: "users"},
+		{url: "https://acme.sentinelone.net/web/api/v2.1/users/2fa/reset", want: "users/2fa/reset"},
+		{url: "https://acme.sentinelone.net/web/api/v2.1/users/1234567890", want: "users/{id}"},
+		{url: "https://acme.sentinelone.net/web/api/v2.1/service-users/42", want: "service-users/{id}"},
+		{url: "https://acme.sentinelone.net/web/api/v2.1/accounts/12/sites/34", want: "accounts/{id}/sites/{id}"},
+		{url: "https://acme.sentinelone.net/web/api/v2.1/users/u1", want: "users/u1"},
+		{url: "https://acme.sentinelone.net/web/api/v2.1/", want: ""},
+	}
+
+	for _, tt := range tests {
+		if got := c.endpoint(tt.url); got != tt.want {
+			t.Errorf("endpoint(%q) = %q, want %q", tt.url, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,22 @@
+package sentinelone
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// PlannedRequest is a mutation the client would have sent to the management console.
+type PlannedRequest struct {
+	Method string          `json:"method"`
+	Path   string          `json:"path"`
+	Body   json.RawMessage `json:"body,omitempty"`
+}
+
+// DryRunError is returned instead of sending a mutation when the client runs in dry-run mode.
+type DryRunError struct {
+	Request PlannedRequest
+}
+
+func (e *DryRunError) Error() string {
+	return fmt.Sprintf("dry run: %s %s was not sent", e.Request.Method, e.Request.Path)
+}
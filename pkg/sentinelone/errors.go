@@ -0,0 +1,23 @@
+package sentinelone
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// APIError is returned when the management console answers with an error status.
+type APIError struct {
+	StatusCode int
+	Errors     []Error
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("request failed with status %d: %v", e.StatusCode, e.Errors)
+}
+
+// IsForbidden reports whether the api token isn't allowed to use the endpoint.
+func IsForbidden(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusForbidden
+}
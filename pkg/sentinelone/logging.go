@@ -0,0 +1,194 @@
+package sentinelone
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+	"go.uber.org/zap"
+)
+
+const (
+	redacted = "[REDACTED]"
+	// maxLoggedBody is the number of bytes of a request body written to the log.
+	maxLoggedBody = 2048
+)
+
+var (
+	emailPattern = regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`)
+
+	// piiFields are the query parameters and body fields holding emails or names.
+	piiFields = map[string]struct{}{
+		"email":     {},
+		"emails":    {},
+		"name":      {},
+		"names":     {},
+		"fullName":  {},
+		"firstName": {},
+		"lastName":  {},
+		"query":     {},
+	}
+)
+
+// LoggingTransport logs the requests sent to the management console at debug level without the api token.
+// With hashPII, emails and names in the logged urls and bodies are replaced by a short hash, so requests can still be correlated.
+type LoggingTransport struct {
+	next    http.RoundTripper
+	hashPII bool
+}
+
+// NewLoggingTransport wraps next with debug logging of every request.
+func NewLoggingTransport(next http.RoundTripper, hashPII bool) *LoggingTransport {
+	return &LoggingTransport{
+		next:    next,
+		hashPII: hashPII,
+	}
+}
+
+func (t *LoggingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	l := ctxzap.Extract(req.Context())
+	if !l.Core().Enabled(zap.DebugLevel) {
+		return t.next.RoundTrip(req)
+	}
+
+	token := apiToken(req.Header.Get("Authorization"))
+	fields := []zap.Field{
+		zap.String("http.method", req.Method),
+		zap.String("http.url_details.host", req.URL.Host),
+		zap.String("http.url_details.path", t.redact(req.URL.Path, token)),
+		zap.String("http.url_details.query", t.redactQuery(req.URL.Query(), token)),
+		zap.String("http.authorization", RedactAuthorization(req.Header.Get("Authorization"))),
+	}
+
+	if req.GetBody != nil {
+		if body, err := req.GetBody(); err == nil {
+			data, _ := io.ReadAll(io.LimitReader(body, maxLoggedBody))
+			body.Close()
+			fields = append(fields, zap.String("http.request.body", t.redactBody(data, token)))
+		}
+	}
+
+	l.Debug("Request started", fields...)
+
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+
+	fields = append(fields, zap.Duration("http.duration", time.Since(start)))
+	if err != nil {
+		// url errors repeat the full url, query included.
+		fields = append(fields, zap.String("error", t.redact(err.Error(), token)))
+	}
+	if resp != nil {
+		fields = append(fields, zap.Int("http.status_code", resp.StatusCode))
+	}
+
+	l.Debug("Request complete", fields...)
+
+	return resp, err
+}
+
+// RedactAuthorization keeps the scheme of an Authorization header and masks the credentials.
+func RedactAuthorization(value string) string {
+	if value == "" {
+		return ""
+	}
+
+	scheme, _, ok := strings.Cut(value, " ")
+	if !ok {
+		return redacted
+	}
+
+	return scheme + " " + redacted
+}
+
+func apiToken(authorization string) string {
+	_, token, _ := strings.Cut(authorization, " ")
+	return token
+}
+
+// redact masks the api token, and the emails when hashing is enabled.
+func (t *LoggingTransport) redact(s, token string) string {
+	if token != "" {
+		s = strings.ReplaceAll(s, token, redacted)
+	}
+
+	if t.hashPII {
+		s = emailPattern.ReplaceAllStringFunc(s, hashPII)
+	}
+
+	return s
+}
+
+func (t *LoggingTransport) redactQuery(query url.Values, token string) string {
+	for key, values := range query {
+		for i, value := range values {
+			if t.hashPII && isPIIField(key) {
+				values[i] = hashPII(value)
+				continue
+			}
+			values[i] = t.redact(value, token)
+		}
+	}
+
+	return query.Encode()
+}
+
+func (t *LoggingTransport) redactBody(data []byte, token string) string {
+	var body interface{}
+	if err := json.Unmarshal(data, &body); err != nil {
+		return t.redact(string(data), token)
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(t.redactJSON("", body, token)); err != nil {
+		return redacted
+	}
+
+	return strings.TrimSpace(buf.String())
+}
+
+func (t *LoggingTransport) redactJSON(key string, value interface{}, token string) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for k, field := range v {
+			v[k] = t.redactJSON(k, field, token)
+		}
+		return v
+	case []interface{}:
+		for i, item := range v {
+			v[i] = t.redactJSON(key, item, token)
+		}
+		return v
+	case string:
+		if t.hashPII && isPIIField(key) {
+			return hashPII(v)
+		}
+		return t.redact(v, token)
+	default:
+		return v
+	}
+}
+
+// isPIIField tells whether the query parameter or body field holds emails or names. Filters name the field before the
+// operator, e.g. "email__contains" or "fullName__like".
+func isPIIField(key string) bool {
+	field, _, _ := strings.Cut(key, "__")
+	_, ok := piiFields[field]
+	return ok
+}
+
+// hashPII replaces a value by a short stable hash, equal values still log the same.
+func hashPII(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return "sha256:" + hex.EncodeToString(sum[:])[:12]
+}
@@ -0,0 +1,225 @@
+package sentinelone
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+const loggedToken = "s3cr3t-t0ken"
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// logRequest sends the request through the logging transport and returns the logged entries.
+func logRequest(t *testing.T, hashPII bool, req *http.Request, next roundTripFunc) []observer.LoggedEntry {
+	core, logs := observer.New(zapcore.DebugLevel)
+	req = req.WithContext(ctxzap.ToContext(context.Background(), zap.New(core)))
+
+	resp, _ := NewLoggingTransport(next, hashPII).RoundTrip(req)
+	if resp != nil {
+		resp.Body.Close()
+	}
+
+	entries := logs.All()
+	if len(entries) != 2 {
+		t.Fatalf("logged %d entries, want the start and the end of the request", len(entries))
+	}
+
+	return entries
+}
+
+func newLoggedRequest(t *testing.T, rawURL, body string) *http.Request {
+	req, err := http.NewRequest(http.MethodPost, rawURL, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "ApiToken "+loggedToken)
+
+	return req
+}
+
+func logged(entries []observer.LoggedEntry) string {
+	var sb strings.Builder
+	for _, entry := range entries {
+		sb.WriteString(entry.Message)
+		for key, value := range entry.ContextMap() {
+			fmt.Fprintf(&sb, " %s=%v", key, value)
+		}
+		sb.WriteString("\n")
+	}
+
+	return sb.String()
+}
+
+func respondOK(*http.Request) (*http.Response, error) {
+	return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+}
+
+func TestLoggingTransportRedactsToken(t *testing.T) {
+	tests := []struct {
+		name string
+		url  string
+		body string
+		next roundTripFunc
+	}{
+		{name: "header", url: "https://acme.sentinelone.net/web/api/v2.1/users", next: respondOK},
+		{name: "query", url: "https://acme.sentinelone.net/web/api/v2.1/users?apiToken=" + loggedToken, next: respondOK},
+		{name: "path", url: "https://acme.sentinelone.net/web/api/v2.1/users/" + loggedToken, next: respondOK},
+		{name: "body", url: "https://acme.sentinelone.net/web/api/v2.1/users", body: `{"data":{"apiToken":"` + loggedToken + `"}}`, next: respondOK},
+		{name: "body that isn't json", url: "https://acme.sentinelone.net/web/api/v2.1/users", body: "token=" + loggedToken, next: respondOK},
+		{
+			name: "url error",
+			url:  "https://acme.sentinelone.net/web/api/v2.1/users?apiToken=" + loggedToken,
+			next: func(req *http.Request) (*http.Response, error) {
+				return nil, &url.Error{Op: "Post", URL: req.URL.String(), Err: errors.New("connection refused")}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := logged(logRequest(t, false, newLoggedRequest(t, tt.url, tt.body), tt.next))
+
+			if strings.Contains(got, loggedToken) {
+				t.Errorf("token logged:\n%s", got)
+			}
+			if !strings.Contains(got, "ApiToken "+redacted) {
+				t.Errorf("authorization scheme not logged:\n%s", got)
+			}
+			if strings.Count(got, redacted) < 2 {
+				t.Errorf("%s not logged in place of the token:\n%s", redacted, got)
+			}
+		})
+	}
+}
+
+func TestLoggingTransportHashesPII(t *testing.T) {
+	const (
+		email = "jane.doe@acme.com"
+		name  = "Jane Doe"
+	)
+	rawURL := "https://acme.sentinelone.net/web/api/v2.1/users?email=" + url.QueryEscape(email) + "&query=" + url.QueryEscape(name)
+	body := `{"data":{"fullName":"` + name + `","description":"owned by ` + email + `","names":["` + name + `"]}}`
+
+	for _, hash := range []bool{true, false} {
+		t.Run(fmt.Sprintf("hash %v", hash), func(t *testing.T) {
+			got := logged(logRequest(t, hash, newLoggedRequest(t, rawURL, body), respondOK))
+
+			for _, value := range []string{email, name, url.QueryEscape(email)} {
+				if strings.Contains(got, value) == hash {
+					t.Errorf("%q logged = %v, want %v:\n%s", value, !hash, !hash, got)
+				}
+			}
+			for _, value := range []string{email, name} {
+				if strings.Contains(got, hashPII(value)) != hash {
+					t.Errorf("hash of %q logged = %v, want %v:\n%s", value, !hash, hash, got)
+				}
+			}
+		})
+	}
+}
+
+func TestLoggingTransportHashesFilteredPII(t *testing.T) {
+	const (
+		email = "jane.doe@acme.com"
+		name  = "Jane Doe"
+	)
+
+	tests := []struct {
+		name   string
+		rawURL string
+		body   string
+	}{
+		{
+			name:   "query filters",
+			rawURL: "https://acme.sentinelone.net/web/api/v2.1/users?email__contains=" + url.QueryEscape(email) + "&fullName__like=" + url.QueryEscape(name),
+		},
+		{
+			name:   "body filters",
+			rawURL: "https://acme.sentinelone.net/web/api/v2.1/users/2fa/reset",
+			body:   `{"filter":{"email__contains":"` + email + `","fullName__like":["` + name + `"]}}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := logged(logRequest(t, true, newLoggedRequest(t, tt.rawURL, tt.body), respondOK))
+
+			for _, value := range []string{email, name, url.QueryEscape(email), url.QueryEscape(name)} {
+				if strings.Contains(got, value) {
+					t.Errorf("%q logged:\n%s", value, got)
+				}
+			}
+			for _, value := range []string{email, name} {
+				// the hashes of the query are escaped.
+				if !strings.Contains(got, hashPII(value)) && !strings.Contains(got, url.QueryEscape(hashPII(value))) {
+					t.Errorf("hash of %q not logged:\n%s", value, got)
+				}
+			}
+		})
+	}
+}
+
+func TestIsPIIField(t *testing.T) {
+	tests := map[string]bool{
+		"email":            true,
+		"email__contains":  true,
+		"fullName__like":   true,
+		"names":            true,
+		"query":            true,
+		"ids":              false,
+		"accountIds":       false,
+		"emailVerified":    false,
+		"name__":           true,
+		"description__has": false,
+	}
+
+	for key, want := range tests {
+		if got := isPIIField(key); got != want {
+			t.Errorf("isPIIField(%q) = %v, want %v", key, got, want)
+		}
+	}
+}
+
+func TestLoggingTransportDebugOnly(t *testing.T) {
+	core, logs := observer.New(zapcore.InfoLevel)
+	req := newLoggedRequest(t, "https://acme.sentinelone.net/web/api/v2.1/users", "")
+	req = req.WithContext(ctxzap.ToContext(context.Background(), zap.New(core)))
+
+	resp, err := NewLoggingTransport(roundTripFunc(respondOK), true).RoundTrip(req)
+	if err != nil {
+		t.Fatalf("RoundTrip() error = %v", err)
+	}
+	resp.Body.Close()
+
+	if logs.Len() != 0 {
+		t.Errorf("logged %d entries above debug level", logs.Len())
+	}
+}
+
+func TestRedactAuthorization(t *testing.T) {
+	tests := map[string]string{
+		"":                        "",
+		"ApiToken " + loggedToken: "ApiToken " + redacted,
+		loggedToken:               redacted,
+	}
+
+	for value, want := range tests {
+		if got := RedactAuthorization(value); got != want {
+			t.Errorf("RedactAuthorization(%q) = %q, want %q", value, got, want)
+		}
+	}
+}
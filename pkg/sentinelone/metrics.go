@@ -0,0 +1,126 @@
+package sentinelone
+
+import (
+	"context"
+	"io"
+	"strconv"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/metric/global"
+	"go.opentelemetry.io/otel/metric/instrument"
+)
+
+// InstrumentationName is the name of the meter recording the API usage.
+const InstrumentationName = "github.com/conductorone/baton-sentinel-one/pkg/sentinelone"
+
+// WithMeterProvider records the API usage of the client with the given provider instead of the global one.
+func WithMeterProvider(provider metric.MeterProvider) ClientOption {
+	return func(c *Client) {
+		c.metrics = newMetrics(provider)
+	}
+}
+
+type retryReasonKey struct{}
+
+// WithRetryReason marks the requests sent with the context as retries of failed ones, counted by reason.
+func WithRetryReason(ctx context.Context, reason string) context.Context {
+	return context.WithValue(ctx, retryReasonKey{}, reason)
+}
+
+// metrics are the instruments recording how hard the client hits the management console.
+// Instruments that fail to be created are left out, the metrics are never a reason to fail a request.
+type metrics struct {
+	requests      instrument.Int64Counter
+	duration      instrument.Float64Histogram
+	bytes         instrument.Int64Counter
+	retries       instrument.Int64Counter
+	rateLimitWait instrument.Float64Histogram
+}
+
+func newMetrics(provider metric.MeterProvider) *metrics {
+	if provider == nil {
+		provider = global.MeterProvider()
+	}
+	meter := provider.Meter(InstrumentationName)
+
+	rv := &metrics{}
+	rv.requests, _ = meter.Int64Counter("sentinelone.api.requests",
+		instrument.WithDescription("Requests sent to the management console, by endpoint, method and status"),
+	)
+	rv.duration, _ = meter.Float64Histogram("sentinelone.api.request.duration",
+		instrument.WithDescription("Latency of the requests sent to the management console"),
+		instrument.WithUnit("s"),
+	)
+	rv.bytes, _ = meter.Int64Counter("sentinelone.api.response.size",
+		instrument.WithDescription("Bytes of response bodies decoded"),
+		instrument.WithUnit("By"),
+	)
+	rv.retries, _ = meter.Int64Counter("sentinelone.api.retries",
+		instrument.WithDescription("Requests sent again, by endpoint and reason"),
+	)
+	rv.rateLimitWait, _ = meter.Float64Histogram("sentinelone.api.rate_limit.wait",
+		instrument.WithDescription("Time requests waited for the client rate limit"),
+		instrument.WithUnit("s"),
+	)
+
+	return rv
+}
+
+// request records a finished request, status is 0 when no response was received.
+func (m *metrics) request(ctx context.Context, endpoint, method string, status int, elapsed time.Duration) {
+	statusLabel := "error"
+	if status > 0 {
+		statusLabel = strconv.Itoa(status)
+	}
+
+	if m.requests != nil {
+		m.requests.Add(ctx, 1,
+			attribute.String("endpoint", endpoint),
+			attribute.String("method", method),
+			attribute.String("status", statusLabel),
+		)
+	}
+
+	if m.duration != nil {
+		m.duration.Record(ctx, elapsed.Seconds(),
+			attribute.String("endpoint", endpoint),
+			attribute.String("method", method),
+		)
+	}
+}
+
+func (m *metrics) decoded(ctx context.Context, endpoint string, n int64) {
+	if m.bytes != nil {
+		m.bytes.Add(ctx, n, attribute.String("endpoint", endpoint))
+	}
+}
+
+// retry records a request sent again, e.g. after a server error or a rotated token.
+func (m *metrics) retry(ctx context.Context, endpoint, reason string) {
+	if m.retries != nil {
+		m.retries.Add(ctx, 1,
+			attribute.String("endpoint", endpoint),
+			attribute.String("reason", reason),
+		)
+	}
+}
+
+func (m *metrics) waited(ctx context.Context, elapsed time.Duration) {
+	if m.rateLimitWait != nil {
+		m.rateLimitWait.Record(ctx, elapsed.Seconds())
+	}
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
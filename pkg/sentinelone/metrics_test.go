@@ -0,0 +1,111 @@
+package sentinelone
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+// collected returns the value of every data point by instrument and attributes, e.g. "endpoint=users,method=GET".
+// The value of a histogram data point is its count.
+func collected(t *testing.T, reader sdkmetric.Reader) map[string]map[string]int64 {
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatalf("Collect() error = %v", err)
+	}
+
+	rv := make(map[string]map[string]int64)
+	for _, sm := range rm.ScopeMetrics {
+		if sm.Scope.Name != InstrumentationName {
+			continue
+		}
+
+		for _, m := range sm.Metrics {
+			points := make(map[string]int64)
+			switch data := m.Data.(type) {
+			case metricdata.Sum[int64]:
+				for _, dp := range data.DataPoints {
+					points[attributesKey(dp.Attributes)] = dp.Value
+				}
+			case metricdata.Histogram:
+				for _, dp := range data.DataPoints {
+					points[attributesKey(dp.Attributes)] = int64(dp.Count)
+				}
+			default:
+				t.Fatalf("unexpected data %T of %s", m.Data, m.Name)
+			}
+			rv[m.Name] = points
+		}
+	}
+
+	return rv
+}
+
+// attributesKey joins the attributes, which the set keeps sorted by key.
+func attributesKey(set attribute.Set) string {
+	var kvs []string
+	for _, kv := range set.ToSlice() {
+		kvs = append(kvs, string(kv.Key)+"="+kv.Value.Emit())
+	}
+
+	return strings.Join(kvs, ",")
+}
+
+func TestMetrics(t *testing.T) {
+	const body = `{"data": [], "pagination": {"nextCursor": ""}}`
+
+	srv := newTestServer(t, body)
+	reader := sdkmetric.NewManualReader()
+	provider := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+	client := srv.client(WithMeterProvider(provider))
+	ctx := context.Background()
+
+	if _, _, err := client.GetUsers(ctx, nil); err != nil {
+		t.Fatalf("GetUsers() error = %v", err)
+	}
+	if _, _, err := client.GetUsers(WithRetryReason(ctx, "server_error"), nil); err != nil {
+		t.Fatalf("GetUsers() error = %v", err)
+	}
+	srv.setStatus(http.StatusTooManyRequests)
+	if _, _, err := client.GetServiceUsers(ctx, nil); err == nil {
+		t.Fatal("expected the rate limited request to fail")
+	}
+
+	// no response is received from a closed server.
+	srv.srv.Close()
+	if _, _, err := client.GetUsers(ctx, nil); err == nil {
+		t.Fatal("expected the request to a closed server to fail")
+	}
+
+	want := map[string]map[string]int64{
+		"sentinelone.api.requests": {
+			"endpoint=users,method=GET,status=200":         2,
+			"endpoint=users,method=GET,status=error":       1,
+			"endpoint=service-users,method=GET,status=429": 1,
+		},
+		"sentinelone.api.request.duration": {
+			"endpoint=users,method=GET":         3,
+			"endpoint=service-users,method=GET": 1,
+		},
+		// only the decoded bodies are counted.
+		"sentinelone.api.response.size": {
+			"endpoint=users": int64(2 * len(body)),
+		},
+		"sentinelone.api.retries": {
+			"endpoint=users,reason=server_error": 1,
+		},
+		// every request waits for the rate limit, even when it doesn't have to.
+		"sentinelone.api.rate_limit.wait": {
+			"": 4,
+		},
+	}
+	if got := collected(t, reader); !reflect.DeepEqual(got, want) {
+		t.Errorf("collected %v, want %v", got, want)
+	}
+}
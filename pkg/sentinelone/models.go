@@ -1,11 +1,29 @@
 package sentinelone
 
 type User struct {
-	Email      string `json:"email"`
-	Scope      string `json:"scope"`
-	ID         string `json:"id"`
-	ScopeRoles []Role `json:"scopeRoles"`
-	FullName   string `json:"fullName"`
+	Email        string    `json:"email"`
+	Scope        string    `json:"scope"`
+	ID           string    `json:"id"`
+	ScopeRoles   []Role    `json:"scopeRoles"`
+	FullName     string    `json:"fullName"`
+	APIToken     *APIToken `json:"apiToken,omitempty"`
+	TwoFaEnabled bool      `json:"twoFaEnabled"`
+	TwoFaStatus  string    `json:"twoFaStatus"`
+}
+
+// TwoFaStatusConfigured is the two-factor status of a user that finished the enrollment.
+const TwoFaStatusConfigured = "configured"
+
+// Personal API token of a console user, nil when the user has not generated one.
+type APIToken struct {
+	CreatedAt string `json:"createdAt"`
+	ExpiresAt string `json:"expiresAt"`
+}
+
+// Version of the management console.
+type SystemInfo struct {
+	Build   string `json:"build"`
+	Release string `json:"release"`
 }
 
 type ServiceUser struct {
@@ -29,6 +47,31 @@ type Site struct {
 	Name        string `json:"name"`
 	SiteType    string `json:"siteType"`
 	AccountID   string `json:"accountId"`
+	AccountName string `json:"accountName"`
+}
+
+// Entry of the activities log of the console, e.g. a login.
+type Activity struct {
+	ID           string `json:"id"`
+	ActivityType int    `json:"activityType"`
+	CreatedAt    string `json:"createdAt"`
+	// UserID is the user or service user that acted, empty for the activities of the console itself.
+	UserID      string `json:"userId"`
+	AccountID   string `json:"accountId"`
+	AccountName string `json:"accountName"`
+	SiteID      string `json:"siteId"`
+	SiteName    string `json:"siteName"`
+	// PrimaryDescription is the activity as shown in the console, e.g. "John changed the role of Jane to Viewer".
+	PrimaryDescription string `json:"primaryDescription"`
+	// Data holds the details of the activity, its keys depend on the activity type.
+	Data map[string]interface{} `json:"data"`
+}
+
+// Type of the entries of the activities log, the ids differ between console releases.
+type ActivityType struct {
+	ID                  int    `json:"id"`
+	Action              string `json:"action"`
+	DescriptionTemplate string `json:"descriptionTemplate"`
 }
 
 // Combination of predefined role and scope role.
@@ -38,4 +81,6 @@ type Role struct {
 	RoleID      string `json:"roleId,omitempty"`
 	ID          string `json:"id"`
 	Name        string `json:"name"`
+	// PredefinedRole is false for the custom roles, only listed roles have it.
+	PredefinedRole bool `json:"predefinedRole,omitempty"`
 }
@@ -0,0 +1,217 @@
+package sentinelone
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+const (
+	// DriftUnknownField is a field of a response the model doesn't know.
+	DriftUnknownField = "unknown_field"
+	// DriftMissingField is a field of the model, without omitempty, that a response left out.
+	DriftMissingField = "missing_field"
+	// DriftTypeMismatch is a field of a response whose JSON type doesn't fit the model.
+	DriftTypeMismatch = "type_mismatch"
+)
+
+var unmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+
+// SchemaDrift is a difference between a response and the model it is decoded into, e.g. after a console release.
+type SchemaDrift struct {
+	Endpoint string `json:"endpoint"`
+	Kind     string `json:"kind"`
+	// Path is the field in the response, e.g. "data[].scopeRoles[].roleName".
+	Path string `json:"path"`
+	// Expected and Actual are the JSON types of a type mismatch.
+	Expected string `json:"expected,omitempty"`
+	Actual   string `json:"actual,omitempty"`
+}
+
+// SchemaRecorder receives the drift found in the responses decoded by a client.
+type SchemaRecorder interface {
+	RecordSchemaDrift(ctx context.Context, drift SchemaDrift)
+}
+
+// WithSchemaRecorder makes the client compare every response to its model and hand the differences to the recorder.
+// The responses are still decoded as before, a type mismatch fails the request.
+func WithSchemaRecorder(recorder SchemaRecorder) ClientOption {
+	return func(c *Client) {
+		c.schema = recorder
+	}
+}
+
+// checkSchema compares a response with the model it is decoded into, each field is reported once whatever the number of items.
+func checkSchema(endpoint string, data []byte, res interface{}) []SchemaDrift {
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return nil
+	}
+
+	w := &schemaWalker{
+		endpoint: endpoint,
+		seen:     make(map[string]struct{}),
+	}
+	w.walk("", value, reflect.TypeOf(res))
+
+	return w.drift
+}
+
+type schemaWalker struct {
+	endpoint string
+	seen     map[string]struct{}
+	drift    []SchemaDrift
+}
+
+func (w *schemaWalker) add(kind, path, expected, actual string) {
+	key := kind + "/" + path
+	if _, ok := w.seen[key]; ok {
+		return
+	}
+	w.seen[key] = struct{}{}
+
+	w.drift = append(w.drift, SchemaDrift{
+		Endpoint: w.endpoint,
+		Kind:     kind,
+		Path:     path,
+		Expected: expected,
+		Actual:   actual,
+	})
+}
+
+func (w *schemaWalker) walk(path string, value interface{}, t reflect.Type) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	// null fits every field, and custom decoders and interfaces accept any shape.
+	if value == nil || t.Kind() == reflect.Interface || reflect.PointerTo(t).Implements(unmarshalerType) {
+		return
+	}
+
+	expected := jsonType(t)
+	if actual := jsonValueType(value); actual != expected {
+		w.add(DriftTypeMismatch, path, expected, actual)
+		return
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		obj := value.(map[string]interface{})
+		fields := jsonFields(t)
+		for _, name := range sortedKeys(fields) {
+			field := fields[name]
+			fieldValue, ok := obj[name]
+			if !ok {
+				if !field.optional {
+					w.add(DriftMissingField, joinPath(path, name), "", "")
+				}
+				continue
+			}
+			w.walk(joinPath(path, name), fieldValue, field.typ)
+		}
+
+		for _, name := range sortedKeys(obj) {
+			if _, ok := fields[name]; !ok {
+				w.add(DriftUnknownField, joinPath(path, name), "", "")
+			}
+		}
+	case reflect.Map:
+		for _, item := range value.(map[string]interface{}) {
+			w.walk(path+".*", item, t.Elem())
+		}
+	case reflect.Slice, reflect.Array:
+		for _, item := range value.([]interface{}) {
+			w.walk(path+"[]", item, t.Elem())
+		}
+	}
+}
+
+type jsonField struct {
+	typ      reflect.Type
+	optional bool
+}
+
+// jsonFields returns the fields of a struct by their JSON name, with the fields of embedded structs merged in like encoding/json does.
+func jsonFields(t reflect.Type) map[string]jsonField {
+	rv := make(map[string]jsonField)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+
+		name, opts, _ := strings.Cut(tag, ",")
+		if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+			for embeddedName, embedded := range jsonFields(f.Type) {
+				rv[embeddedName] = embedded
+			}
+			continue
+		}
+
+		if !f.IsExported() {
+			continue
+		}
+
+		if name == "" {
+			name = f.Name
+		}
+		rv[name] = jsonField{
+			typ:      f.Type,
+			optional: strings.Contains(opts, "omitempty"),
+		}
+	}
+
+	return rv
+}
+
+func jsonType(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Struct, reflect.Map:
+		return "object"
+	case reflect.Slice, reflect.Array:
+		return "array"
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "boolean"
+	default:
+		return "number"
+	}
+}
+
+func jsonValueType(value interface{}) string {
+	switch value.(type) {
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	default:
+		return "number"
+	}
+}
+
+func sortedKeys[T any](m map[string]T) []string {
+	rv := make([]string, 0, len(m))
+	for key := range m {
+		rv = append(rv, key)
+	}
+	sort.Strings(rv)
+
+	return rv
+}
+
+func joinPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+
+	return path + "." + name
+}
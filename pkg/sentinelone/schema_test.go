@@ -0,0 +1,216 @@
+package sentinelone
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+)
+
+type driftBase struct {
+	ID string `json:"id"`
+}
+
+type driftRole struct {
+	RoleName string `json:"roleName"`
+}
+
+// driftTimestamp decodes itself, it accepts any shape.
+type driftTimestamp struct{}
+
+func (*driftTimestamp) UnmarshalJSON([]byte) error {
+	return nil
+}
+
+type driftItem struct {
+	driftBase
+	Name      string                 `json:"name"`
+	Email     string                 `json:"email,omitempty"`
+	Active    bool                   `json:"active,omitempty"`
+	Count     *int                   `json:"count,omitempty"`
+	Roles     []driftRole            `json:"roles,omitempty"`
+	Counts    map[string]int         `json:"counts,omitempty"`
+	Extra     interface{}            `json:"extra,omitempty"`
+	Data      map[string]interface{} `json:"data,omitempty"`
+	UpdatedAt driftTimestamp         `json:"updatedAt,omitempty"`
+	Internal  string                 `json:"-"`
+	Untagged  string                 `json:",omitempty"`
+	hidden    string
+}
+
+func TestCheckSchema(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want []SchemaDrift
+	}{
+		{
+			name: "matching",
+			data: `{"id": "1", "name": "Jane", "email": "jane@acme.com", "count": 2, "roles": [{"roleName": "Admin"}], "counts": {"a": 1}}`,
+		},
+		{
+			name: "optional fields left out",
+			data: `{"id": "1", "name": "Jane"}`,
+		},
+		{
+			name: "null fits every field",
+			data: `{"id": null, "name": null, "roles": null, "counts": null}`,
+		},
+		{
+			name: "interfaces and custom decoders accept any shape",
+			data: `{"id": "1", "name": "Jane", "extra": [1, "a"], "data": {"x": [true]}, "updatedAt": 1700000000}`,
+		},
+		{
+			name: "untagged field by its go name",
+			data: `{"id": "1", "name": "Jane", "Untagged": "x"}`,
+		},
+		{
+			name: "unknown fields",
+			data: `{"id": "1", "name": "Jane", "tenant": "t1", "roles": [{"roleName": "Admin", "roleId": "r1"}], "Internal": "x", "hidden": "x"}`,
+			want: []SchemaDrift{
+				{Kind: DriftUnknownField, Path: "Internal"},
+				{Kind: DriftUnknownField, Path: "hidden"},
+				{Kind: DriftUnknownField, Path: "roles[].roleId"},
+				{Kind: DriftUnknownField, Path: "tenant"},
+			},
+		},
+		{
+			name: "missing fields",
+			data: `{"roles": [{}]}`,
+			want: []SchemaDrift{
+				{Kind: DriftMissingField, Path: "id"},
+				{Kind: DriftMissingField, Path: "name"},
+				{Kind: DriftMissingField, Path: "roles[].roleName"},
+			},
+		},
+		{
+			name: "type mismatches",
+			data: `{"id": 1, "name": "Jane", "active": "yes", "count": "2", "roles": {"roleName": "Admin"}, "counts": {"a": "1"}}`,
+			want: []SchemaDrift{
+				{Kind: DriftTypeMismatch, Path: "active", Expected: "boolean", Actual: "string"},
+				{Kind: DriftTypeMismatch, Path: "count", Expected: "number", Actual: "string"},
+				{Kind: DriftTypeMismatch, Path: "counts.*", Expected: "number", Actual: "string"},
+				{Kind: DriftTypeMismatch, Path: "id", Expected: "string", Actual: "number"},
+				{Kind: DriftTypeMismatch, Path: "roles", Expected: "array", Actual: "object"},
+			},
+		},
+		{
+			name: "field of every item reported once",
+			data: `{"id": "1", "name": "Jane", "roles": [{"roleName": 1}, {"roleName": 2}, {}, {}]}`,
+			want: []SchemaDrift{
+				{Kind: DriftMissingField, Path: "roles[].roleName"},
+				{Kind: DriftTypeMismatch, Path: "roles[].roleName", Expected: "string", Actual: "number"},
+			},
+		},
+		{
+			name: "not an object",
+			data: `["1"]`,
+			want: []SchemaDrift{
+				{Kind: DriftTypeMismatch, Path: "", Expected: "object", Actual: "array"},
+			},
+		},
+		{
+			name: "invalid json",
+			data: `{"id": `,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := checkSchema("items", []byte(tt.data), &driftItem{})
+			sortDrift(got)
+
+			for i := range tt.want {
+				tt.want[i].Endpoint = "items"
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("checkSchema() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestJSONFields(t *testing.T) {
+	fields := jsonFields(reflect.TypeOf(driftItem{}))
+
+	var names []string
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	// the fields of the embedded struct are merged in, ignored and unexported ones are left out.
+	want := []string{"Untagged", "active", "count", "counts", "data", "email", "extra", "id", "name", "roles", "updatedAt"}
+	if !reflect.DeepEqual(names, want) {
+		t.Fatalf("jsonFields() = %v, want %v", names, want)
+	}
+
+	for name, wantOptional := range map[string]bool{"id": false, "name": false, "email": true, "Untagged": true} {
+		if got := fields[name].optional; got != wantOptional {
+			t.Errorf("field %s optional = %v, want %v", name, got, wantOptional)
+		}
+	}
+}
+
+// driftRecorder keeps the drift handed to it.
+type driftRecorder struct {
+	mtx   sync.Mutex
+	drift []SchemaDrift
+}
+
+func (r *driftRecorder) RecordSchemaDrift(_ context.Context, drift SchemaDrift) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	r.drift = append(r.drift, drift)
+}
+
+// TestStrictDecodeStream checks that a streamed listing is compared with its whole response, and still decoded.
+func TestStrictDecodeStream(t *testing.T) {
+	srv := newTestServer(t, `{
+		"data": [
+			{"id": "u1", "email": "jane@acme.com", "fullName": "Jane", "scope": "account", "scopeRoles": [], "twoFaEnabled": true, "twoFaStatus": "configured", "tenant": "t1"},
+			{"id": "u2", "email": 2, "fullName": "John", "scope": "account", "scopeRoles": [], "twoFaEnabled": false, "twoFaStatus": "not_configured"}
+		],
+		"pagination": {"nextCursor": "next", "totalItems": 2},
+		"meta": {}
+	}`)
+
+	recorder := &driftRecorder{}
+	client := srv.client(WithSchemaRecorder(recorder))
+
+	var ids []string
+	next, err := client.EachUser(context.Background(), nil, func(u User) error {
+		ids = append(ids, u.ID)
+		return nil
+	})
+	// the type mismatch fails the decoding like without strict decoding, after the first user.
+	if err == nil {
+		t.Fatal("expected the type mismatch to fail the decoding")
+	}
+	if next != "" || !reflect.DeepEqual(ids, []string{"u1"}) {
+		t.Errorf("EachUser() = %q and users %v, want the users decoded before the mismatch", next, ids)
+	}
+
+	got := recorder.drift
+	sortDrift(got)
+	want := []SchemaDrift{
+		{Endpoint: usersEndpoint, Kind: DriftUnknownField, Path: "data[].tenant"},
+		{Endpoint: usersEndpoint, Kind: DriftUnknownField, Path: "meta"},
+		{Endpoint: usersEndpoint, Kind: DriftTypeMismatch, Path: "data[].email", Expected: "string", Actual: "number"},
+	}
+	sortDrift(want)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("recorded drift %+v, want %+v", got, want)
+	}
+}
+
+func sortDrift(drift []SchemaDrift) {
+	sort.Slice(drift, func(i, j int) bool {
+		if drift[i].Kind != drift[j].Kind {
+			return drift[i].Kind < drift[j].Kind
+		}
+		return drift[i].Path < drift[j].Path
+	})
+}
@@ -0,0 +1,142 @@
+package sentinelone
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+// streamDecoder is implemented by the responses decoded while they are read, instead of into memory at once.
+type streamDecoder interface {
+	decodeStream(r io.Reader) error
+	// model is the response the stream is compared with in strict decode mode.
+	model() interface{}
+}
+
+// itemStream is a list response whose items are handed to yield one by one as the data array is decoded,
+// so a page is never held in memory. The pagination and errors are kept like in Response.
+type itemStream[T any] struct {
+	PaginationResponse
+	ErrorResponse
+
+	yield func(T) error
+	count int
+}
+
+func (s *itemStream[T]) decodeStream(r io.Reader) error {
+	dec := json.NewDecoder(r)
+	if err := expectDelim(dec, '{'); err != nil {
+		return err
+	}
+
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+
+		switch tok {
+		case "data":
+			err = s.decodeItems(dec)
+		case "pagination":
+			err = dec.Decode(&s.Pagination)
+		case "errors":
+			err = dec.Decode(&s.Errors)
+		default:
+			var skip json.RawMessage
+			err = dec.Decode(&skip)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return expectDelim(dec, '}')
+}
+
+func (s *itemStream[T]) decodeItems(dec *json.Decoder) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+
+	// an empty page may come as null.
+	if tok == nil {
+		return nil
+	}
+	if tok != json.Delim('[') {
+		return fmt.Errorf("unexpected %v in data, expected an array", tok)
+	}
+
+	for dec.More() {
+		var item T
+		if err := dec.Decode(&item); err != nil {
+			return err
+		}
+
+		s.count++
+		if err := s.yield(item); err != nil {
+			return err
+		}
+	}
+
+	return expectDelim(dec, ']')
+}
+
+func (s *itemStream[T]) model() interface{} {
+	return &Response[T]{}
+}
+
+func (s *itemStream[T]) itemCount() int {
+	return s.count
+}
+
+func expectDelim(dec *json.Decoder, delim json.Delim) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+
+	if tok != delim {
+		return fmt.Errorf("unexpected %v in response, expected %v", tok, delim)
+	}
+
+	return nil
+}
+
+// EachUser calls fn with every user of a page as it is decoded, for scans that don't need the whole page.
+// It returns the cursor of the next page. When an error is returned, fn may already have seen part of the page.
+func (c *Client) EachUser(ctx context.Context, params ParamsMap, fn func(User) error) (string, error) {
+	return eachItem(ctx, c, usersEndpoint, params, fn)
+}
+
+// EachServiceUser calls fn with every service user of a page as it is decoded, like EachUser.
+func (c *Client) EachServiceUser(ctx context.Context, params ParamsMap, fn func(ServiceUser) error) (string, error) {
+	return eachItem(ctx, c, serviceUsersEndpoint, params, fn)
+}
+
+// EachActivity calls fn with every activity of a page as it is decoded, like EachUser.
+func (c *Client) EachActivity(ctx context.Context, params ParamsMap, fn func(Activity) error) (string, error) {
+	return eachItem(ctx, c, activitiesEndpoint, params, fn)
+}
+
+func eachItem[T any](ctx context.Context, c *Client, endpoint string, params ParamsMap, fn func(T) error) (string, error) {
+	var queryParams url.Values
+	if params != nil {
+		queryParams = createParams(params)
+	}
+
+	res := &itemStream[T]{yield: fn}
+	if err := c.doRequest(ctx, http.MethodGet, fmt.Sprint(c.baseUrl, endpoint), res, queryParams, nil); err != nil {
+		return "", err
+	}
+
+	if res.ErrorResponse.Errors != nil {
+		return "", fmt.Errorf("failed to get %s: %v", endpoint, res.ErrorResponse.Errors)
+	}
+
+	return res.Pagination.NextCursor, nil
+}
@@ -0,0 +1,314 @@
+package sentinelone
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDecodeStream(t *testing.T) {
+	stop := errors.New("stop")
+
+	tests := []struct {
+		name string
+		body string
+		// stopAt makes yield fail with the user of this id.
+		stopAt     string
+		wantIDs    []string
+		wantNext   string
+		wantErrors []Error
+		wantErr    error
+	}{
+		{
+			name:     "page with a cursor",
+			body:     `{"data": [{"id": "1"}, {"id": "2"}], "pagination": {"nextCursor": "c2", "totalItems": 3}}`,
+			wantIDs:  []string{"1", "2"},
+			wantNext: "c2",
+		},
+		{
+			name:    "pagination first and unknown fields",
+			body:    `{"pagination": {"nextCursor": ""}, "meta": {"x": [1, 2]}, "data": [{"id": "1", "tenant": "t1"}]}`,
+			wantIDs: []string{"1"},
+		},
+		{
+			name: "data null",
+			body: `{"data": null, "pagination": {"nextCursor": null}}`,
+		},
+		{
+			name: "empty data",
+			body: `{"data": [], "pagination": {}}`,
+		},
+		{
+			name:       "api error body",
+			body:       `{"errors": [{"code": 4000010, "detail": "invalid filter"}], "data": null}`,
+			wantErrors: []Error{{Code: 4000010, Detail: "invalid filter"}},
+		},
+		{
+			name:    "malformed json mid-array",
+			body:    `{"data": [{"id": "1"}, {"id": "2"}, {"id": }], "pagination": {"nextCursor": "c2"}}`,
+			wantIDs: []string{"1", "2"},
+			wantErr: errAny,
+		},
+		{
+			name:    "truncated body",
+			body:    `{"data": [{"id": "1"}, {"id"`,
+			wantIDs: []string{"1"},
+			wantErr: errAny,
+		},
+		{
+			name:    "data not an array",
+			body:    `{"data": {"id": "1"}}`,
+			wantErr: errAny,
+		},
+		{
+			name:    "not an object",
+			body:    `[{"id": "1"}]`,
+			wantErr: errAny,
+		},
+		{
+			name:    "yield error",
+			body:    `{"data": [{"id": "1"}, {"id": "2"}, {"id": "3"}], "pagination": {"nextCursor": "c2"}}`,
+			stopAt:  "2",
+			wantIDs: []string{"1", "2"},
+			wantErr: stop,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ids []string
+			s := &itemStream[User]{yield: func(u User) error {
+				ids = append(ids, u.ID)
+				if u.ID == tt.stopAt {
+					return stop
+				}
+				return nil
+			}}
+
+			err := s.decodeStream(strings.NewReader(tt.body))
+			switch {
+			case tt.wantErr == nil && err != nil, tt.wantErr != nil && err == nil:
+				t.Fatalf("decodeStream() error = %v, wantErr %v", err, tt.wantErr)
+			case tt.wantErr != nil && tt.wantErr != errAny && !errors.Is(err, tt.wantErr):
+				t.Fatalf("decodeStream() error = %v, want %v", err, tt.wantErr)
+			}
+
+			if !reflect.DeepEqual(ids, tt.wantIDs) {
+				t.Errorf("yielded %v, want %v", ids, tt.wantIDs)
+			}
+			if s.itemCount() != len(tt.wantIDs) {
+				t.Errorf("itemCount() = %d, want %d", s.itemCount(), len(tt.wantIDs))
+			}
+			if err != nil {
+				return
+			}
+			if s.Pagination.NextCursor != tt.wantNext {
+				t.Errorf("next cursor = %q, want %q", s.Pagination.NextCursor, tt.wantNext)
+			}
+			if !reflect.DeepEqual(s.Errors, tt.wantErrors) {
+				t.Errorf("errors = %+v, want %+v", s.Errors, tt.wantErrors)
+			}
+		})
+	}
+}
+
+// errAny is the wantErr of the cases that fail with any error.
+var errAny = errors.New("any error")
+
+// TestEachUserPages walks the pages of a listing by their cursors, and checks the errors of the API reach the caller.
+func TestEachUserPages(t *testing.T) {
+	pages := map[string]string{
+		"":   `{"data": [{"id": "1"}, {"id": "2"}], "pagination": {"nextCursor": "c2"}}`,
+		"c2": `{"data": [{"id": "3"}], "pagination": {"nextCursor": "c3"}}`,
+		"c3": `{"data": null, "pagination": {"nextCursor": null}}`,
+		"c4": `{"errors": [{"code": 4000010, "detail": "invalid cursor"}]}`,
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		page, ok := pages[r.URL.Query().Get("cursor")]
+		if !ok {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		_, _ = w.Write([]byte(page))
+	}))
+	defer srv.Close()
+
+	client := NewClient(srv.Client(), srv.URL+testAPIPath, "token")
+	ctx := context.Background()
+
+	var (
+		ids    []string
+		cursor string
+		calls  int
+	)
+	for {
+		next, err := client.EachUser(ctx, ParamsMap{"cursor": cursor}, func(u User) error {
+			ids = append(ids, u.ID)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("EachUser() error = %v", err)
+		}
+		calls++
+
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+	if want := []string{"1", "2", "3"}; !reflect.DeepEqual(ids, want) || calls != 3 {
+		t.Errorf("streamed %v in %d pages, want %v in 3", ids, calls, want)
+	}
+
+	if _, err := client.EachUser(ctx, ParamsMap{"cursor": "c4"}, func(User) error { return nil }); err == nil {
+		t.Error("expected the errors of the response to fail the listing")
+	}
+
+	var apiErr *APIError
+	if _, err := client.EachUser(ctx, ParamsMap{"cursor": "c5"}, func(User) error { return nil }); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusBadRequest {
+		t.Errorf("EachUser() error = %v, want a 400 API error", err)
+	}
+}
+
+const benchmarkUsers = 50000
+
+// usersPage returns a single page of n users with their roles and tokens, the size of a large console.
+func usersPage(b *testing.B, n int) string {
+	users := make([]User, n)
+	for i := range users {
+		users[i] = User{
+			ID:         fmt.Sprintf("%d", 1000000+i),
+			Email:      fmt.Sprintf("user%d@acme.com", i),
+			FullName:   fmt.Sprintf("User %d", i),
+			Scope:      "account",
+			ScopeRoles: []Role{{ID: "a1", Name: "Acme", RoleName: "Viewer", RoleID: "r1"}},
+			APIToken:   &APIToken{CreatedAt: "2024-01-01T00:00:00Z", ExpiresAt: "2025-01-01T00:00:00Z"},
+		}
+	}
+
+	body, err := json.Marshal(Response[User]{Data: users})
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	return string(body)
+}
+
+// heapSampler samples the heap in use while a page is decoded. The benchmarks report the peak above the heap in use
+// before the request, which the allocations per op don't tell: they add up everything allocated, freed or not.
+type heapSampler struct {
+	base uint64
+	peak uint64
+	stop chan struct{}
+	done chan struct{}
+}
+
+func sampleHeap() *heapSampler {
+	runtime.GC()
+
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+
+	s := &heapSampler{
+		base: m.HeapInuse,
+		peak: m.HeapInuse,
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+
+	go func() {
+		defer close(s.done)
+
+		ticker := time.NewTicker(100 * time.Microsecond)
+		defer ticker.Stop()
+
+		for {
+			var m runtime.MemStats
+			runtime.ReadMemStats(&m)
+			if m.HeapInuse > s.peak {
+				s.peak = m.HeapInuse
+			}
+
+			select {
+			case <-s.stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	return s
+}
+
+// peakHeap stops the sampling and returns the peak of the heap in use above the one before the request.
+func (s *heapSampler) peakHeap() uint64 {
+	close(s.stop)
+	<-s.done
+
+	return s.peak - s.base
+}
+
+// benchmarkPeakHeap runs the decoding of every op under a heap sampler, and reports the highest peak as peak-heap-B.
+func benchmarkPeakHeap(b *testing.B, decode func()) {
+	var peak uint64
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		s := sampleHeap()
+		b.StartTimer()
+
+		decode()
+
+		b.StopTimer()
+		if p := s.peakHeap(); p > peak {
+			peak = p
+		}
+		b.StartTimer()
+	}
+
+	b.ReportMetric(float64(peak), "peak-heap-B")
+}
+
+func BenchmarkEachUser(b *testing.B) {
+	client := newTestServer(b, usersPage(b, benchmarkUsers)).client()
+	ctx := context.Background()
+
+	benchmarkPeakHeap(b, func() {
+		count := 0
+		_, err := client.EachUser(ctx, nil, func(User) error {
+			count++
+			return nil
+		})
+		if err != nil {
+			b.Fatal(err)
+		}
+		if count != benchmarkUsers {
+			b.Fatalf("streamed %d users, want %d", count, benchmarkUsers)
+		}
+	})
+}
+
+func BenchmarkGetUsers(b *testing.B) {
+	client := newTestServer(b, usersPage(b, benchmarkUsers)).client()
+	ctx := context.Background()
+
+	benchmarkPeakHeap(b, func() {
+		users, _, err := client.GetUsers(ctx, nil)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if len(users) != benchmarkUsers {
+			b.Fatalf("got %d users, want %d", len(users), benchmarkUsers)
+		}
+	})
+}
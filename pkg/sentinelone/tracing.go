@@ -0,0 +1,69 @@
+package sentinelone
+
+import (
+	"context"
+	"net/url"
+	"sort"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// WithTracerProvider traces the requests of the client with the given provider instead of the global one.
+func WithTracerProvider(provider trace.TracerProvider) ClientOption {
+	return func(c *Client) {
+		if provider != nil {
+			c.tracer = provider.Tracer(InstrumentationName)
+		}
+	}
+}
+
+// itemCounter is implemented by the list responses, so their spans tell how many items a page held.
+type itemCounter interface {
+	itemCount() int
+}
+
+func (r *Response[T]) itemCount() int {
+	return len(r.Data)
+}
+
+func (r *sitesResponse) itemCount() int {
+	return len(r.Data.Sites)
+}
+
+// startRequestSpan starts the span of a request, tagged with the names of the query parameters but not their values,
+// which may hold emails and names.
+func (c *Client) startRequestSpan(ctx context.Context, method, endpoint string, queryParams url.Values) (context.Context, trace.Span) {
+	filterKeys := make([]string, 0, len(queryParams))
+	for key := range queryParams {
+		filterKeys = append(filterKeys, key)
+	}
+	sort.Strings(filterKeys)
+
+	return c.tracer.Start(ctx, method+" "+endpoint,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("http.method", method),
+			attribute.String("sentinelone.endpoint", endpoint),
+			attribute.StringSlice("sentinelone.filter_keys", filterKeys),
+		),
+	)
+}
+
+// endRequestSpan ends the span of a request with its item count or error.
+func endRequestSpan(span trace.Span, res interface{}, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	} else if counter, ok := res.(itemCounter); ok {
+		span.SetAttributes(attribute.Int("sentinelone.item_count", counter.itemCount()))
+	}
+
+	span.End()
+}
+
+func defaultTracer() trace.Tracer {
+	return otel.GetTracerProvider().Tracer(InstrumentationName)
+}